@@ -4,6 +4,8 @@ import (
 	"strconv"
 
 	"helm.sh/helm/v3/pkg/release"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 
@@ -76,6 +78,40 @@ type ChartSpec struct {
 	// Credentials: credentials for private repos
 	// +optional
 	Credentials *Credentials `json:"credentials,omitempty"`
+
+	// KeepHistory retains the release history when the release is uninstalled.
+	// +optional
+	KeepHistory bool `json:"keepHistory,omitempty"`
+
+	// Adopt brings the resources of the chart that already exist, i.e.
+	// created by another chart or by hand, under the release before
+	// installing or upgrading it.
+	// +optional
+	Adopt bool `json:"adopt,omitempty"`
+
+	// ForceAdopt lets adopt take over the resources owned by another helm
+	// release as well, they are refused otherwise. Resources locked by
+	// another KrateoPlatformOps are always refused.
+	// +optional
+	ForceAdopt bool `json:"forceAdopt,omitempty"`
+
+	// Outputs are evaluated against the installed release: its name,
+	// namespace, revision, status, notes, manifest, values and chart
+	// (name, version and appVersion).
+	// +optional
+	Outputs []*Output `json:"outputs,omitempty"`
+}
+
+// Output sets a workflow variable from the result of a step.
+type Output struct {
+	// Name of the variable.
+	Name string `json:"name"`
+	// Selector is a jq expression, objects and arrays are stored as JSON.
+	Selector string `json:"selector"`
+	// Sensitive keeps the value out of the status, i.e. for tokens: it is
+	// stored in the Secret <name>-outputs of the KrateoPlatformOps instead.
+	// +optional
+	Sensitive bool `json:"sensitive,omitempty"`
 }
 
 type ChartObservation struct {
@@ -86,23 +122,465 @@ type ChartObservation struct {
 type Object struct {
 	ObjectMeta `json:",inline"`
 	Set        []*Data `json:"set,omitempty"`
+	// Outputs are evaluated against the object returned by the apply.
+	// +optional
+	Outputs []*Output `json:"outputs,omitempty"`
 }
 
-// +kubebuilder:validation:Enum=object;chart;var
+// StepType selects the handler executing a step. Besides the types below,
+// handlers registered with steps.Register add their own.
 type StepType string
 
 const (
-	TypeObject StepType = "object"
-	TypeChart  StepType = "chart"
-	TypeVar    StepType = "var"
+	TypeObject    StepType = "object"
+	TypeChart     StepType = "chart"
+	TypeVar       StepType = "var"
+	TypeApproval  StepType = "approval"
+	TypeInclude   StepType = "include"
+	TypeWait      StepType = "wait"
+	TypeJob       StepType = "job"
+	TypePatch     StepType = "patch"
+	TypeManifest  StepType = "manifest"
+	TypeKustomize StepType = "kustomize"
+	TypeHTTP      StepType = "http"
+	TypeGenerate  StepType = "generate"
+)
+
+type ForEach struct {
+	// Items is a literal list of items.
+	// +optional
+	Items []apiextensionsv1.JSON `json:"items,omitempty"`
+	// Var is the name of a workflow variable holding a JSON array.
+	// It takes precedence over Items.
+	// +optional
+	Var string `json:"var,omitempty"`
+	// Key is a jq expression on the item, i.e. ".name", whose result
+	// names the instance <id>-<key>: an instance keeps its id when other
+	// items are added, removed or reordered. Defaults to the item index.
+	// +optional
+	Key string `json:"key,omitempty"`
+}
+
+// ApprovalSpec is the configuration of an approval step: the workflow
+// doesn't go past it until the step is approved for the current spec.
+type ApprovalSpec struct {
+	// Message is reported while the step awaits approval.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// WaitSpec is the configuration of a wait step: the workflow doesn't go
+// past it until the condition on the object is true.
+type WaitSpec struct {
+	ObjectMeta `json:",inline"`
+	// Condition is a jq expression evaluated against the object, the wait
+	// ends when its result is neither null nor false.
+	Condition string `json:"condition"`
+	// Interval between two checks of the condition. Defaults to 5s.
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+	// Timeout bounds the wait. Defaults to 5m.
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+}
+
+// JobCleanupPolicy tells whether the Job of a job step is deleted once completed.
+// +kubebuilder:validation:Enum=Delete;DeleteOnSuccess;Keep
+type JobCleanupPolicy string
+
+const (
+	// JobCleanupDelete deletes the Job whatever its outcome.
+	JobCleanupDelete JobCleanupPolicy = "Delete"
+	// JobCleanupDeleteOnSuccess keeps the failed Jobs for inspection.
+	JobCleanupDeleteOnSuccess JobCleanupPolicy = "DeleteOnSuccess"
+	// JobCleanupKeep never deletes the Job, it is pruned with the step.
+	JobCleanupKeep JobCleanupPolicy = "Keep"
+)
+
+// JobSpec is the configuration of a job step: it runs a Job and
+// waits for it to succeed or fail.
+type JobSpec struct {
+	// Metadata names the Job, the namespace defaults to the one of the workflow.
+	Metadata rtv1.Reference `json:"metadata"`
+	// Template is the pod template of the Job, restartPolicy defaults to Never.
+	Template corev1.PodTemplateSpec `json:"template"`
+	// BackoffLimit is the number of retries before the Job is marked as failed.
+	// +optional
+	BackoffLimit *int32 `json:"backoffLimit,omitempty"`
+	// Interval between two checks of the Job status. Defaults to 5s.
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+	// Timeout bounds the wait for the Job to complete. Defaults to 10m.
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+	// LogLines is the number of lines captured from the end of the pod logs.
+	// Defaults to 20.
+	// +optional
+	LogLines *int64 `json:"logLines,omitempty"`
+	// LogsVar is the name of the variable set to the captured logs.
+	// +optional
+	LogsVar string `json:"logsVar,omitempty"`
+	// CleanupPolicy defaults to DeleteOnSuccess.
+	// +optional
+	CleanupPolicy JobCleanupPolicy `json:"cleanupPolicy,omitempty"`
+}
+
+// PatchType selects how the patch of a patch step is applied.
+// +kubebuilder:validation:Enum=json;merge;strategic
+type PatchType string
+
+const (
+	// PatchJSON is an RFC6902 JSON patch, a list of operations.
+	PatchJSON PatchType = "json"
+	// PatchMerge is an RFC7386 JSON merge patch.
+	PatchMerge PatchType = "merge"
+	// PatchStrategic is a strategic merge patch, built-in kinds only.
+	PatchStrategic PatchType = "strategic"
+)
+
+// PatchSpec is the configuration of a patch step: it patches existing
+// objects, selected by name or by labels.
+type PatchSpec struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	// Metadata selects the object by name, the namespace defaults to
+	// the one of the workflow. The name is not set with Selector.
+	// +optional
+	Metadata rtv1.Reference `json:"metadata,omitempty"`
+	// Selector selects the objects by labels.
+	// +optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+	// Type of the patch. Defaults to merge.
+	// +optional
+	Type PatchType `json:"type,omitempty"`
+	// Patch is the patch document: a list of operations for json,
+	// an object for merge and strategic.
+	Patch apiextensionsv1.JSON `json:"patch"`
+	// IgnoreMissing completes the step when the object doesn't exist,
+	// or no object matches the selector, instead of failing it.
+	// +optional
+	IgnoreMissing bool `json:"ignoreMissing,omitempty"`
+}
+
+// ManifestSpec is the configuration of a manifest step: it applies the
+// objects of a multi-document YAML. Exactly one of content,
+// configMapRef, secretRef and url must be set.
+type ManifestSpec struct {
+	// Content is the inline YAML.
+	// +optional
+	Content string `json:"content,omitempty"`
+	// ConfigMapRef selects the ConfigMap key holding the YAML.
+	// +optional
+	ConfigMapRef *ManifestKeyRef `json:"configMapRef,omitempty"`
+	// SecretRef selects the Secret key holding the YAML.
+	// +optional
+	SecretRef *ManifestKeyRef `json:"secretRef,omitempty"`
+	// URL is the http(s) address of the YAML.
+	// +optional
+	URL string `json:"url,omitempty"`
+	// Credentials for the URL, sent as basic auth.
+	// +optional
+	Credentials *Credentials `json:"credentials,omitempty"`
+	// +optional
+	InsecureSkipTLSVerify *bool `json:"insecureSkipTLSVerify,omitempty"`
+}
+
+type ManifestKeyRef struct {
+	Name string `json:"name"`
+	// Namespace defaults to the namespace of the workflow.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+	Key       string `json:"key"`
+}
+
+// KustomizeSpec is the configuration of a kustomize step: it builds a
+// kustomization and applies the resulting objects. Exactly one of
+// files, configMapRef and url must be set.
+type KustomizeSpec struct {
+	// Files maps the paths of the files of the kustomization to their content.
+	// +optional
+	Files map[string]string `json:"files,omitempty"`
+	// ConfigMapRef selects a ConfigMap whose keys are the files of
+	// the kustomization.
+	// +optional
+	ConfigMapRef *KustomizeConfigMapRef `json:"configMapRef,omitempty"`
+	// URL is the http(s) address of a tarball (.tgz or .tar.gz)
+	// holding the kustomization.
+	// +optional
+	URL string `json:"url,omitempty"`
+	// Credentials for the URL, sent as basic auth.
+	// +optional
+	Credentials *Credentials `json:"credentials,omitempty"`
+	// +optional
+	InsecureSkipTLSVerify *bool `json:"insecureSkipTLSVerify,omitempty"`
+	// Path of the directory holding the kustomization.yaml, relative
+	// to the root of the files. Defaults to the root.
+	// +optional
+	Path string `json:"path,omitempty"`
+}
+
+type KustomizeConfigMapRef struct {
+	Name string `json:"name"`
+	// Namespace defaults to the namespace of the workflow.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// HTTPSpec is the configuration of an http step: it calls an endpoint
+// and stores values of the JSON response in the workflow variables.
+type HTTPSpec struct {
+	// Method of the request. Defaults to GET.
+	// +optional
+	Method string `json:"method,omitempty"`
+	// URL of the endpoint.
+	URL string `json:"url"`
+	// Headers of the request.
+	// +optional
+	Headers map[string]string `json:"headers,omitempty"`
+	// HeadersFrom selects a Secret whose keys are headers of the
+	// request, i.e. Authorization. They take precedence over Headers.
+	// +optional
+	HeadersFrom *HTTPSecretRef `json:"headersFrom,omitempty"`
+	// Body of the request.
+	// +optional
+	Body string `json:"body,omitempty"`
+	// ExpectedStatus lists the status codes completing the step.
+	// Defaults to any 2xx.
+	// +optional
+	ExpectedStatus []int `json:"expectedStatus,omitempty"`
+	// Retries is the number of attempts after the first one failed with
+	// a network error or a status worth retrying (408, 425, 429, 5xx).
+	// Defaults to 3.
+	// +optional
+	Retries *int32 `json:"retries,omitempty"`
+	// RetryInterval between two attempts. Defaults to 5s.
+	// +optional
+	RetryInterval *metav1.Duration `json:"retryInterval,omitempty"`
+	// Timeout of each attempt. Defaults to 30s.
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+	// +optional
+	InsecureSkipTLSVerify *bool `json:"insecureSkipTLSVerify,omitempty"`
+	// Outputs are evaluated against the JSON response.
+	// +optional
+	Outputs []*Output `json:"outputs,omitempty"`
+}
+
+type HTTPSecretRef struct {
+	Name string `json:"name"`
+	// Namespace defaults to the namespace of the workflow.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// GenerateType selects what a generate step generates.
+// +kubebuilder:validation:Enum=random;keypair;ca;certificate
+type GenerateType string
+
+const (
+	// GenerateRandom is a random alphanumeric string.
+	GenerateRandom GenerateType = "random"
+	// GenerateKeyPair is a private key (<name>.key) and its public key (<name>.pub).
+	GenerateKeyPair GenerateType = "keypair"
+	// GenerateCA is a self-signed CA certificate (<name>.crt) and its key (<name>.key).
+	GenerateCA GenerateType = "ca"
+	// GenerateCertificate is a certificate (<name>.crt) signed by a CA of
+	// the same step and its key (<name>.key).
+	GenerateCertificate GenerateType = "certificate"
+)
+
+// GenerateSpec is the configuration of a generate step: it creates a
+// Secret holding generated values, the existing values are kept.
+type GenerateSpec struct {
+	// Metadata names the Secret, the namespace defaults to the one of the workflow.
+	Metadata rtv1.Reference `json:"metadata"`
+	// Type of the Secret. Defaults to Opaque.
+	// +optional
+	Type corev1.SecretType `json:"type,omitempty"`
+	// Values are generated in order, the CAs before the certificates they sign.
+	Values []GeneratedValue `json:"values"`
+	// RotateEvery regenerates all the values once the Secret is older than it.
+	// +optional
+	RotateEvery *metav1.Duration `json:"rotateEvery,omitempty"`
+}
+
+type GeneratedValue struct {
+	// Name is the Secret key, or the prefix of the keys of keypairs
+	// and certificates.
+	Name string       `json:"name"`
+	Type GenerateType `json:"type"`
+	// Length of a random string. Defaults to 32.
+	// +optional
+	Length *int `json:"length,omitempty"`
+	// Algorithm of the keys: ecdsa (P-256), rsa (2048 bits) or ed25519.
+	// Defaults to ecdsa.
+	// +kubebuilder:validation:Enum=ecdsa;rsa;ed25519
+	// +optional
+	Algorithm string `json:"algorithm,omitempty"`
+	// CommonName of a certificate. Defaults to the name.
+	// +optional
+	CommonName string `json:"commonName,omitempty"`
+	// DNSNames of a certificate.
+	// +optional
+	DNSNames []string `json:"dnsNames,omitempty"`
+	// IPAddresses of a certificate.
+	// +optional
+	IPAddresses []string `json:"ipAddresses,omitempty"`
+	// CA is the name of the ca value signing a certificate.
+	// +optional
+	CA string `json:"ca,omitempty"`
+	// Validity of a certificate. Defaults to 10 years for a CA, 1 year otherwise.
+	// +optional
+	Validity *metav1.Duration `json:"validity,omitempty"`
+	// Var is the name of the variable set to the public part of a keypair
+	// or a certificate, i.e. the CA bundle. Not allowed for random values.
+	// +optional
+	Var string `json:"var,omitempty"`
+}
+
+// IncludeSpec is the configuration of an include step: the step is
+// replaced by the steps of a fragment before the workflow runs.
+type IncludeSpec struct {
+	// ConfigMapRef selects the ConfigMap key holding the fragment.
+	// +optional
+	ConfigMapRef *FragmentConfigMapRef `json:"configMapRef,omitempty"`
+	// Artifact is a packaged chart (oci:// or .tgz URL) holding the
+	// fragment in its fragment.yaml file.
+	// +optional
+	Artifact *FragmentArtifact `json:"artifact,omitempty"`
+	// Parameters are the values of the fragment parameters.
+	// +optional
+	Parameters []*Data `json:"parameters,omitempty"`
+}
+
+type FragmentConfigMapRef struct {
+	Name string `json:"name"`
+	// Namespace defaults to the namespace of the workflow.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+	// Key defaults to fragment.yaml.
+	// +optional
+	Key string `json:"key,omitempty"`
+}
+
+type FragmentArtifact struct {
+	URL string `json:"url"`
+	// Version of the artifact, the latest one if not set.
+	// +optional
+	Version string `json:"version,omitempty"`
+	// +optional
+	Credentials *Credentials `json:"credentials,omitempty"`
+	// +optional
+	InsecureSkipTLSVerify *bool `json:"insecureSkipTLSVerify,omitempty"`
+}
+
+// FragmentParameter is a parameter declared by a fragment.
+type FragmentParameter struct {
+	Name string `json:"name"`
+	// Default is the value of the parameter when the include step doesn't
+	// set it. Parameters without a default are required.
+	// +optional
+	Default *string `json:"default,omitempty"`
+}
+
+// Fragment is a reusable list of steps, included by the include steps.
+type Fragment struct {
+	Parameters []FragmentParameter `json:"parameters,omitempty"`
+	Steps      []*Step             `json:"steps,omitempty"`
+}
+
+// RetryPolicy controls how a step is retried when it fails with a
+// transient error. Permanent errors (invalid input, a chart version
+// that doesn't exist) are never retried.
+type RetryPolicy struct {
+	// Attempts is the maximum number of attempts, including the first one.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Attempts int `json:"attempts,omitempty"`
+	// Backoff is the delay before the first retry, it doubles at each
+	// subsequent attempt. Defaults to 5s.
+	// +optional
+	Backoff *metav1.Duration `json:"backoff,omitempty"`
+	// MaxDelay caps the delay between two attempts. Defaults to 1m.
+	// +optional
+	MaxDelay *metav1.Duration `json:"maxDelay,omitempty"`
+}
+
+// FailureAction tells what to do with the changes of a failed step.
+// +kubebuilder:validation:Enum=None;Rollback
+type FailureAction string
+
+const (
+	// FailureNone leaves the changes of the step in place.
+	FailureNone FailureAction = "None"
+	// FailureRollback rolls back the release to the previous revision
+	// (or uninstalls it) and restores the previous content of the object
+	// (or deletes it).
+	FailureRollback FailureAction = "Rollback"
+)
+
+// PrunePolicy tells what to do with the releases and objects of a step
+// once the step no longer produces them.
+// +kubebuilder:validation:Enum=Delete;Orphan
+type PrunePolicy string
+
+const (
+	// PruneDelete uninstalls the releases and deletes the objects.
+	PruneDelete PrunePolicy = "Delete"
+	// PruneOrphan leaves the releases and objects in the cluster.
+	PruneOrphan PrunePolicy = "Orphan"
+)
+
+// DeletionPolicy tells what to do with the releases and objects of a
+// step when the workflow is deleted.
+// +kubebuilder:validation:Enum=Delete;Orphan
+type DeletionPolicy string
+
+const (
+	// DeletionDelete uninstalls the releases and deletes the objects.
+	DeletionDelete DeletionPolicy = "Delete"
+	// DeletionOrphan leaves the releases and objects in the cluster.
+	DeletionOrphan DeletionPolicy = "Orphan"
 )
 
 type Step struct {
 	// +kubebuilder:validation:Required
 	ID string `json:"id"`
 	// +kubebuilder:validation:Required
-	// +kubebuilder:validation:Enum=object;chart;var
 	Type StepType `json:"type"`
+	// DependsOn lists the ids of the steps that must complete before this one.
+	// Steps that don't set it wait for all the steps declared before them,
+	// an empty list lets the step start immediately.
+	// +optional
+	DependsOn []string `json:"dependsOn,omitempty"`
+	// PrunePolicy overrides the workflow prune policy for this step.
+	// +optional
+	PrunePolicy PrunePolicy `json:"prunePolicy,omitempty"`
+	// When is a jq expression evaluated against the workflow variables (.vars)
+	// and the cluster facts (.cluster). The step is skipped if it evaluates
+	// to false or null.
+	// +optional
+	When string `json:"when,omitempty"`
+	// ForEach runs the step once for each item of a list, see the
+	// workflow documentation for the variables available to the instances.
+	// +optional
+	ForEach *ForEach `json:"forEach,omitempty"`
+	// Retry tells how many times the step is attempted on transient failures.
+	// By default a failed step is not retried until the next reconcile.
+	// +optional
+	Retry *RetryPolicy `json:"retry,omitempty"`
+	// Timeout bounds the execution of the step, retries included.
+	// The step is not started if the time left to the reconcile is shorter.
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+	// DeletionPolicy tells whether the releases and objects of the step
+	// are removed when the workflow is deleted. Defaults to Delete.
+	// +optional
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+	// OnFailure tells what to do with the changes of the step when it fails.
+	// Defaults to None.
+	// +optional
+	OnFailure FailureAction `json:"onFailure,omitempty"`
 	// +kubebuilder:pruning:PreserveUnknownFields
 	With *runtime.RawExtension `json:"with"`
 }
@@ -118,11 +596,72 @@ func (s *Step) Digest() string {
 	return strconv.FormatUint(hasher.Sum64(), 16)
 }
 
+// DeleteAction tells how an onDelete step is run.
+// +kubebuilder:validation:Enum=Apply;Delete
+type DeleteAction string
+
+const (
+	// DeleteActionApply installs the release or applies the object
+	// (i.e. to back up a Secret).
+	DeleteActionApply DeleteAction = "Apply"
+	// DeleteActionDelete uninstalls the release or deletes the object.
+	DeleteActionDelete DeleteAction = "Delete"
+)
+
+// DeleteStep is a step run when the workflow is deleted.
+type DeleteStep struct {
+	Step `json:",inline"`
+	// Action defaults to Apply.
+	// +optional
+	Action DeleteAction `json:"action,omitempty"`
+}
+
+// Mode tells whether the workflow applies its steps or only plans them.
+// +kubebuilder:validation:Enum=Apply;Plan
+type Mode string
+
+const (
+	// ModeApply executes the steps.
+	ModeApply Mode = "Apply"
+	// ModePlan reports in the status what the steps would change,
+	// without changing anything.
+	ModePlan Mode = "Plan"
+)
+
 type WorkflowSpec struct {
-	Steps []*Step `json:"steps,omitempty"`
+	// Mode defaults to Apply, the annotation krateo.io/plan: "true"
+	// has the same effect as Plan.
+	// +optional
+	Mode Mode `json:"mode,omitempty"`
+	// PrunePolicy applies to the releases and objects of the steps removed
+	// from the workflow or no longer producing them. Defaults to Delete.
+	// +optional
+	PrunePolicy PrunePolicy `json:"prunePolicy,omitempty"`
+	// RollbackOnFailure rolls back the failed step and the steps completed
+	// by the same run, in reverse order.
+	// +optional
+	RollbackOnFailure bool    `json:"rollbackOnFailure,omitempty"`
+	Steps             []*Step `json:"steps,omitempty"`
+	// OnDelete lists the steps run, in order, when the workflow is deleted
+	// and before its steps are removed.
+	// +optional
+	OnDelete []*DeleteStep `json:"onDelete,omitempty"`
+}
+
+// PrunePolicyFor returns the prune policy in effect for the given step.
+func (s *WorkflowSpec) PrunePolicyFor(x *Step) PrunePolicy {
+	if x != nil && len(x.PrunePolicy) > 0 {
+		return x.PrunePolicy
+	}
+	if len(s.PrunePolicy) > 0 {
+		return s.PrunePolicy
+	}
+	return PruneDelete
 }
 
 type Release struct {
+	// Step is the id of the step that installed the release.
+	Step         string      `json:"step,omitempty"`
 	ReleaseName  string      `json:"releaseName,omitempty"`
 	ChartName    string      `json:"chartName,omitempty"`
 	ChartVersion string      `json:"chartVersion,omitempty"`
@@ -131,15 +670,155 @@ type Release struct {
 	Status       string      `json:"status,omitempty"`
 	Revision     int         `json:"revision,omitempty"`
 	Updated      metav1.Time `json:"updated,omitempty"`
+	// Adopted lists the existing resources the release took over.
+	Adopted []string `json:"adopted,omitempty"`
+}
+
+type ObjectStatus struct {
+	ObjectMeta `json:",inline"`
+	// Step is the id of the step that applied the object.
+	Step string `json:"step,omitempty"`
+}
+
+// JobStatus reports the Job run by a job step.
+type JobStatus struct {
+	// Step is the id of the step that ran the Job.
+	Step           string       `json:"step,omitempty"`
+	Name           string       `json:"name"`
+	Namespace      string       `json:"namespace,omitempty"`
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	// Logs is the tail of the logs of the Job pod.
+	Logs string `json:"logs,omitempty"`
+}
+
+// GeneratedSecretStatus reports the Secret created by a generate step.
+type GeneratedSecretStatus struct {
+	// Step is the id of the step that created the Secret.
+	Step        string      `json:"step,omitempty"`
+	Name        string      `json:"name"`
+	Namespace   string      `json:"namespace,omitempty"`
+	GeneratedAt metav1.Time `json:"generatedAt,omitempty"`
+	// RotateAt is set when the Secret is rotated on a schedule, the
+	// step runs again once it is due.
+	RotateAt *metav1.Time `json:"rotateAt,omitempty"`
+}
+
+type VarStatus struct {
+	Var `json:",inline"`
+	// Step is the id of the step that resolved the variable.
+	Step string `json:"step,omitempty"`
+	// Sensitive variables are recorded without value, which is
+	// stored in the Secret <name>-outputs of the KrateoPlatformOps.
+	Sensitive bool `json:"sensitive,omitempty"`
+}
+
+// Checkpoint reports the progress of a run that didn't complete. The next
+// run doesn't read it: it resumes by skipping the steps whose digest
+// didn't change, the failed one being the first it executes again.
+type Checkpoint struct {
+	// Step is the id of the last step completed before the failure.
+	Step string `json:"step,omitempty"`
+	// Failed is the id of the step that failed.
+	Failed string `json:"failed,omitempty"`
+}
+
+type StepPhase string
+
+const (
+	// StepSucceeded means the step was executed successfully.
+	StepSucceeded StepPhase = "Succeeded"
+	// StepUnchanged means the step was not executed since its digest
+	// didn't change since the previous run.
+	StepUnchanged StepPhase = "Unchanged"
+	// StepSkipped means the step was not executed since its when
+	// condition evaluated to false.
+	StepSkipped StepPhase = "Skipped"
+	// StepRolledBack means the changes of the step were rolled back
+	// after a failure.
+	StepRolledBack StepPhase = "RolledBack"
+	// StepFailed means the step failed, it is reported for the onDelete steps.
+	StepFailed StepPhase = "Failed"
+)
+
+// PlannedChange reports what a step would change.
+type PlannedChange struct {
+	Step string `json:"step"`
+	// Action is one of create, update, install, upgrade or none.
+	Action string `json:"action"`
+	// Resource identifies the object or the release.
+	Resource string `json:"resource"`
+	// ChartVersion reports the version change of a release (i.e. 1.0.0 -> 1.1.0).
+	// +optional
+	ChartVersion string `json:"chartVersion,omitempty"`
+	// Values lists the chart values that would be added (+), removed (-) or changed (~).
+	// +optional
+	Values []string `json:"values,omitempty"`
+	// Changes lists the object fields, or the release objects, that would be
+	// added (+), removed (-) or changed (~).
+	// +optional
+	Changes []string `json:"changes,omitempty"`
+	// Error reports why the change couldn't be validated against the cluster.
+	// +optional
+	Error string `json:"error,omitempty"`
+}
+
+type Plan struct {
+	// Digest of the steps the plan was computed for.
+	Digest  string          `json:"digest,omitempty"`
+	Time    metav1.Time     `json:"time,omitempty"`
+	Changes []PlannedChange `json:"changes,omitempty"`
+}
+
+type RollbackStatus struct {
+	Step string `json:"step"`
+	// Error reports why the rollback failed, empty if it succeeded.
+	Error string `json:"error,omitempty"`
+}
+
+// DeleteStepStatus reports the result of an onDelete step.
+type DeleteStepStatus struct {
+	ID    string    `json:"id"`
+	Phase StepPhase `json:"phase,omitempty"`
+	// Error reports why the step failed.
+	// +optional
+	Error string `json:"error,omitempty"`
+}
+
+type StepStatus struct {
+	ID    string    `json:"id"`
+	Phase StepPhase `json:"phase,omitempty"`
+	// Digest hashes the step configuration together with the values
+	// of the variables it references.
+	Digest string `json:"digest,omitempty"`
+	// PrunePolicy in effect for the releases and objects of the step.
+	PrunePolicy PrunePolicy `json:"prunePolicy,omitempty"`
 }
 
 type WorkflowStatus struct {
 	rtv1.ConditionedStatus `json:",inline"`
 	Digest                 string `json:"digest,omitempty"`
 
-	ObjectList  []Object  `json:"objectList,omitempty"`
-	ReleaseList []Release `json:"releaseList,omitempty"`
-	VarList     []Var     `json:"varList,omitempty"`
+	// Steps records the digest of each step executed by the last run.
+	Steps []StepStatus `json:"steps,omitempty"`
+
+	ObjectList  []ObjectStatus `json:"objectList,omitempty"`
+	ReleaseList []Release      `json:"releaseList,omitempty"`
+	VarList     []VarStatus    `json:"varList,omitempty"`
+	JobList     []JobStatus    `json:"jobList,omitempty"`
+	// SecretList records the Secrets created by the generate steps.
+	SecretList []GeneratedSecretStatus `json:"secretList,omitempty"`
+
+	// RolledBack lists the steps rolled back by the last run.
+	RolledBack []RollbackStatus `json:"rolledBack,omitempty"`
+
+	// Plan reports what the workflow would change, it is set in plan mode.
+	Plan *Plan `json:"plan,omitempty"`
+
+	// Checkpoint is set when the last run didn't complete.
+	Checkpoint *Checkpoint `json:"checkpoint,omitempty"`
+
+	// OnDelete reports the results of the onDelete steps.
+	OnDelete []DeleteStepStatus `json:"onDelete,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -0,0 +1,35 @@
+package workflows
+
+import (
+	"fmt"
+	"strings"
+
+	workflowsv1alpha1 "github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+	"github.com/krateoplatformops/installer/internal/workflows"
+)
+
+// AnnotationApprovalPrefix prefixes the annotations approving the
+// approval steps: the annotation approval.krateo.io/<step-id> approves
+// the step if its value matches the digest of the current spec, so that
+// any later change of the spec requires a new approval.
+const AnnotationApprovalPrefix = "approval.krateo.io/"
+
+// approvedSteps returns the ids of the approval steps approved for the
+// spec with the given digest.
+func approvedSteps(cr *workflowsv1alpha1.KrateoPlatformOps, digest string) []string {
+	var all []string
+	for k, v := range cr.GetAnnotations() {
+		id, ok := strings.CutPrefix(k, AnnotationApprovalPrefix)
+		if !ok || len(id) == 0 || v != digest {
+			continue
+		}
+		all = append(all, id)
+	}
+	return all
+}
+
+// approvalHint tells how to approve the step awaiting approval.
+func approvalHint(cr *workflowsv1alpha1.KrateoPlatformOps, digest string, err *workflows.ApprovalError) string {
+	return fmt.Sprintf("%s (approve with: kubectl annotate krateoplatformops %s -n %s %s%s=%s --overwrite)",
+		err.Error(), cr.Name, cr.Namespace, AnnotationApprovalPrefix, err.StepID, digest)
+}
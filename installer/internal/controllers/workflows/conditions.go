@@ -0,0 +1,86 @@
+package workflows
+
+import (
+	rtv1 "github.com/krateoplatformops/provider-runtime/apis/common/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ReasonStepFailed is the Ready condition reason reported when a step
+// fails with an error that retrying won't fix (i.e. invalid input).
+const ReasonStepFailed rtv1.ConditionReason = "StepFailed"
+
+// StepFailed returns a condition indicating that the workflow can't
+// complete until the failing step is fixed.
+func StepFailed(err error) rtv1.Condition {
+	return rtv1.Condition{
+		Type:               rtv1.TypeReady,
+		Status:             metav1.ConditionFalse,
+		LastTransitionTime: metav1.Now(),
+		Reason:             ReasonStepFailed,
+		Message:            err.Error(),
+	}
+}
+
+// ReasonYielded is the Ready condition reason reported when the workflow
+// stopped before the reconcile timeout and resumes at the next reconcile.
+const ReasonYielded rtv1.ConditionReason = "Yielded"
+
+// Yielded returns a condition indicating that the workflow ran out of
+// time budget and will continue from the first step not completed.
+func Yielded(err error) rtv1.Condition {
+	return rtv1.Condition{
+		Type:               rtv1.TypeReady,
+		Status:             metav1.ConditionFalse,
+		LastTransitionTime: metav1.Now(),
+		Reason:             ReasonYielded,
+		Message:            err.Error(),
+	}
+}
+
+// ReasonPlanned is the Ready condition reason reported in plan mode.
+const ReasonPlanned rtv1.ConditionReason = "Planned"
+
+// Planned returns a condition indicating that the changes of the
+// workflow have been planned but not applied.
+func Planned() rtv1.Condition {
+	return rtv1.Condition{
+		Type:               rtv1.TypeReady,
+		Status:             metav1.ConditionFalse,
+		LastTransitionTime: metav1.Now(),
+		Reason:             ReasonPlanned,
+		Message:            "Plan mode, the changes reported in status.plan have not been applied",
+	}
+}
+
+// ReasonAwaitingApproval is the Ready condition reason reported when
+// the workflow stopped at an approval step not approved yet.
+const ReasonAwaitingApproval rtv1.ConditionReason = "AwaitingApproval"
+
+// AwaitingApproval returns a condition indicating that the workflow
+// resumes from the approval step once approved.
+func AwaitingApproval(msg string) rtv1.Condition {
+	return rtv1.Condition{
+		Type:               rtv1.TypeReady,
+		Status:             metav1.ConditionFalse,
+		LastTransitionTime: metav1.Now(),
+		Reason:             ReasonAwaitingApproval,
+		Message:            msg,
+	}
+}
+
+// ReasonOwnershipConflict is the Ready condition reason reported when a
+// release or an object of the workflow is owned by another KrateoPlatformOps.
+const ReasonOwnershipConflict rtv1.ConditionReason = "OwnershipConflict"
+
+// OwnershipConflict returns a condition indicating that the workflow
+// can't change a release or an object until the other KrateoPlatformOps,
+// named in the message, stops managing it.
+func OwnershipConflict(err error) rtv1.Condition {
+	return rtv1.Condition{
+		Type:               rtv1.TypeReady,
+		Status:             metav1.ConditionFalse,
+		LastTransitionTime: metav1.Now(),
+		Reason:             ReasonOwnershipConflict,
+		Message:            err.Error(),
+	}
+}
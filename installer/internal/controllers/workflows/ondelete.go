@@ -0,0 +1,114 @@
+package workflows
+
+import (
+	"context"
+	"fmt"
+
+	workflowsv1alpha1 "github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+	"github.com/krateoplatformops/installer/internal/workflows"
+	"github.com/krateoplatformops/installer/pkg/workflows/steps"
+)
+
+// runOnDelete runs the onDelete steps in order, recording their results
+// in the status. The steps that already succeeded are not run again when
+// the deletion is retried.
+func (e *external) runOnDelete(ctx context.Context, cr *workflowsv1alpha1.KrateoPlatformOps) error {
+	for _, x := range e.spec.OnDelete {
+		if phase := onDeletePhase(cr, x.ID); phase == workflowsv1alpha1.StepSucceeded ||
+			phase == workflowsv1alpha1.StepSkipped {
+			continue
+		}
+
+		// onDelete steps are always required to resolve their variables
+		e.wf.Applied(x.ID)
+
+		op := steps.Create
+		if x.Action == workflowsv1alpha1.DeleteActionDelete {
+			op = steps.Delete
+		}
+		e.wf.Op(op)
+
+		spec := &workflowsv1alpha1.WorkflowSpec{
+			Steps: []*workflowsv1alpha1.Step{x.Step.DeepCopy()},
+		}
+		results := e.wf.Run(ctx, spec, func(*workflowsv1alpha1.Step) bool {
+			return false
+		})
+
+		el := workflowsv1alpha1.DeleteStepStatus{ID: x.ID, Phase: phaseOf(results)}
+		err := workflows.Err(results)
+		if err != nil {
+			el.Phase = workflowsv1alpha1.StepFailed
+			el.Error = err.Error()
+		}
+		setOnDeleteStatus(cr, el)
+
+		if err != nil {
+			return fmt.Errorf("onDelete step %s: %w", x.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// skipOnDelete skips the steps that have nothing to remove, and the
+// ones whose releases and objects are kept by their deletion policy.
+func skipOnDelete(s *workflowsv1alpha1.Step) bool {
+	return s.Type == workflowsv1alpha1.TypeVar ||
+		s.Type == workflowsv1alpha1.TypeApproval ||
+		s.DeletionPolicy == workflowsv1alpha1.DeletionOrphan
+}
+
+// appliedSteps lists the steps that changed the cluster: the ones that
+// completed a run and the ones still holding releases, objects or Secrets.
+func appliedSteps(status *workflowsv1alpha1.WorkflowStatus) []string {
+	var all []string
+	for _, x := range status.Steps {
+		if x.Phase != workflowsv1alpha1.StepSkipped {
+			all = append(all, x.ID)
+		}
+	}
+	for _, x := range status.ReleaseList {
+		all = append(all, x.Step)
+	}
+	for _, x := range status.ObjectList {
+		all = append(all, x.Step)
+	}
+	for _, x := range status.JobList {
+		all = append(all, x.Step)
+	}
+	for _, x := range status.SecretList {
+		all = append(all, x.Step)
+	}
+	return all
+}
+
+// phaseOf returns Skipped if all the results (one for each forEach
+// instance) were skipped, Succeeded otherwise.
+func phaseOf(results []workflows.StepResult[any]) workflowsv1alpha1.StepPhase {
+	for _, x := range results {
+		if x.Phase() != workflowsv1alpha1.StepSkipped {
+			return workflowsv1alpha1.StepSucceeded
+		}
+	}
+	return workflowsv1alpha1.StepSkipped
+}
+
+func onDeletePhase(cr *workflowsv1alpha1.KrateoPlatformOps, id string) workflowsv1alpha1.StepPhase {
+	for _, x := range cr.Status.OnDelete {
+		if x.ID == id {
+			return x.Phase
+		}
+	}
+	return ""
+}
+
+func setOnDeleteStatus(cr *workflowsv1alpha1.KrateoPlatformOps, el workflowsv1alpha1.DeleteStepStatus) {
+	for i, x := range cr.Status.OnDelete {
+		if x.ID == el.ID {
+			cr.Status.OnDelete[i] = el
+			return
+		}
+	}
+	cr.Status.OnDelete = append(cr.Status.OnDelete, el)
+}
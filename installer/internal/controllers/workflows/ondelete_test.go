@@ -0,0 +1,66 @@
+package workflows
+
+import (
+	"fmt"
+	"testing"
+
+	workflowsv1alpha1 "github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+)
+
+func TestSkipOnDelete(t *testing.T) {
+	tests := []struct {
+		step *workflowsv1alpha1.Step
+		skip bool
+	}{
+		{step: &workflowsv1alpha1.Step{ID: "chart", Type: workflowsv1alpha1.TypeChart}, skip: false},
+		{step: &workflowsv1alpha1.Step{ID: "var", Type: workflowsv1alpha1.TypeVar}, skip: true},
+		{step: &workflowsv1alpha1.Step{ID: "gate", Type: workflowsv1alpha1.TypeApproval}, skip: true},
+		{step: &workflowsv1alpha1.Step{ID: "crds", Type: workflowsv1alpha1.TypeChart,
+			DeletionPolicy: workflowsv1alpha1.DeletionOrphan}, skip: true},
+		{step: &workflowsv1alpha1.Step{ID: "cm", Type: workflowsv1alpha1.TypeObject,
+			DeletionPolicy: workflowsv1alpha1.DeletionDelete}, skip: false},
+	}
+
+	for _, tc := range tests {
+		if got := skipOnDelete(tc.step); got != tc.skip {
+			t.Errorf("step %s: got skip %v, expected %v", tc.step.ID, got, tc.skip)
+		}
+	}
+}
+
+func TestSetOnDeleteStatus(t *testing.T) {
+	cr := &workflowsv1alpha1.KrateoPlatformOps{}
+
+	setOnDeleteStatus(cr, workflowsv1alpha1.DeleteStepStatus{ID: "backup", Phase: workflowsv1alpha1.StepSucceeded})
+	setOnDeleteStatus(cr, workflowsv1alpha1.DeleteStepStatus{ID: "uninstall", Phase: workflowsv1alpha1.StepFailed, Error: "boom"})
+	setOnDeleteStatus(cr, workflowsv1alpha1.DeleteStepStatus{ID: "uninstall", Phase: workflowsv1alpha1.StepSucceeded})
+
+	if len(cr.Status.OnDelete) != 2 {
+		t.Fatalf("unexpected status: %+v", cr.Status.OnDelete)
+	}
+	if got := onDeletePhase(cr, "uninstall"); got != workflowsv1alpha1.StepSucceeded {
+		t.Fatalf("got phase %s, expected %s", got, workflowsv1alpha1.StepSucceeded)
+	}
+	if cr.Status.OnDelete[1].Error != "" {
+		t.Fatalf("expected the error to be cleared, got: %s", cr.Status.OnDelete[1].Error)
+	}
+	if got := onDeletePhase(cr, "missing"); got != "" {
+		t.Fatalf("unexpected phase %s", got)
+	}
+}
+
+func TestAppliedSteps(t *testing.T) {
+	status := &workflowsv1alpha1.WorkflowStatus{
+		Steps: []workflowsv1alpha1.StepStatus{
+			{ID: "ns", Phase: workflowsv1alpha1.StepSucceeded},
+			{ID: "portal", Phase: workflowsv1alpha1.StepSkipped},
+		},
+		ReleaseList: []workflowsv1alpha1.Release{{Step: "bff", ReleaseName: "bff"}},
+		JobList:     []workflowsv1alpha1.JobStatus{{Step: "migrate", Name: "migrate"}},
+	}
+
+	got := appliedSteps(status)
+	if fmt.Sprint(got) != "[ns bff migrate]" {
+		t.Fatalf("got: %v, expected: [ns bff migrate]", got)
+	}
+}
@@ -0,0 +1,110 @@
+package workflows
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	workflowsv1alpha1 "github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// outputsSecretName is the name of the Secret holding the values of the
+// sensitive variables, which are recorded in the status without value.
+func outputsSecretName(cr *workflowsv1alpha1.KrateoPlatformOps) string {
+	return cr.Name + "-outputs"
+}
+
+// restore sets the workflow variables recorded by the last run, reading
+// the sensitive ones from the outputs Secret. A sensitive variable
+// missing from the Secret is left unresolved.
+func (e *external) restore(ctx context.Context, cr *workflowsv1alpha1.KrateoPlatformOps) error {
+	vars := varsOf(cr.Status.VarList)
+
+	if hasSensitive(&cr.Status) {
+		secret, err := e.secrets.Secrets(cr.Namespace).Get(ctx, outputsSecretName(cr), metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			e.log.Info(fmt.Sprintf("WARN: secret %s/%s of the sensitive outputs not found", cr.Namespace, outputsSecretName(cr)))
+			secret = &corev1.Secret{}
+		} else if err != nil {
+			return err
+		}
+
+		for _, x := range cr.Status.VarList {
+			if val, ok := secret.Data[x.Name]; ok && x.Sensitive {
+				vars = append(vars, workflowsv1alpha1.Data{Name: x.Name, Value: string(val)})
+			}
+		}
+	}
+
+	e.wf.Restore(vars)
+	return nil
+}
+
+// storeSensitive writes the values of the sensitive variables recorded in
+// the status to the outputs Secret, owned by the KrateoPlatformOps. The
+// Secret is deleted when the previous status recorded some and the
+// current one none.
+func (e *external) storeSensitive(ctx context.Context, cr *workflowsv1alpha1.KrateoPlatformOps, prev *workflowsv1alpha1.WorkflowStatus) error {
+	cli := e.secrets.Secrets(cr.Namespace)
+
+	data := sensitiveOf(cr.Status.VarList, e.wf.Var)
+	if len(data) == 0 {
+		if !hasSensitive(prev) {
+			return nil
+		}
+		err := cli.Delete(ctx, outputsSecretName(cr), metav1.DeleteOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: cr.Namespace,
+			Name:      outputsSecretName(cr),
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(cr, workflowsv1alpha1.KrateoPlatformOpsGroupVersionKind),
+			},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: data,
+	}
+
+	cur, err := cli.Get(ctx, secret.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = cli.Create(ctx, secret, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	secret.ResourceVersion = cur.ResourceVersion
+	_, err = cli.Update(ctx, secret, metav1.UpdateOptions{})
+	return err
+}
+
+func hasSensitive(status *workflowsv1alpha1.WorkflowStatus) bool {
+	return slices.ContainsFunc(status.VarList, func(x workflowsv1alpha1.VarStatus) bool {
+		return x.Sensitive
+	})
+}
+
+// sensitiveOf returns the values of the sensitive variables, looked up
+// by name.
+func sensitiveOf(all []workflowsv1alpha1.VarStatus, lookup func(string) (string, bool)) map[string][]byte {
+	res := map[string][]byte{}
+	for _, x := range all {
+		if !x.Sensitive {
+			continue
+		}
+		if val, ok := lookup(x.Name); ok {
+			res[x.Name] = []byte(val)
+		}
+	}
+	return res
+}
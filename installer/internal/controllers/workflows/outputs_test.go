@@ -0,0 +1,85 @@
+package workflows
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	workflowsv1alpha1 "github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+	"github.com/krateoplatformops/installer/internal/workflows"
+	"github.com/krateoplatformops/installer/pkg/dynamic/applier"
+	"github.com/krateoplatformops/installer/pkg/dynamic/deletor"
+	"github.com/krateoplatformops/installer/pkg/dynamic/getter"
+	mockhelmclient "github.com/krateoplatformops/installer/pkg/helmclient/mock"
+	"github.com/krateoplatformops/provider-runtime/pkg/logging"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newWorkflow(t *testing.T) *workflows.Workflow {
+	t.Helper()
+
+	wf, err := workflows.New(workflows.Opts{
+		Getter:     &getter.Getter{},
+		Applier:    &applier.Applier{},
+		Deletor:    &deletor.Deletor{},
+		HelmClient: mockhelmclient.NewMockClient(gomock.NewController(t)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wf
+}
+
+func TestSensitiveOutputs(t *testing.T) {
+	ctx := context.Background()
+	cli := fake.NewSimpleClientset()
+
+	cr := &workflowsv1alpha1.KrateoPlatformOps{
+		ObjectMeta: metav1.ObjectMeta{Name: "krateo", Namespace: "krateo-system", UID: "1234"},
+	}
+	cr.Status.VarList = []workflowsv1alpha1.VarStatus{
+		{Var: workflowsv1alpha1.Var{Data: workflowsv1alpha1.Data{Name: "TOKEN"}}, Step: "register", Sensitive: true},
+		{Var: workflowsv1alpha1.Var{Data: workflowsv1alpha1.Data{Name: "ID", Value: "42"}}, Step: "register"},
+	}
+
+	e := &external{secrets: cli.CoreV1(), wf: newWorkflow(t), log: logging.NewNopLogger()}
+	e.wf.Restore([]workflowsv1alpha1.Data{{Name: "TOKEN", Value: "s3cr3t"}})
+	if err := e.storeSensitive(ctx, cr, &workflowsv1alpha1.WorkflowStatus{}); err != nil {
+		t.Fatal(err)
+	}
+
+	secret, err := cli.CoreV1().Secrets("krateo-system").Get(ctx, "krateo-outputs", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secret.Data) != 1 || string(secret.Data["TOKEN"]) != "s3cr3t" {
+		t.Fatalf("unexpected secret data: %v", secret.Data)
+	}
+	if len(secret.OwnerReferences) != 1 || secret.OwnerReferences[0].UID != "1234" {
+		t.Fatalf("expected the secret to be owned by the KrateoPlatformOps: %+v", secret.OwnerReferences)
+	}
+
+	// the next run resumes with both values
+	e.wf = newWorkflow(t)
+	if err := e.restore(ctx, cr); err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range map[string]string{"TOKEN": "s3cr3t", "ID": "42"} {
+		if got, _ := e.wf.Var(k); got != v {
+			t.Errorf("variable %s: got %q, expected %q", k, got, v)
+		}
+	}
+
+	// no sensitive outputs left
+	prev := cr.Status.DeepCopy()
+	cr.Status.VarList = cr.Status.VarList[1:]
+	if err := e.storeSensitive(ctx, cr, prev); err != nil {
+		t.Fatal(err)
+	}
+	_, err = cli.CoreV1().Secrets("krateo-system").Get(ctx, "krateo-outputs", metav1.GetOptions{})
+	if !apierrors.IsNotFound(err) {
+		t.Fatalf("expected the secret to be deleted, got: %v", err)
+	}
+}
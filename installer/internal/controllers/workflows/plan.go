@@ -0,0 +1,62 @@
+package workflows
+
+import (
+	"context"
+
+	workflowsv1alpha1 "github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+	"github.com/krateoplatformops/installer/internal/workflows"
+	"github.com/krateoplatformops/installer/pkg/workflows/steps"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// AnnotationPlan set to "true" runs the workflow in plan mode,
+// regardless of spec.mode.
+const AnnotationPlan = "krateo.io/plan"
+
+func isPlanMode(cr *workflowsv1alpha1.KrateoPlatformOps) bool {
+	return cr.Spec.Mode == workflowsv1alpha1.ModePlan ||
+		cr.GetAnnotations()[AnnotationPlan] == "true"
+}
+
+// plan runs all the steps in dry-run and records what they would change.
+func (e *external) plan(ctx context.Context, cr *workflowsv1alpha1.KrateoPlatformOps) error {
+	e.wf.Op(steps.Update)
+	e.wf.DryRun(true)
+	if err := e.restore(ctx, cr); err != nil {
+		return err
+	}
+
+	results := e.wf.Run(ctx, e.spec.DeepCopy(), func(*workflowsv1alpha1.Step) bool {
+		return false
+	})
+	if err := workflows.Err(results); err != nil {
+		return err
+	}
+
+	cr.Status.Plan = &workflowsv1alpha1.Plan{
+		Digest:  digestForSteps(e.spec),
+		Time:    metav1.Now(),
+		Changes: plannedChanges(results),
+	}
+	cr.SetConditions(Planned())
+
+	return e.kube.Status().Update(ctx, cr)
+}
+
+func plannedChanges(results []workflows.StepResult[any]) []workflowsv1alpha1.PlannedChange {
+	all := []workflowsv1alpha1.PlannedChange{}
+	for _, x := range results {
+		res, ok := x.Result().(steps.Result)
+		if !ok {
+			continue
+		}
+		change := res.PlannedChange()
+		if change == nil {
+			continue
+		}
+
+		change.Step = x.ID()
+		all = append(all, *change)
+	}
+	return all
+}
@@ -0,0 +1,180 @@
+package workflows
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	workflowsv1alpha1 "github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+	rtv1 "github.com/krateoplatformops/provider-runtime/apis/common/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// orphans are releases and objects no longer produced by a workflow.
+type orphans struct {
+	releases []workflowsv1alpha1.Release
+	objects  []workflowsv1alpha1.ObjectStatus
+}
+
+func (o *orphans) empty() bool {
+	return len(o.releases) == 0 && len(o.objects) == 0
+}
+
+// orphansOf returns the releases and objects recorded in the previous status
+// that the last run no longer produced: the ones to prune, whose prune
+// policy is Delete, and the ones to keep in place.
+// The Orphan policy wins if either the previous run or the current spec
+// sets it, so that a step set to Orphan and removed in the same edit is kept.
+// Entries not tagged with the step that produced them are never pruned,
+// nor are the Secrets of generate steps, whose values can't be generated
+// again: they are kept in place.
+func orphansOf(spec *workflowsv1alpha1.WorkflowSpec, prev, cur *workflowsv1alpha1.WorkflowStatus) (pruned, kept orphans) {
+	orphan := map[string]bool{}
+	for _, all := range [][]workflowsv1alpha1.StepStatus{prev.Steps, cur.Steps} {
+		for _, x := range all {
+			if x.PrunePolicy == workflowsv1alpha1.PruneOrphan {
+				orphan[x.ID] = true
+			}
+		}
+	}
+
+	shouldPrune := func(step string) bool {
+		if len(step) == 0 || orphan[step] {
+			return false
+		}
+		return spec.PrunePolicyFor(declaringStep(spec, step)) != workflowsv1alpha1.PruneOrphan
+	}
+
+	keep := map[string]struct{}{}
+	for _, x := range cur.ReleaseList {
+		keep[releaseKey(x)] = struct{}{}
+	}
+	for _, x := range cur.ObjectList {
+		keep[objectKey(x)] = struct{}{}
+	}
+	for _, x := range cur.SecretList {
+		keep[objectKey(generatedSecret(x))] = struct{}{}
+	}
+
+	for _, x := range prev.ReleaseList {
+		if _, ok := keep[releaseKey(x)]; ok {
+			continue
+		}
+		if shouldPrune(x.Step) {
+			pruned.releases = append(pruned.releases, x)
+		} else {
+			kept.releases = append(kept.releases, x)
+		}
+	}
+	for _, x := range prev.ObjectList {
+		if _, ok := keep[objectKey(x)]; ok {
+			continue
+		}
+		if shouldPrune(x.Step) {
+			pruned.objects = append(pruned.objects, x)
+		} else {
+			kept.objects = append(kept.objects, x)
+		}
+	}
+	for _, x := range prev.SecretList {
+		if _, ok := keep[objectKey(generatedSecret(x))]; !ok {
+			kept.objects = append(kept.objects, generatedSecret(x))
+		}
+	}
+
+	return
+}
+
+// deletionOrphansOf returns the releases and objects recorded in the status
+// whose steps are kept in place on delete by their deletion policy.
+func deletionOrphansOf(spec *workflowsv1alpha1.WorkflowSpec, status *workflowsv1alpha1.WorkflowStatus) (res orphans) {
+	orphan := func(step string) bool {
+		x := declaringStep(spec, step)
+		return x != nil && x.DeletionPolicy == workflowsv1alpha1.DeletionOrphan
+	}
+
+	for _, x := range status.ReleaseList {
+		if orphan(x.Step) {
+			res.releases = append(res.releases, x)
+		}
+	}
+	for _, x := range status.ObjectList {
+		if orphan(x.Step) {
+			res.objects = append(res.objects, x)
+		}
+	}
+	for _, x := range status.SecretList {
+		if orphan(x.Step) {
+			res.objects = append(res.objects, generatedSecret(x))
+		}
+	}
+	return
+}
+
+// declaringStep returns the step of the spec with the given id or, for the
+// instances of a forEach step, <id>-<key>, the forEach step; nil if the
+// step has been removed. Keys may hold dashes: the longest id wins.
+func declaringStep(spec *workflowsv1alpha1.WorkflowSpec, id string) *workflowsv1alpha1.Step {
+	if x := stepByID(spec, id); x != nil {
+		return x
+	}
+	var res *workflowsv1alpha1.Step
+	for _, x := range spec.Steps {
+		key, ok := strings.CutPrefix(id, x.ID+"-")
+		if ok && len(key) > 0 && x.ForEach != nil && (res == nil || len(x.ID) > len(res.ID)) {
+			res = x
+		}
+	}
+	return res
+}
+
+// generatedSecret returns the Secret of a generate step as an object.
+func generatedSecret(x workflowsv1alpha1.GeneratedSecretStatus) workflowsv1alpha1.ObjectStatus {
+	return workflowsv1alpha1.ObjectStatus{
+		ObjectMeta: workflowsv1alpha1.ObjectMeta{
+			APIVersion: "v1",
+			Kind:       "Secret",
+			Metadata:   rtv1.Reference{Name: x.Name, Namespace: x.Namespace},
+		},
+		Step: x.Step,
+	}
+}
+
+func releaseKey(x workflowsv1alpha1.Release) string {
+	return strings.Join([]string{"release", x.Namespace, x.ReleaseName}, "/")
+}
+
+func objectKey(x workflowsv1alpha1.ObjectStatus) string {
+	gv, _ := schema.ParseGroupVersion(x.APIVersion)
+	return strings.Join([]string{gv.Group, x.Kind, x.Metadata.Namespace, x.Metadata.Name}, "/")
+}
+
+// prune garbage-collects the releases and objects the last run
+// no longer produced, and gives up the leases of the ones kept in place.
+func (e *external) prune(ctx context.Context, cr *workflowsv1alpha1.KrateoPlatformOps, prev *workflowsv1alpha1.WorkflowStatus) error {
+	pruned, kept := orphansOf(e.spec, prev, &cr.Status)
+	if !kept.empty() {
+		if err := e.wf.Disown(ctx, kept.releases, kept.objects); err != nil {
+			return err
+		}
+	}
+	if pruned.empty() {
+		return nil
+	}
+
+	if err := e.wf.Prune(ctx, pruned.releases, pruned.objects); err != nil {
+		return err
+	}
+
+	for _, x := range pruned.releases {
+		e.rec.Event(cr, corev1.EventTypeNormal, "Pruned",
+			fmt.Sprintf("Uninstalled release %s of step %s", x.ReleaseName, x.Step))
+	}
+	for _, x := range pruned.objects {
+		e.rec.Event(cr, corev1.EventTypeNormal, "Pruned",
+			fmt.Sprintf("Deleted %s %s of step %s", x.Kind, x.Metadata.Name, x.Step))
+	}
+
+	return nil
+}
@@ -0,0 +1,195 @@
+package workflows
+
+import (
+	"testing"
+
+	workflowsv1alpha1 "github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+	rtv1 "github.com/krateoplatformops/provider-runtime/apis/common/v1"
+)
+
+func cm(step, name string) workflowsv1alpha1.ObjectStatus {
+	return workflowsv1alpha1.ObjectStatus{
+		ObjectMeta: workflowsv1alpha1.ObjectMeta{
+			APIVersion: "v1", Kind: "ConfigMap",
+			Metadata: rtv1.Reference{Name: name, Namespace: "krateo-system"},
+		},
+		Step: step,
+	}
+}
+
+func TestOrphansOf(t *testing.T) {
+	rel := func(step, name string) workflowsv1alpha1.Release {
+		return workflowsv1alpha1.Release{Step: step, ReleaseName: name, Namespace: "krateo-system"}
+	}
+
+	prev := &workflowsv1alpha1.WorkflowStatus{
+		Steps: []workflowsv1alpha1.StepStatus{
+			{ID: "bff", PrunePolicy: workflowsv1alpha1.PruneDelete},
+			{ID: "backend", PrunePolicy: workflowsv1alpha1.PruneOrphan},
+			{ID: "cm", PrunePolicy: workflowsv1alpha1.PruneDelete},
+			{ID: "renamed", PrunePolicy: workflowsv1alpha1.PruneDelete},
+		},
+		ReleaseList: []workflowsv1alpha1.Release{
+			rel("bff", "bff"), rel("backend", "backend"), rel("renamed", "frontend"), rel("", "legacy"),
+		},
+		ObjectList: []workflowsv1alpha1.ObjectStatus{
+			cm("cm", "old-config"), cm("cm", "config"),
+		},
+	}
+
+	cur := &workflowsv1alpha1.WorkflowStatus{
+		ReleaseList: []workflowsv1alpha1.Release{
+			rel("frontend", "frontend"),
+		},
+		ObjectList: []workflowsv1alpha1.ObjectStatus{
+			cm("cm", "config"),
+		},
+	}
+
+	spec := &workflowsv1alpha1.WorkflowSpec{
+		Steps: []*workflowsv1alpha1.Step{{ID: "frontend"}, {ID: "cm"}},
+	}
+
+	pruned, kept := orphansOf(spec, prev, cur)
+
+	if len(pruned.releases) != 1 || pruned.releases[0].ReleaseName != "bff" {
+		t.Fatalf("expected only release bff to be pruned, got: %v", pruned.releases)
+	}
+	if len(pruned.objects) != 1 || pruned.objects[0].Metadata.Name != "old-config" {
+		t.Fatalf("expected only configmap old-config to be pruned, got: %v", pruned.objects)
+	}
+
+	// their leases are given up
+	if len(kept.releases) != 2 || kept.releases[0].ReleaseName != "backend" || kept.releases[1].ReleaseName != "legacy" {
+		t.Fatalf("expected releases backend and legacy to be kept, got: %v", kept.releases)
+	}
+	if len(kept.objects) != 0 {
+		t.Fatalf("expected no object to be kept, got: %v", kept.objects)
+	}
+}
+
+func TestOrphansOfSameEdit(t *testing.T) {
+	prev := &workflowsv1alpha1.WorkflowStatus{
+		Steps: []workflowsv1alpha1.StepStatus{
+			{ID: "cm", PrunePolicy: workflowsv1alpha1.PruneDelete},
+			{ID: "removed", PrunePolicy: workflowsv1alpha1.PruneDelete},
+			{ID: "items-1", PrunePolicy: workflowsv1alpha1.PruneDelete},
+		},
+		ObjectList: []workflowsv1alpha1.ObjectStatus{
+			cm("cm", "old-config"), cm("removed", "removed"), cm("items-1", "item"),
+		},
+	}
+
+	tests := []struct {
+		name string
+		spec *workflowsv1alpha1.WorkflowSpec
+		cur  *workflowsv1alpha1.WorkflowStatus
+	}{
+		{
+			name: "step set to Orphan and its object renamed",
+			spec: &workflowsv1alpha1.WorkflowSpec{
+				PrunePolicy: workflowsv1alpha1.PruneOrphan,
+			},
+			cur: &workflowsv1alpha1.WorkflowStatus{
+				Steps: []workflowsv1alpha1.StepStatus{
+					{ID: "cm", PrunePolicy: workflowsv1alpha1.PruneOrphan},
+				},
+				ObjectList: []workflowsv1alpha1.ObjectStatus{cm("cm", "config")},
+			},
+		},
+		{
+			name: "workflow set to Orphan and steps removed",
+			spec: &workflowsv1alpha1.WorkflowSpec{
+				PrunePolicy: workflowsv1alpha1.PruneOrphan,
+			},
+			cur: &workflowsv1alpha1.WorkflowStatus{},
+		},
+		{
+			name: "forEach step set to Orphan and item removed",
+			spec: &workflowsv1alpha1.WorkflowSpec{
+				Steps: []*workflowsv1alpha1.Step{
+					{ID: "cm", PrunePolicy: workflowsv1alpha1.PruneOrphan},
+					{ID: "removed", PrunePolicy: workflowsv1alpha1.PruneOrphan},
+					{ID: "items", PrunePolicy: workflowsv1alpha1.PruneOrphan, ForEach: &workflowsv1alpha1.ForEach{}},
+				},
+			},
+			cur: &workflowsv1alpha1.WorkflowStatus{},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			pruned, kept := orphansOf(tc.spec, prev, tc.cur)
+			if !pruned.empty() {
+				t.Fatalf("expected nothing to be pruned, got: %v, %v", pruned.releases, pruned.objects)
+			}
+			if len(kept.objects) != len(prev.ObjectList) {
+				t.Fatalf("expected the objects no longer produced to be kept, got: %v", kept.objects)
+			}
+		})
+	}
+}
+
+func TestOrphansOfGeneratedSecrets(t *testing.T) {
+	secret := func(step, name string) workflowsv1alpha1.GeneratedSecretStatus {
+		return workflowsv1alpha1.GeneratedSecretStatus{Step: step, Name: name, Namespace: "krateo-system"}
+	}
+
+	prev := &workflowsv1alpha1.WorkflowStatus{
+		Steps: []workflowsv1alpha1.StepStatus{
+			{ID: "jwt", PrunePolicy: workflowsv1alpha1.PruneDelete},
+			{ID: "tls", PrunePolicy: workflowsv1alpha1.PruneDelete},
+			{ID: "removed", PrunePolicy: workflowsv1alpha1.PruneDelete},
+		},
+		SecretList: []workflowsv1alpha1.GeneratedSecretStatus{
+			secret("jwt", "jwt-sign-key"), secret("tls", "snowplow-tls"), secret("removed", "removed"),
+		},
+	}
+
+	// tls renamed its Secret, removed is no longer in the spec
+	cur := &workflowsv1alpha1.WorkflowStatus{
+		SecretList: []workflowsv1alpha1.GeneratedSecretStatus{
+			secret("jwt", "jwt-sign-key"), secret("tls", "snowplow-certs"),
+		},
+	}
+
+	spec := &workflowsv1alpha1.WorkflowSpec{
+		Steps: []*workflowsv1alpha1.Step{{ID: "jwt"}, {ID: "tls"}},
+	}
+
+	pruned, kept := orphansOf(spec, prev, cur)
+	if !pruned.empty() {
+		t.Fatalf("expected nothing to be pruned, got: %v", pruned.objects)
+	}
+	if len(kept.objects) != 2 || kept.objects[0].Metadata.Name != "snowplow-tls" || kept.objects[1].Metadata.Name != "removed" ||
+		kept.objects[0].Kind != "Secret" {
+		t.Fatalf("expected secrets snowplow-tls and removed to be kept, got: %v", kept.objects)
+	}
+}
+
+func TestDeletionOrphansOf(t *testing.T) {
+	spec := &workflowsv1alpha1.WorkflowSpec{
+		Steps: []*workflowsv1alpha1.Step{
+			{ID: "crds", DeletionPolicy: workflowsv1alpha1.DeletionOrphan},
+			{ID: "items", DeletionPolicy: workflowsv1alpha1.DeletionOrphan, ForEach: &workflowsv1alpha1.ForEach{}},
+			{ID: "cm"},
+		},
+	}
+
+	status := &workflowsv1alpha1.WorkflowStatus{
+		ReleaseList: []workflowsv1alpha1.Release{
+			{Step: "crds", ReleaseName: "crds", Namespace: "krateo-system"},
+		},
+		ObjectList: []workflowsv1alpha1.ObjectStatus{
+			cm("cm", "config"), cm("items-0", "item"), cm("items-eu-west", "keyed-item"), cm("removed", "removed"),
+		},
+	}
+
+	got := deletionOrphansOf(spec, status)
+	if len(got.releases) != 1 || got.releases[0].ReleaseName != "crds" {
+		t.Fatalf("expected release crds to be kept, got: %v", got.releases)
+	}
+	if len(got.objects) != 2 || got.objects[0].Metadata.Name != "item" || got.objects[1].Metadata.Name != "keyed-item" {
+		t.Fatalf("expected only configmaps item and keyed-item to be kept, got: %v", got.objects)
+	}
+}
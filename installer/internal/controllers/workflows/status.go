@@ -1,110 +1,194 @@
 package workflows
 
 import (
+	"time"
+
 	workflowsv1alpha1 "github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
 	"github.com/krateoplatformops/installer/internal/workflows"
-	"github.com/krateoplatformops/installer/internal/workflows/steps"
-	rtv1 "github.com/krateoplatformops/provider-runtime/apis/common/v1"
+	"github.com/krateoplatformops/installer/pkg/workflows/steps"
 )
 
-// StatusPopulator definisce come un risultato può popolare lo status
-type StatusPopulator interface {
-	PopulateStatus(cr *workflowsv1alpha1.KrateoPlatformOps)
-}
+// populateStatus popola lo status del CR basandosi sui risultati del workflow.
+// Steps skipped because unchanged keep the entries recorded by the previous run.
+func populateStatus(cr *workflowsv1alpha1.KrateoPlatformOps, spec *workflowsv1alpha1.WorkflowSpec, results []workflows.StepResult[any]) {
+	prev := cr.Status.DeepCopy()
+
+	// Reset delle liste
+	cr.Status.Steps = make([]workflowsv1alpha1.StepStatus, 0)
+	cr.Status.ObjectList = make([]workflowsv1alpha1.ObjectStatus, 0)
+	cr.Status.ReleaseList = make([]workflowsv1alpha1.Release, 0)
+	cr.Status.VarList = make([]workflowsv1alpha1.VarStatus, 0)
+	cr.Status.JobList = make([]workflowsv1alpha1.JobStatus, 0)
+	cr.Status.SecretList = make([]workflowsv1alpha1.GeneratedSecretStatus, 0)
+
+	for _, result := range results {
+		if len(result.ID()) == 0 || result.Err() != nil {
+			continue // Skip risultati con errori
+		}
+		if result.Phase() == workflowsv1alpha1.StepRolledBack {
+			continue
+		}
 
-// Wrapper per VarResult
-type VarStatusWrapper struct {
-	*steps.VarResult
+		cr.Status.Steps = append(cr.Status.Steps, workflowsv1alpha1.StepStatus{
+			ID:          result.ID(),
+			Phase:       result.Phase(),
+			Digest:      result.Digest(),
+			PrunePolicy: spec.PrunePolicyFor(stepByID(spec, result.Parent())),
+		})
+
+		switch result.Phase() {
+		case workflowsv1alpha1.StepUnchanged:
+			keepStatusOf(cr, prev, result.ID())
+			continue
+		case workflowsv1alpha1.StepSkipped:
+			continue
+		}
+
+		// Ogni risultato popola la propria parte dello status
+		if res, ok := result.Result().(steps.Result); ok {
+			res.PopulateStatus(&cr.Status, result.ID())
+		}
+	}
 }
 
-func (w VarStatusWrapper) PopulateStatus(cr *workflowsv1alpha1.KrateoPlatformOps) {
-	if cr.Status.VarList == nil {
-		cr.Status.VarList = make([]workflowsv1alpha1.Var, 0)
+// checkpointOf returns the checkpoint of a run that didn't complete.
+func checkpointOf(results []workflows.StepResult[any]) *workflowsv1alpha1.Checkpoint {
+	cp := &workflowsv1alpha1.Checkpoint{}
+	for _, x := range results {
+		if x.Err() != nil {
+			if len(cp.Failed) == 0 {
+				cp.Failed = x.ID()
+			}
+			continue
+		}
+		if x.Phase() == workflowsv1alpha1.StepSucceeded || x.Phase() == workflowsv1alpha1.StepUnchanged {
+			cp.Step = x.ID()
+		}
 	}
-	cr.Status.VarList = append(cr.Status.VarList, workflowsv1alpha1.Var{
-		Data: workflowsv1alpha1.Data{
-			Name:  w.Name,
-			Value: w.Value,
-		},
-	})
+	return cp
 }
 
-// Wrapper per ObjectResult
-type ObjectStatusWrapper struct {
-	*steps.ObjectResult
+// rolledBackOf lists the steps rolled back by a run.
+func rolledBackOf(results []workflows.StepResult[any]) []workflowsv1alpha1.RollbackStatus {
+	var all []workflowsv1alpha1.RollbackStatus
+	for _, x := range results {
+		if x.Phase() != workflowsv1alpha1.StepRolledBack {
+			continue
+		}
+
+		el := workflowsv1alpha1.RollbackStatus{Step: x.ID()}
+		if err := x.RollbackErr(); err != nil {
+			el.Error = err.Error()
+		}
+		all = append(all, el)
+	}
+	return all
 }
 
-func (w ObjectStatusWrapper) PopulateStatus(cr *workflowsv1alpha1.KrateoPlatformOps) {
-	if cr.Status.ObjectList == nil {
-		cr.Status.ObjectList = make([]workflowsv1alpha1.Object, 0)
+// varsOf returns the values of the variables recorded in the status,
+// the sensitive ones excluded.
+func varsOf(all []workflowsv1alpha1.VarStatus) []workflowsv1alpha1.Data {
+	res := make([]workflowsv1alpha1.Data, 0, len(all))
+	for _, x := range all {
+		// their values are in the outputs Secret
+		if x.Sensitive {
+			continue
+		}
+		res = append(res, x.Data)
 	}
-	cr.Status.ObjectList = append(cr.Status.ObjectList, workflowsv1alpha1.Object{
-		ObjectMeta: workflowsv1alpha1.ObjectMeta{
-			APIVersion: w.APIVersion,
-			Kind:       w.Kind,
-			Metadata: rtv1.Reference{
-				Name:      w.Name,
-				Namespace: w.Namespace,
-			},
-		},
-	})
+	return res
 }
 
-// Wrapper per ChartResult
-type ChartStatusWrapper struct {
-	*steps.ChartResult
+func stepByID(spec *workflowsv1alpha1.WorkflowSpec, id string) *workflowsv1alpha1.Step {
+	for _, x := range spec.Steps {
+		if x.ID == id {
+			return x
+		}
+	}
+	return nil
 }
 
-func (w ChartStatusWrapper) PopulateStatus(cr *workflowsv1alpha1.KrateoPlatformOps) {
-	if cr.Status.ReleaseList == nil {
-		cr.Status.ReleaseList = make([]workflowsv1alpha1.Release, 0)
+// keepNotReached carries over from the previous status the entries of
+// the steps that didn't complete, so that a partial run doesn't forget
+// what they applied before.
+func keepNotReached(cr *workflowsv1alpha1.KrateoPlatformOps, prev *workflowsv1alpha1.WorkflowStatus, results []workflows.StepResult[any]) {
+	done := make(map[string]bool, len(results))
+	for _, x := range results {
+		if x.Err() == nil && x.Phase() != workflowsv1alpha1.StepRolledBack {
+			done[x.ID()] = true
+		}
+	}
+
+	for _, x := range prev.Steps {
+		if done[x.ID] {
+			continue
+		}
+		cr.Status.Steps = append(cr.Status.Steps, x)
+		keepStatusOf(cr, prev, x.ID)
 	}
-	cr.Status.ReleaseList = append(cr.Status.ReleaseList, workflowsv1alpha1.Release{
-		ReleaseName:  w.ReleaseName,
-		ChartName:    w.ChartName,
-		ChartVersion: w.ChartVersion,
-		AppVersion:   w.AppVersion,
-		Namespace:    w.Namespace,
-		Status:       w.Status,
-		Revision:     w.Revision,
-		Updated:      w.Updated,
-	})
 }
 
-// Factory function per creare il wrapper appropriato
-func wrapResultForStatus(result interface{}) StatusPopulator {
-	switch v := result.(type) {
-	case *steps.VarResult:
-		return VarStatusWrapper{v}
-	case *steps.ObjectResult:
-		return ObjectStatusWrapper{v}
-	case *steps.ChartResult:
-		return ChartStatusWrapper{v}
-	default:
-		return nil
+// keepStatusOf copies the objects, releases, variables, jobs and secrets
+// recorded for the given step from the previous status.
+func keepStatusOf(cr *workflowsv1alpha1.KrateoPlatformOps, prev *workflowsv1alpha1.WorkflowStatus, step string) {
+	for _, x := range prev.ObjectList {
+		if x.Step == step {
+			cr.Status.ObjectList = append(cr.Status.ObjectList, x)
+		}
+	}
+	for _, x := range prev.ReleaseList {
+		if x.Step == step {
+			cr.Status.ReleaseList = append(cr.Status.ReleaseList, x)
+		}
+	}
+	for _, x := range prev.VarList {
+		if x.Step == step {
+			cr.Status.VarList = append(cr.Status.VarList, x)
+		}
+	}
+	for _, x := range prev.JobList {
+		if x.Step == step {
+			cr.Status.JobList = append(cr.Status.JobList, x)
+		}
+	}
+	for _, x := range prev.SecretList {
+		if x.Step == step {
+			cr.Status.SecretList = append(cr.Status.SecretList, x)
+		}
 	}
 }
 
-// populateStatus popola lo status del CR basandosi sui risultati del workflow
-func populateStatus(cr *workflowsv1alpha1.KrateoPlatformOps, results []workflows.StepResult[any]) {
-	// Reset delle liste
-	cr.Status.ObjectList = make([]workflowsv1alpha1.Object, 0)
-	cr.Status.ReleaseList = make([]workflowsv1alpha1.Release, 0)
-	cr.Status.VarList = make([]workflowsv1alpha1.Var, 0)
+// skipUnchanged returns a skip callback for Workflow.Run that skips
+// the steps whose digest matches the one recorded by the previous run.
+// Var steps are always executed since they populate the workflow env,
+// resuming from a checkpoint as well, since their values are not recorded.
+// Approval steps are always checked against the current approvals, and
+// generate steps are executed once the rotation of their Secret is due.
+func skipUnchanged(cr *workflowsv1alpha1.KrateoPlatformOps, wf *workflows.Workflow) func(*workflowsv1alpha1.Step) bool {
+	digests := make(map[string]string, len(cr.Status.Steps))
+	for _, x := range cr.Status.Steps {
+		digests[x.ID] = x.Digest
+	}
 
-	for _, result := range results {
-		if result.Err() != nil {
-			continue // Skip risultati con errori
-		}
+	due := rotationsDue(&cr.Status, time.Now())
 
-		// Usa il wrapper per popolare lo status
-		resultValue := result.Result()
-		if resultValue == nil {
-			continue
+	return func(s *workflowsv1alpha1.Step) bool {
+		if s.Type == workflowsv1alpha1.TypeApproval || s.Type == workflowsv1alpha1.TypeVar || due[s.ID] {
+			return false
 		}
 
-		if wrapper := wrapResultForStatus(resultValue); wrapper != nil {
-			wrapper.PopulateStatus(cr)
+		got, ok := digests[s.ID]
+		return ok && got == wf.Digest(s)
+	}
+}
+
+// rotationsDue returns the steps whose generated Secret is due for rotation.
+func rotationsDue(status *workflowsv1alpha1.WorkflowStatus, now time.Time) map[string]bool {
+	res := map[string]bool{}
+	for _, x := range status.SecretList {
+		if x.RotateAt != nil && !now.Before(x.RotateAt.Time) {
+			res[x.Step] = true
 		}
 	}
+	return res
 }
@@ -0,0 +1,30 @@
+package workflows
+
+import (
+	"testing"
+	"time"
+
+	workflowsv1alpha1 "github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRotationsDue(t *testing.T) {
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *metav1.Time {
+		return &metav1.Time{Time: now.Add(d)}
+	}
+
+	status := &workflowsv1alpha1.WorkflowStatus{
+		SecretList: []workflowsv1alpha1.GeneratedSecretStatus{
+			{Step: "jwt", Name: "jwt-sign-key", RotateAt: at(-time.Minute)},
+			{Step: "tls", Name: "snowplow-tls", RotateAt: at(time.Hour)},
+			{Step: "ca", Name: "krateo-ca"},
+			{Step: "now", Name: "cookie-key", RotateAt: at(0)},
+		},
+	}
+
+	due := rotationsDue(status, now)
+	if len(due) != 2 || !due["jwt"] || !due["now"] {
+		t.Fatalf("expected the rotations of jwt and now to be due, got: %v", due)
+	}
+}
@@ -1,21 +1,50 @@
 package workflows
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"strconv"
+	"strings"
 
 	"github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
-	"github.com/krateoplatformops/installer/internal/helmclient"
+	"github.com/krateoplatformops/installer/pkg/dynamic/getter"
+	"github.com/krateoplatformops/installer/pkg/helmclient"
 	"github.com/krateoplatformops/provider-runtime/pkg/logging"
 	"github.com/twmb/murmur3"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/client-go/rest"
 )
 
-func digestForSteps(cr *v1alpha1.KrateoPlatformOps) string {
+// ownerExists reports whether the KrateoPlatformOps holding a lease,
+// identified as namespace/name, still exists.
+func ownerExists(dyn *getter.Getter) func(ctx context.Context, holder string) (bool, error) {
+	return func(ctx context.Context, holder string) (bool, error) {
+		namespace, name, _ := strings.Cut(holder, "/")
+		_, err := dyn.Get(ctx, getter.GetOptions{
+			GVK:       v1alpha1.KrateoPlatformOpsGroupVersionKind,
+			Namespace: namespace,
+			Name:      name,
+		})
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+}
+
+// digestForSteps hashes the steps, all their fields included, and the
+// workflow settings applying to them: any edit makes Observe report a change.
+func digestForSteps(spec *v1alpha1.WorkflowSpec) string {
 	hasher := murmur3.New64()
+	hasher.Write([]byte(spec.PrunePolicy))
+	hasher.Write([]byte(strconv.FormatBool(spec.RollbackOnFailure)))
 
-	for _, x := range cr.Spec.Steps {
-		hasher.Write([]byte(x.Digest()))
+	for _, x := range spec.Steps {
+		dat, _ := json.Marshal(x)
+		hasher.Write(dat)
+		// omitempty drops an empty dependsOn, which starts the step immediately
+		hasher.Write([]byte(strconv.FormatBool(x.DependsOn != nil)))
 	}
 
 	return strconv.FormatUint(hasher.Sum64(), 16)
@@ -0,0 +1,65 @@
+package workflows
+
+import (
+	"testing"
+	"time"
+
+	workflowsv1alpha1 "github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestDigestForSteps(t *testing.T) {
+	spec := func() *workflowsv1alpha1.WorkflowSpec {
+		return &workflowsv1alpha1.WorkflowSpec{
+			Steps: []*workflowsv1alpha1.Step{
+				{ID: "ns", Type: workflowsv1alpha1.TypeObject, With: &runtime.RawExtension{Raw: []byte(`{"kind": "Namespace"}`)}},
+				{ID: "cm", Type: workflowsv1alpha1.TypeObject, With: &runtime.RawExtension{Raw: []byte(`{"kind": "ConfigMap"}`)}},
+			},
+		}
+	}
+
+	tests := []struct {
+		name string
+		edit func(*workflowsv1alpha1.WorkflowSpec)
+	}{
+		{"with", func(s *workflowsv1alpha1.WorkflowSpec) {
+			s.Steps[1].With = &runtime.RawExtension{Raw: []byte(`{"kind": "Secret"}`)}
+		}},
+		{"type", func(s *workflowsv1alpha1.WorkflowSpec) { s.Steps[1].Type = workflowsv1alpha1.TypeManifest }},
+		{"dependsOn", func(s *workflowsv1alpha1.WorkflowSpec) { s.Steps[1].DependsOn = []string{"ns"} }},
+		{"empty dependsOn", func(s *workflowsv1alpha1.WorkflowSpec) { s.Steps[1].DependsOn = []string{} }},
+		{"when", func(s *workflowsv1alpha1.WorkflowSpec) { s.Steps[1].When = `.vars.ENABLED == "true"` }},
+		{"forEach", func(s *workflowsv1alpha1.WorkflowSpec) {
+			s.Steps[1].ForEach = &workflowsv1alpha1.ForEach{Items: []apiextensionsv1.JSON{{Raw: []byte(`"a"`)}}}
+		}},
+		{"retry", func(s *workflowsv1alpha1.WorkflowSpec) {
+			s.Steps[1].Retry = &workflowsv1alpha1.RetryPolicy{Attempts: 3}
+		}},
+		{"timeout", func(s *workflowsv1alpha1.WorkflowSpec) { s.Steps[1].Timeout = &metav1.Duration{Duration: time.Minute} }},
+		{"onFailure", func(s *workflowsv1alpha1.WorkflowSpec) { s.Steps[1].OnFailure = workflowsv1alpha1.FailureRollback }},
+		{"deletionPolicy", func(s *workflowsv1alpha1.WorkflowSpec) { s.Steps[1].DeletionPolicy = workflowsv1alpha1.DeletionOrphan }},
+		{"prunePolicy", func(s *workflowsv1alpha1.WorkflowSpec) { s.Steps[1].PrunePolicy = workflowsv1alpha1.PruneOrphan }},
+		{"workflow prunePolicy", func(s *workflowsv1alpha1.WorkflowSpec) { s.PrunePolicy = workflowsv1alpha1.PruneOrphan }},
+		{"rollbackOnFailure", func(s *workflowsv1alpha1.WorkflowSpec) { s.RollbackOnFailure = true }},
+		{"step without with", func(s *workflowsv1alpha1.WorkflowSpec) {
+			s.Steps = append(s.Steps, &workflowsv1alpha1.Step{ID: "go", Type: workflowsv1alpha1.TypeApproval})
+		}},
+	}
+
+	exp := digestForSteps(spec())
+	if got := digestForSteps(spec()); got != exp {
+		t.Fatalf("expected a stable digest, got %s and %s", exp, got)
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := spec()
+			tc.edit(s)
+			if digestForSteps(s) == exp {
+				t.Fatalf("expected the digest to change")
+			}
+		})
+	}
+}
@@ -2,20 +2,26 @@ package workflows
 
 import (
 	"context"
+	"fmt"
 	"time"
 
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	workflowsv1alpha1 "github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
-	"github.com/krateoplatformops/installer/internal/dynamic/applier"
-	"github.com/krateoplatformops/installer/internal/dynamic/deletor"
-	"github.com/krateoplatformops/installer/internal/dynamic/getter"
+	"github.com/krateoplatformops/installer/pkg/dynamic/applier"
+	"github.com/krateoplatformops/installer/pkg/dynamic/deletor"
+	"github.com/krateoplatformops/installer/pkg/dynamic/getter"
+	"github.com/krateoplatformops/installer/pkg/locker"
 
 	"github.com/krateoplatformops/installer/internal/workflows"
-	"github.com/krateoplatformops/installer/internal/workflows/steps"
+	"github.com/krateoplatformops/installer/internal/workflows/fragments"
+	"github.com/krateoplatformops/installer/pkg/workflows/steps"
 	"github.com/krateoplatformops/plumbing/env"
 	rtv1 "github.com/krateoplatformops/provider-runtime/apis/common/v1"
 	"github.com/krateoplatformops/provider-runtime/pkg/controller"
@@ -32,14 +38,21 @@ const (
 	errNotCR            = "managed resource is not a KrateoPlatformOps custom resource"
 	creationGracePeriod = 2 * time.Minute
 	reconcileTimeout    = 10 * time.Minute
+	// yieldRequeueInterval is the delay before resuming a workflow
+	// that ran out of time budget.
+	yieldRequeueInterval = 5 * time.Second
 )
 
 const (
-	MAX_HELM_HISTORY_VAR = "MAX_HELM_HISTORY"
+	MAX_HELM_HISTORY_VAR   = "MAX_HELM_HISTORY"
+	MAX_PARALLEL_STEPS_VAR = "MAX_PARALLEL_STEPS"
+	LOCK_NAMESPACE_VAR     = "INSTALLER_LOCK_NAMESPACE"
 )
 
 var (
-	MAX_HELM_HISTORY int // the maximum number of helm releases to keep in history
+	MAX_HELM_HISTORY   int    // the maximum number of helm releases to keep in history
+	MAX_PARALLEL_STEPS int    // the maximum number of workflow steps executed at the same time
+	LOCK_NAMESPACE     string // the namespace of the leases locking releases and objects
 )
 
 func Setup(mgr ctrl.Manager, o controller.Options) error {
@@ -50,6 +63,8 @@ func Setup(mgr ctrl.Manager, o controller.Options) error {
 
 	timeout := env.Duration("INSTALLER_PROVIDER_TIMEOUT", reconcileTimeout)
 	MAX_HELM_HISTORY = env.Int(MAX_HELM_HISTORY_VAR, 10)
+	MAX_PARALLEL_STEPS = env.Int(MAX_PARALLEL_STEPS_VAR, 4)
+	LOCK_NAMESPACE = env.String(LOCK_NAMESPACE_VAR, "")
 
 	r := reconciler.NewReconciler(mgr,
 		resource.ManagedKind(workflowsv1alpha1.KrateoPlatformOpsGroupVersionKind),
@@ -58,10 +73,17 @@ func Setup(mgr ctrl.Manager, o controller.Options) error {
 			log:      log,
 			rc:       mgr.GetConfig(),
 			recorder: recorder,
+			budget:   timeout,
 		}),
 		reconciler.WithTimeout(timeout),
 		reconciler.WithCreationGracePeriod(creationGracePeriod),
 		reconciler.WithPollInterval(o.PollInterval),
+		reconciler.WithPollIntervalHook(func(mg resource.Managed, pollInterval time.Duration) time.Duration {
+			if mg.GetCondition(rtv1.TypeReady).Reason == ReasonYielded {
+				return yieldRequeueInterval
+			}
+			return pollInterval
+		}),
 		reconciler.WithLogger(log),
 		reconciler.WithRecorder(event.NewAPIRecorder(recorder)),
 	)
@@ -78,6 +100,7 @@ type connector struct {
 	rc       *rest.Config
 	log      logging.Logger
 	recorder record.EventRecorder
+	budget   time.Duration
 }
 
 func (c *connector) Connect(ctx context.Context, mg resource.Managed) (reconciler.ExternalClient, error) {
@@ -101,6 +124,11 @@ func (c *connector) Connect(ctx context.Context, mg resource.Managed) (reconcile
 		return nil, errors.Wrap(err, "failed to create dynamic deletor")
 	}
 
+	clientset, err := kubernetes.NewForConfig(c.rc)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create kubernetes client")
+	}
+
 	helmClient, err := newHelmClient(helmClientOptions{
 		namespace:  cr.GetNamespace(),
 		restConfig: c.rc,
@@ -110,6 +138,19 @@ func (c *connector) Connect(ctx context.Context, mg resource.Managed) (reconcile
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create helm client")
 	}
+	lockNamespace := LOCK_NAMESPACE
+	if len(lockNamespace) == 0 {
+		lockNamespace = cr.GetNamespace()
+	}
+	locker, err := locker.NewLocker(c.rc, locker.Options{
+		Namespace: lockNamespace,
+		Holder:    locker.Holder(cr.GetNamespace(), cr.GetName()),
+		Exists:    ownerExists(getter),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create locker")
+	}
+
 	wf, err := workflows.New(workflows.Opts{
 		Getter:         getter,
 		Applier:        applier,
@@ -118,25 +159,37 @@ func (c *connector) Connect(ctx context.Context, mg resource.Managed) (reconcile
 		Log:            log,
 		Namespace:      cr.GetNamespace(),
 		HelmClient:     helmClient,
+		Clientset:      clientset,
+		Parallelism:    MAX_PARALLEL_STEPS,
+		Locker:         locker,
+		Budget:         c.budget,
 	})
 	if err != nil {
 		return nil, err
 	}
 
 	return &external{
-		kube: c.kube,
-		log:  log,
-		wf:   wf,
-		rec:  c.recorder,
+		kube:      c.kube,
+		log:       log,
+		wf:        wf,
+		rec:       c.recorder,
+		fragments: fragments.NewResolver(getter, cr.GetNamespace()),
+		secrets:   clientset.CoreV1(),
 	}, nil
 
 }
 
 type external struct {
-	kube client.Client
-	log  logging.Logger
-	wf   *workflows.Workflow
-	rec  record.EventRecorder
+	kube      client.Client
+	log       logging.Logger
+	wf        *workflows.Workflow
+	rec       record.EventRecorder
+	fragments *fragments.Resolver
+	// secrets holds the outputs Secret, read directly: the client of
+	// the manager would cache all the Secrets of the cluster.
+	secrets corev1client.SecretsGetter
+	// spec is the workflow spec with the include steps resolved by Observe.
+	spec *workflowsv1alpha1.WorkflowSpec
 }
 
 func (e *external) Observe(ctx context.Context, mg resource.Managed) (reconciler.ExternalObservation, error) {
@@ -157,9 +210,46 @@ func (e *external) Observe(ctx context.Context, mg resource.Managed) (reconciler
 		}, nil
 	}
 
-	exp := digestForSteps(cr)
+	spec, err := e.fragments.Resolve(ctx, &cr.Spec)
+	if err != nil && meta.WasDeleted(cr) {
+		// i.e. the ConfigMap of a fragment went away with the chart that shipped it:
+		// delete the other steps instead of holding the finalizer forever
+		spec, err = e.fragments.ResolveAvailable(ctx, &cr.Spec)
+		if spec != nil && err != nil {
+			log.Warn("Deleting without the steps of unresolved fragments", "reason", err.Error())
+			e.rec.Event(cr, corev1.EventTypeWarning, "FragmentsUnresolved",
+				fmt.Sprintf("The releases and objects of these fragments are left in place: %s", err.Error()))
+			err = nil
+		}
+	}
+	if err != nil {
+		return reconciler.ExternalObservation{}, errors.Wrap(err, "failed to resolve workflow fragments")
+	}
+	e.spec = spec
+
+	// the steps of unknown types are skipped on delete
+	if !meta.WasDeleted(cr) {
+		if err := e.wf.Validate(e.spec); err != nil {
+			return reconciler.ExternalObservation{}, errors.Wrap(err, "invalid workflow")
+		}
+	}
+
+	exp := digestForSteps(e.spec)
+
+	if isPlanMode(cr) {
+		upToDate := cr.Status.Plan != nil && cr.Status.Plan.Digest == exp
+		if upToDate {
+			cr.SetConditions(Planned())
+		}
 
-	upToDate := (exp == got)
+		return reconciler.ExternalObservation{
+			ResourceExists:   true,
+			ResourceUpToDate: upToDate,
+		}, nil
+	}
+
+	// the steps rotating a generated Secret run again once it is due
+	upToDate := (exp == got) && len(rotationsDue(&cr.Status, time.Now())) == 0
 	if upToDate {
 		cr.SetConditions(rtv1.Available())
 
@@ -192,6 +282,11 @@ func (e *external) Create(ctx context.Context, mg resource.Managed) error {
 		return nil
 	}
 
+	if isPlanMode(cr) {
+		log.Info("Planning resource")
+		return e.plan(ctx, cr)
+	}
+
 	log.Info("Creating resource")
 
 	cr.SetConditions(rtv1.Creating())
@@ -203,16 +298,54 @@ func (e *external) Create(ctx context.Context, mg resource.Managed) error {
 
 	e.wf.Op(steps.Create)
 
-	results := e.wf.Run(ctx, cr.Spec.DeepCopy(), func(s *workflowsv1alpha1.Step) bool {
-		return false
-	})
+	// the outputs of the steps skipped as unchanged keep the recorded values
+	if err := e.restore(ctx, cr); err != nil {
+		return err
+	}
+	if cp := cr.Status.Checkpoint; cp != nil {
+		log.Info("Resuming workflow", "failed", cp.Failed, "last", cp.Step)
+	}
+	e.wf.Approve(approvedSteps(cr, digestForSteps(e.spec))...)
+	results := e.wf.Run(ctx, e.spec.DeepCopy(), skipUnchanged(cr, e.wf))
 	if err := workflows.Err(results); err != nil {
+		if errors.Is(err, workflows.ErrBudgetExceeded) {
+			log.Info("Workflow time budget exceeded, resuming at next reconcile", "reason", err.Error())
+			cr.SetConditions(Yielded(err))
+			return e.checkpoint(ctx, cr, results)
+		}
+
+		var ae *workflows.ApprovalError
+		if errors.As(err, &ae) {
+			log.Info("Workflow awaiting approval", "step", ae.StepID)
+			cr.SetConditions(AwaitingApproval(approvalHint(cr, digestForSteps(e.spec), ae)))
+			return e.checkpoint(ctx, cr, results)
+		}
+
 		log.Error(err, "Workflow failure")
+		var ce *locker.ConflictError
+		if errors.As(err, &ce) {
+			cr.SetConditions(OwnershipConflict(err))
+			e.rec.Event(cr, corev1.EventTypeWarning, "OwnershipConflict", err.Error())
+		} else if steps.IsPermanent(err) {
+			cr.SetConditions(StepFailed(err))
+		}
+		if err := e.checkpoint(ctx, cr, results); err != nil {
+			log.Error(err, "Failed to record workflow checkpoint")
+		}
 		return err
 	}
 
 	// Popola lo status con i risultati
-	populateStatus(cr, results)
+	prev := cr.Status.DeepCopy()
+	populateStatus(cr, e.spec, results)
+	if err := e.storeSensitive(ctx, cr, prev); err != nil {
+		return err
+	}
+
+	if err := e.prune(ctx, cr, prev); err != nil {
+		log.Error(err, "Workflow prune failure")
+		return err
+	}
 
 	log.Info(
 		"Workflow completed successfully",
@@ -220,7 +353,10 @@ func (e *external) Create(ctx context.Context, mg resource.Managed) error {
 	)
 
 	cr.SetConditions(rtv1.Available())
-	cr.Status.Digest = digestForSteps(cr)
+	cr.Status.Digest = digestForSteps(e.spec)
+	cr.Status.Checkpoint = nil
+	cr.Status.RolledBack = nil
+	cr.Status.Plan = nil
 	return e.kube.Status().Update(ctx, cr)
 }
 
@@ -241,6 +377,11 @@ func (e *external) Update(ctx context.Context, mg resource.Managed) error {
 		return nil
 	}
 
+	if isPlanMode(cr) {
+		log.Info("Planning resource")
+		return e.plan(ctx, cr)
+	}
+
 	// Set creatimg condition to show that update is in progress
 	cr.SetConditions(rtv1.Creating())
 	err := e.kube.Status().Update(ctx, cr)
@@ -251,19 +392,60 @@ func (e *external) Update(ctx context.Context, mg resource.Managed) error {
 
 	log.Info("Updating resource")
 	e.wf.Op(steps.Update)
-	results := e.wf.Run(ctx, cr.Spec.DeepCopy(), func(s *workflowsv1alpha1.Step) bool {
-		return false
-	})
+	// the outputs of the steps skipped as unchanged keep the recorded values
+	if err := e.restore(ctx, cr); err != nil {
+		return err
+	}
+	if cp := cr.Status.Checkpoint; cp != nil {
+		log.Info("Resuming workflow", "failed", cp.Failed, "last", cp.Step)
+	}
+	e.wf.Approve(approvedSteps(cr, digestForSteps(e.spec))...)
+	results := e.wf.Run(ctx, e.spec.DeepCopy(), skipUnchanged(cr, e.wf))
 	if err := workflows.Err(results); err != nil {
+		if errors.Is(err, workflows.ErrBudgetExceeded) {
+			log.Info("Workflow time budget exceeded, resuming at next reconcile", "reason", err.Error())
+			cr.SetConditions(Yielded(err))
+			return e.checkpoint(ctx, cr, results)
+		}
+
+		var ae *workflows.ApprovalError
+		if errors.As(err, &ae) {
+			log.Info("Workflow awaiting approval", "step", ae.StepID)
+			cr.SetConditions(AwaitingApproval(approvalHint(cr, digestForSteps(e.spec), ae)))
+			return e.checkpoint(ctx, cr, results)
+		}
+
 		log.Error(err, "Workflow failure")
+		var ce *locker.ConflictError
+		if errors.As(err, &ce) {
+			cr.SetConditions(OwnershipConflict(err))
+			e.rec.Event(cr, corev1.EventTypeWarning, "OwnershipConflict", err.Error())
+		} else if steps.IsPermanent(err) {
+			cr.SetConditions(StepFailed(err))
+		}
+		if err := e.checkpoint(ctx, cr, results); err != nil {
+			log.Error(err, "Failed to record workflow checkpoint")
+		}
 		return err
 	}
 
 	// Popola lo status con i risultati
-	populateStatus(cr, results)
+	prev := cr.Status.DeepCopy()
+	populateStatus(cr, e.spec, results)
+	if err := e.storeSensitive(ctx, cr, prev); err != nil {
+		return err
+	}
+
+	if err := e.prune(ctx, cr, prev); err != nil {
+		log.Error(err, "Workflow prune failure")
+		return err
+	}
 
 	cr.SetConditions(rtv1.Available())
-	cr.Status.Digest = digestForSteps(cr)
+	cr.Status.Digest = digestForSteps(e.spec)
+	cr.Status.Checkpoint = nil
+	cr.Status.RolledBack = nil
+	cr.Status.Plan = nil
 
 	log.Info(
 		"Workflow completed successfully",
@@ -273,6 +455,31 @@ func (e *external) Update(ctx context.Context, mg resource.Managed) error {
 	return e.kube.Status().Update(ctx, cr)
 }
 
+// checkpoint records the steps completed by a run that didn't complete,
+// so that the next reconcile resumes from the step that failed.
+func (e *external) checkpoint(ctx context.Context, cr *workflowsv1alpha1.KrateoPlatformOps, results []workflows.StepResult[any]) error {
+	prev := cr.Status.DeepCopy()
+	populateStatus(cr, e.spec, results)
+	keepNotReached(cr, prev, results)
+	if err := e.storeSensitive(ctx, cr, prev); err != nil {
+		return err
+	}
+
+	cr.Status.Checkpoint = checkpointOf(results)
+	cr.Status.RolledBack = rolledBackOf(results)
+	for _, x := range cr.Status.RolledBack {
+		if len(x.Error) > 0 {
+			e.rec.Event(cr, corev1.EventTypeWarning, "RollbackFailed",
+				fmt.Sprintf("Rollback of step %s failed: %s", x.Step, x.Error))
+			continue
+		}
+		e.rec.Event(cr, corev1.EventTypeNormal, "RolledBack",
+			fmt.Sprintf("Rolled back step %s", x.Step))
+	}
+
+	return e.kube.Status().Update(ctx, cr)
+}
+
 func (e *external) Delete(ctx context.Context, mg resource.Managed) error {
 	cr, ok := mg.(*workflowsv1alpha1.KrateoPlatformOps)
 	if !ok {
@@ -294,17 +501,42 @@ func (e *external) Delete(ctx context.Context, mg resource.Managed) error {
 		return err
 	}
 
+	// var steps are not run on delete, use the values resolved by the last run
+	if err := e.restore(ctx, cr); err != nil {
+		return err
+	}
+	e.wf.Applied(appliedSteps(&cr.Status)...)
+	// the steps applying a set of objects delete the ones recorded
+	e.wf.RestoreObjects(cr.Status.ObjectList)
+
+	if err := e.runOnDelete(ctx, cr); err != nil {
+		log.Error(err, "Workflow onDelete failure")
+		if err := e.kube.Status().Update(ctx, cr); err != nil {
+			log.Error(err, "Failed to record onDelete results")
+		}
+		return err
+	}
+
 	e.wf.Op(steps.Delete)
-	results := e.wf.Run(ctx, cr.Spec.DeepCopy(), func(s *workflowsv1alpha1.Step) bool {
-		return s.Type == workflowsv1alpha1.TypeVar
-	})
+	results := e.wf.Run(ctx, e.spec.DeepCopy(), skipOnDelete)
 
 	err = workflows.Err(results)
 	if err != nil {
 		log.Error(err, "Workflow failure")
+		if err := e.kube.Status().Update(ctx, cr); err != nil {
+			log.Error(err, "Failed to record onDelete results")
+		}
 		return err
 	}
 
+	// the releases and objects left in place can be taken over by other workflows
+	if kept := deletionOrphansOf(e.spec, &cr.Status); !kept.empty() {
+		if err := e.wf.Disown(ctx, kept.releases, kept.objects); err != nil {
+			log.Error(err, "Failed to release the leases of the orphaned steps")
+			return err
+		}
+	}
+
 	cr.SetConditions(rtv1.Deleting())
 	cr.Status.Digest = ""
 
@@ -1,5 +1,10 @@
 package expand
 
+import (
+	"encoding/json"
+	"sort"
+)
+
 // Modified copy of os/env.go. The Go LICENSE file is included below:
 
 // Copyright (c) 2009 The Go Authors. All rights reserved.
@@ -61,6 +66,56 @@ func Expand(s, escape string, mapping func(string) string) string {
 	return string(buf) + s[i:]
 }
 
+// Known replaces the variables for which lookup returns true, the
+// references to other names are left exactly as they are written,
+// i.e. the shell variables of a script.
+func Known(s string, lookup func(string) (string, bool)) string {
+	buf := make([]byte, 0, 2*len(s))
+	i := 0
+	for j := 0; j < len(s); j++ {
+		if s[j] == '$' && j+1 < len(s) {
+			buf = append(buf, s[i:j]...)
+			name, w := variableName(s[j+1:])
+			value, ok := "", false
+			if len(name) > 0 {
+				value, ok = lookup(name)
+			}
+			if !ok {
+				value = s[j : j+w+1]
+			}
+			buf = append(buf, value...)
+			j += w
+			i = j + 1
+		}
+	}
+	return string(buf) + s[i:]
+}
+
+// JSONEscape escapes s to be embedded in a JSON string.
+func JSONEscape(s string) string {
+	dat, _ := json.Marshal(s)
+	return string(dat[1 : len(dat)-1])
+}
+
+// Vars returns the sorted names of the variables referenced in s.
+func Vars(s string) []string {
+	seen := map[string]struct{}{}
+	Expand(s, "", func(k string) string {
+		if len(k) > 0 {
+			seen[k] = struct{}{}
+		}
+		return ""
+	})
+
+	res := make([]string, 0, len(seen))
+	for k := range seen {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+
+	return res
+}
+
 func isAlphaNum(c uint8) bool {
 	return c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
 }
@@ -1,6 +1,9 @@
 package expand
 
-import "testing"
+import (
+	"fmt"
+	"testing"
+)
 
 func TestExpand(t *testing.T) {
 	table := []struct {
@@ -36,3 +39,59 @@ func TestExpand(t *testing.T) {
 		}
 	}
 }
+
+func TestVars(t *testing.T) {
+	table := []struct {
+		in   string
+		want string
+	}{
+		{
+			in:   "https://$HOST:$PORT/$HOST",
+			want: "[HOST PORT]",
+		},
+		{
+			in:   `{"name": "url", "value": "${API_URL}/v1"}`,
+			want: "[API_URL]",
+		},
+		{
+			in:   "no vars here",
+			want: "[]",
+		},
+	}
+
+	for i, tc := range table {
+		got := fmt.Sprint(Vars(tc.in))
+		if got != tc.want {
+			t.Fatalf("[tc: %d] - got: %v, expected: %v", i, got, tc.want)
+		}
+	}
+}
+
+func TestKnown(t *testing.T) {
+	env := map[string]string{
+		"HOST": "domain.com",
+		"PORT": "8080",
+		"PASS": `p"ss`,
+	}
+	lookup := func(k string) (string, bool) {
+		v, ok := env[k]
+		return JSONEscape(v), ok
+	}
+
+	table := []struct {
+		in   string
+		want string
+	}{
+		{in: "https://$HOST:${PORT}", want: "https://domain.com:8080"},
+		{in: "echo $HOME ${USER} $1", want: "echo $HOME ${USER} $1"},
+		{in: "cost: 5$, ${", want: "cost: 5$, ${"},
+		{in: `{"password": "$PASS"}`, want: `{"password": "p\"ss"}`},
+		{in: "trailing $", want: "trailing $"},
+	}
+
+	for i, tc := range table {
+		if got := Known(tc.in, lookup); got != tc.want {
+			t.Fatalf("[tc: %d] - got: %v, expected: %v", i, got, tc.want)
+		}
+	}
+}
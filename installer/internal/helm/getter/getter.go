@@ -47,6 +47,15 @@ func Get(opts GetOptions) ([]byte, string, error) {
 	return nil, "", fmt.Errorf("no handler found for url: %s", opts.URI)
 }
 
+// Fetch returns the content of an http(s) URL as is, i.e. a YAML manifest.
+func Fetch(opts GetOptions) ([]byte, error) {
+	if !isHTTP(opts.URI) {
+		return nil, fmt.Errorf("uri '%s' is not a valid http(s) url", opts.URI)
+	}
+
+	return fetch(opts)
+}
+
 func fetch(opts GetOptions) ([]byte, error) {
 	req, err := http.NewRequest(http.MethodGet, opts.URI, nil)
 	if err != nil {
@@ -9,11 +9,13 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 
-	"github.com/krateoplatformops/installer/internal/dynamic/getter"
+	"github.com/krateoplatformops/installer/pkg/dynamic/getter"
 )
 
-func GetSecret(ctx context.Context, dyn getter.Getter, secretKeySelector rtv1.SecretKeySelector) (string, error) {
-	uns, err := dyn.Get(ctx, getter.GetOptions{
+// GetSecret returns the decoded value of the key of a Secret, read with get,
+// i.e. the Get of a getter.Getter.
+func GetSecret(ctx context.Context, get func(context.Context, getter.GetOptions) (*unstructured.Unstructured, error), secretKeySelector rtv1.SecretKeySelector) (string, error) {
+	uns, err := get(ctx, getter.GetOptions{
 		GVK:       corev1.SchemeGroupVersion.WithKind("Secret"),
 		Namespace: secretKeySelector.Namespace,
 		Name:      secretKeySelector.Name,
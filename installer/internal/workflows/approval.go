@@ -0,0 +1,58 @@
+package workflows
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+	"github.com/krateoplatformops/installer/pkg/workflows/steps"
+)
+
+// ApprovalError is returned for an approval step not approved yet.
+// The workflow is expected to resume from that step once approved.
+type ApprovalError struct {
+	StepID  string
+	Message string
+}
+
+func (e *ApprovalError) Error() string {
+	if len(e.Message) == 0 {
+		return fmt.Sprintf("step %s awaits approval", e.StepID)
+	}
+	return fmt.Sprintf("step %s awaits approval: %s", e.StepID, e.Message)
+}
+
+// Approve marks the approval steps with the given ids as approved.
+func (wf *Workflow) Approve(ids ...string) {
+	if wf.approved == nil {
+		wf.approved = make(map[string]bool, len(ids))
+	}
+	for _, id := range ids {
+		wf.approved[id] = true
+	}
+}
+
+// checkApproval fails with an ApprovalError if the step has not been
+// approved. Approvals are not required on Delete and in plan mode.
+func (wf *Workflow) checkApproval(x *v1alpha1.Step) error {
+	if wf.op == steps.Delete || wf.dryRun || wf.approved[x.ID] {
+		return nil
+	}
+
+	spec := v1alpha1.ApprovalSpec{}
+	if x.With != nil && len(x.With.Raw) > 0 {
+		if err := json.Unmarshal(x.With.Raw, &spec); err != nil {
+			return err
+		}
+	}
+
+	return &ApprovalError{StepID: x.ID, Message: spec.Message}
+}
+
+// isSuspended reports whether err stopped the run without a failure,
+// so that it resumes later from the same step.
+func isSuspended(err error) bool {
+	var ae *ApprovalError
+	return errors.Is(err, ErrBudgetExceeded) || errors.As(err, &ae)
+}
@@ -0,0 +1,80 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+	"github.com/krateoplatformops/installer/pkg/workflows/steps"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func approvalSteps() *v1alpha1.WorkflowSpec {
+	spec := objectSteps(map[string][]string{"b": {"gate"}}, "a", "b")
+	spec.Steps = append(spec.Steps, &v1alpha1.Step{
+		ID: "gate", Type: v1alpha1.TypeApproval, DependsOn: []string{"a"},
+		With: &runtime.RawExtension{Raw: []byte(`{"message":"check the staging cluster first"}`)},
+	})
+	spec.RollbackOnFailure = true
+	return spec
+}
+
+func TestRunApprovalPending(t *testing.T) {
+	hdl := &undoHandler{}
+	wf := newUndoWorkflow(hdl)
+
+	results := wf.Run(context.Background(), approvalSteps(), noSkip)
+
+	var ae *ApprovalError
+	if err := Err(results); !errors.As(err, &ae) {
+		t.Fatalf("expected approval error, got: %v", err)
+	}
+	if ae.StepID != "gate" || ae.Message != "check the staging cluster first" {
+		t.Fatalf("unexpected approval error: %+v", ae)
+	}
+	// awaiting approval is not a failure, nothing is rolled back
+	if got := fmt.Sprint(hdl.calls); got != "[a]" {
+		t.Fatalf("got: %s, expected: [a]", got)
+	}
+}
+
+func TestRunApproved(t *testing.T) {
+	hdl := &undoHandler{}
+	wf := newUndoWorkflow(hdl)
+	wf.Approve("gate")
+
+	results := wf.Run(context.Background(), approvalSteps(), noSkip)
+	if err := Err(results); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(hdl.calls); got != "[a b]" {
+		t.Fatalf("got: %s, expected: [a b]", got)
+	}
+}
+
+func TestRunApprovalNotRequired(t *testing.T) {
+	tests := []struct {
+		name   string
+		op     steps.Op
+		dryRun bool
+	}{
+		{name: "delete", op: steps.Delete},
+		{name: "plan", op: steps.Update, dryRun: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			hdl := &planHandler{}
+			wf := newFakeWorkflow(&hdl.fakeHandler, 1, tc.op)
+			setObjectHandler(wf, hdl)
+			wf.DryRun(tc.dryRun)
+
+			results := wf.Run(context.Background(), approvalSteps(), noSkip)
+			if err := Err(results); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
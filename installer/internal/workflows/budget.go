@@ -0,0 +1,102 @@
+package workflows
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ErrBudgetExceeded is returned for a step not started because the time
+// left to the reconcile is not enough to complete it. The workflow is
+// expected to resume from that step at the next reconcile.
+var ErrBudgetExceeded = errors.New("workflow time budget exceeded")
+
+// budgetReserve is the time kept aside from the reconcile deadline
+// for recording the results of the workflow.
+const budgetReserve = 30 * time.Second
+
+// defaultEstimates are the times a step without timeout is expected to
+// take, the defaults of the waits the step types perform.
+var defaultEstimates = map[v1alpha1.StepType]time.Duration{
+	v1alpha1.TypeChart: 10 * time.Minute,
+	v1alpha1.TypeJob:   10 * time.Minute,
+	v1alpha1.TypeWait:  5 * time.Minute,
+	v1alpha1.TypeHTTP:  30 * time.Second,
+}
+
+// estimate returns the time a step is expected to take at most: its timeout
+// if set, the wait declared in the with block or the default for its type
+// otherwise. Zero means unknown. Since only the step timeout bounds the
+// step, the other estimates are capped to what a reconcile is given,
+// budget, so that the step can start on the next one.
+func estimate(x *v1alpha1.Step, budget time.Duration) time.Duration {
+	if x.Timeout != nil {
+		return x.Timeout.Duration
+	}
+
+	res := waitOf(x)
+	if budget > 0 {
+		res = min(res, budget-budgetReserve)
+	}
+	return max(res, 0)
+}
+
+// waitOf returns the wait declared in the with block of a step,
+// or the default for its type.
+func waitOf(x *v1alpha1.Step) time.Duration {
+	if x.With != nil && len(x.With.Raw) > 0 {
+		with := struct {
+			Timeout     *metav1.Duration `json:"timeout,omitempty"`
+			WaitTimeout *metav1.Duration `json:"waitTimeout,omitempty"`
+		}{}
+		// variables are not replaced yet, an invalid duration means the default
+		if err := json.Unmarshal(x.With.Raw, &with); err == nil {
+			if x.Type == v1alpha1.TypeChart && with.WaitTimeout != nil && with.WaitTimeout.Duration > 0 {
+				return with.WaitTimeout.Duration
+			}
+			if x.Type != v1alpha1.TypeChart && with.Timeout != nil && with.Timeout.Duration > 0 {
+				return with.Timeout.Duration
+			}
+		}
+	}
+
+	return defaultEstimates[x.Type]
+}
+
+// stepContext returns the context for executing a step: it expires when
+// the step timeout elapses or when the reconcile budget is exhausted,
+// whichever comes first. A step is not started if the budget left is
+// shorter than its estimate.
+func stepContext(ctx context.Context, x *v1alpha1.Step, budget time.Duration) (context.Context, context.CancelFunc, error) {
+	var timeout time.Duration
+	if x.Timeout != nil {
+		timeout = x.Timeout.Duration
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		if timeout > 0 {
+			sub, cancel := context.WithTimeout(ctx, timeout)
+			return sub, cancel, nil
+		}
+		return ctx, func() {}, nil
+	}
+
+	left := time.Until(deadline) - budgetReserve
+	if needs := estimate(x, budget); left <= 0 || left < needs {
+		return nil, nil, fmt.Errorf("%w: step needs %s, %s left",
+			ErrBudgetExceeded, needs, max(left, 0).Round(time.Second))
+	}
+
+	if timeout == 0 {
+		timeout = left
+	}
+
+	sub, cancel := context.WithTimeout(ctx, timeout)
+	return sub, cancel, nil
+}
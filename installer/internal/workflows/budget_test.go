@@ -0,0 +1,140 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+	"github.com/krateoplatformops/installer/pkg/workflows/steps"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestStepContext(t *testing.T) {
+	step := func(d time.Duration) *v1alpha1.Step {
+		x := &v1alpha1.Step{ID: "a"}
+		if d > 0 {
+			x.Timeout = &metav1.Duration{Duration: d}
+		}
+		return x
+	}
+
+	table := []struct {
+		budget  time.Duration
+		timeout time.Duration
+		// expected deadline from now, zero if none
+		want time.Duration
+		err  bool
+	}{
+		{budget: 0, timeout: 0, want: 0},
+		{budget: 0, timeout: time.Minute, want: time.Minute},
+		{budget: time.Hour, timeout: time.Minute, want: time.Minute},
+		{budget: time.Hour, timeout: 0, want: time.Hour - budgetReserve},
+		{budget: time.Hour, timeout: 2 * time.Hour, err: true},
+		{budget: budgetReserve / 2, timeout: 0, err: true},
+	}
+
+	for i, tc := range table {
+		ctx := context.Background()
+		if tc.budget > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, tc.budget)
+			defer cancel()
+		}
+
+		sub, cancel, err := stepContext(ctx, step(tc.timeout), 0)
+		if tc.err {
+			if !errors.Is(err, ErrBudgetExceeded) {
+				t.Fatalf("[tc: %d] - got: %v, expected: %v", i, err, ErrBudgetExceeded)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("[tc: %d] - unexpected error: %v", i, err)
+		}
+		defer cancel()
+
+		deadline, ok := sub.Deadline()
+		if tc.want == 0 {
+			if ok {
+				t.Fatalf("[tc: %d] - unexpected deadline: %v", i, deadline)
+			}
+			continue
+		}
+		if got := time.Until(deadline); got > tc.want || got < tc.want-time.Second {
+			t.Fatalf("[tc: %d] - got: %v, expected: %v", i, got, tc.want)
+		}
+	}
+}
+
+func TestEstimate(t *testing.T) {
+	step := func(typ v1alpha1.StepType, with string) *v1alpha1.Step {
+		return &v1alpha1.Step{ID: "a", Type: typ, With: &runtime.RawExtension{Raw: []byte(with)}}
+	}
+
+	table := []struct {
+		step   *v1alpha1.Step
+		budget time.Duration
+		want   time.Duration
+	}{
+		{step: step(v1alpha1.TypeObject, `{}`), want: 0},
+		{step: step(v1alpha1.TypeChart, `{}`), want: 10 * time.Minute},
+		{step: step(v1alpha1.TypeChart, `{"waitTimeout": "3m"}`), want: 3 * time.Minute},
+		{step: step(v1alpha1.TypeChart, `{"waitTimeout": "$WAIT"}`), want: 10 * time.Minute},
+		{step: step(v1alpha1.TypeJob, `{"timeout": "2m"}`), want: 2 * time.Minute},
+		{step: step(v1alpha1.TypeWait, `{}`), want: 5 * time.Minute},
+		{step: step(v1alpha1.TypeChart, `{}`), budget: 10 * time.Minute, want: 10*time.Minute - budgetReserve},
+		{step: &v1alpha1.Step{ID: "a", Type: v1alpha1.TypeChart, Timeout: &metav1.Duration{Duration: time.Hour}}, budget: 10 * time.Minute, want: time.Hour},
+	}
+
+	for i, tc := range table {
+		if got := estimate(tc.step, tc.budget); got != tc.want {
+			t.Fatalf("[tc: %d] - got: %v, expected: %v", i, got, tc.want)
+		}
+	}
+}
+
+func TestRunYieldsBeforeChartWithoutTimeout(t *testing.T) {
+	hdl := &fakeHandler{}
+	wf := newFakeWorkflow(hdl, 1, steps.Create)
+
+	spec := objectSteps(nil, "a", "b")
+	spec.Steps[1].Type = v1alpha1.TypeChart
+	spec.Steps[1].With = &runtime.RawExtension{Raw: []byte(`{"waitTimeout": "20m"}`)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	results := wf.Run(ctx, spec, noSkip)
+	if err := Err(results); !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("got: %v, expected: %v", err, ErrBudgetExceeded)
+	}
+	if len(hdl.calls) != 1 || hdl.calls[0] != "a" {
+		t.Fatalf("expected only step a to be executed, got: %v", hdl.calls)
+	}
+}
+
+func TestRunYieldsWhenBudgetExceeded(t *testing.T) {
+	hdl := &fakeHandler{}
+	wf := newFakeWorkflow(hdl, 1, steps.Create)
+
+	spec := objectSteps(nil, "a", "b")
+	spec.Steps[1].Timeout = &metav1.Duration{Duration: 2 * time.Hour}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+
+	results := wf.Run(ctx, spec, noSkip)
+	if err := Err(results); !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("got: %v, expected: %v", err, ErrBudgetExceeded)
+	}
+
+	if len(hdl.calls) != 1 || hdl.calls[0] != "a" {
+		t.Fatalf("expected only step a to be executed, got: %v", hdl.calls)
+	}
+	if results[0].Err() != nil || results[0].Phase() != v1alpha1.StepSucceeded {
+		t.Fatalf("expected step a to succeed, got: %v", results[0].Err())
+	}
+}
@@ -0,0 +1,31 @@
+package workflows
+
+import (
+	"github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+)
+
+// Var returns the value of a workflow variable.
+func (wf *Workflow) Var(name string) (string, bool) {
+	return wf.env.Get(name)
+}
+
+// Restore sets the workflow variables recorded by the last run, so that
+// it can skip (or delete) the steps using them without executing again
+// the steps defining them.
+func (wf *Workflow) Restore(env []v1alpha1.Data) {
+	for _, x := range env {
+		wf.env.Set(x.Name, x.Value)
+	}
+}
+
+// RestoreObjects sets the objects recorded by the last run for each step,
+// so that the steps applying a set of objects delete the ones they applied.
+func (wf *Workflow) RestoreObjects(all []v1alpha1.ObjectStatus) {
+	for _, x := range all {
+		if len(x.Step) == 0 {
+			continue
+		}
+		got, _ := wf.inventory.Get(x.Step)
+		wf.inventory.Set(x.Step, append(got, x.ObjectMeta))
+	}
+}
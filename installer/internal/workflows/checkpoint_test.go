@@ -0,0 +1,30 @@
+package workflows
+
+import (
+	"testing"
+
+	"github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+	"github.com/krateoplatformops/installer/pkg/workflows/steps"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestVarsRestore(t *testing.T) {
+	src := newFakeWorkflow(&fakeHandler{}, 1, steps.Create)
+	src.env.Set("B", "2")
+	src.env.Set("A", "1")
+
+	x := &v1alpha1.Step{
+		ID: "a", Type: v1alpha1.TypeObject,
+		With: &runtime.RawExtension{Raw: []byte(`{"name": "$A-$B"}`)},
+	}
+
+	dst := newFakeWorkflow(&fakeHandler{}, 1, steps.Create)
+	if src.Digest(x) == dst.Digest(x) {
+		t.Fatal("expected digests to differ before restore")
+	}
+
+	dst.Restore([]v1alpha1.Data{{Name: "A", Value: "1"}, {Name: "B", Value: "2"}})
+	if got, want := dst.Digest(x), src.Digest(x); got != want {
+		t.Fatalf("got: %s, expected: %s", got, want)
+	}
+}
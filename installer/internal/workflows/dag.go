@@ -0,0 +1,137 @@
+package workflows
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+)
+
+// graph holds the ordering constraints between the steps of a workflow.
+// Nodes are the indexes of the steps in the spec.
+type graph struct {
+	// deps[i] lists the steps that must complete before step i starts.
+	deps [][]int
+	// next[i] lists the steps waiting for step i to complete.
+	next [][]int
+}
+
+// GraphError reports a workflow spec whose dependencies can't be resolved.
+type GraphError struct {
+	StepID string
+	Msg    string
+}
+
+func (e *GraphError) Error() string {
+	return e.Msg
+}
+
+// newGraph builds the dependency graph for the given steps.
+// A step that declares dependsOn waits only for the listed steps,
+// otherwise it waits for all the steps declared before it.
+func newGraph(list []*v1alpha1.Step) (*graph, error) {
+	index := make(map[string]int, len(list))
+	for i, x := range list {
+		if _, ok := index[x.ID]; ok {
+			return nil, &GraphError{StepID: x.ID, Msg: fmt.Sprintf("duplicate step id %q", x.ID)}
+		}
+		index[x.ID] = i
+	}
+
+	g := &graph{
+		deps: make([][]int, len(list)),
+		next: make([][]int, len(list)),
+	}
+
+	for i, x := range list {
+		if x.DependsOn == nil {
+			for j := 0; j < i; j++ {
+				g.link(j, i)
+			}
+			continue
+		}
+
+		for _, id := range x.DependsOn {
+			j, ok := index[id]
+			if !ok {
+				return nil, &GraphError{StepID: x.ID,
+					Msg: fmt.Sprintf("step %q depends on unknown step %q", x.ID, id)}
+			}
+			if j == i {
+				return nil, &GraphError{StepID: x.ID,
+					Msg: fmt.Sprintf("step %q depends on itself", x.ID)}
+			}
+			g.link(j, i)
+		}
+	}
+
+	if cycle := g.cycle(); len(cycle) > 0 {
+		ids := make([]string, len(cycle))
+		for k, i := range cycle {
+			ids[k] = list[i].ID
+		}
+		return nil, &GraphError{StepID: ids[0],
+			Msg: fmt.Sprintf("dependency cycle detected: %s", strings.Join(ids, " -> "))}
+	}
+
+	return g, nil
+}
+
+func (g *graph) link(from, to int) {
+	g.deps[to] = append(g.deps[to], from)
+	g.next[from] = append(g.next[from], to)
+}
+
+// reverse returns the graph with all the edges inverted, so that
+// a step starts only after all the steps depending on it completed.
+func (g *graph) reverse() *graph {
+	return &graph{deps: g.next, next: g.deps}
+}
+
+// cycle returns the nodes of a dependency cycle, if any.
+func (g *graph) cycle() []int {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	state := make([]int, len(g.deps))
+	stack := []int{}
+
+	var visit func(i int) []int
+	visit = func(i int) []int {
+		state[i] = visiting
+		stack = append(stack, i)
+
+		for _, j := range g.deps[i] {
+			switch state[j] {
+			case visiting:
+				for k := len(stack) - 1; k >= 0; k-- {
+					if stack[k] == j {
+						cycle := append([]int{}, stack[k:]...)
+						return append(cycle, j)
+					}
+				}
+			case unvisited:
+				if cycle := visit(j); len(cycle) > 0 {
+					return cycle
+				}
+			}
+		}
+
+		stack = stack[:len(stack)-1]
+		state[i] = visited
+		return nil
+	}
+
+	for i := range g.deps {
+		if state[i] == unvisited {
+			if cycle := visit(i); len(cycle) > 0 {
+				return cycle
+			}
+		}
+	}
+
+	return nil
+}
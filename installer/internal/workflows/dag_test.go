@@ -0,0 +1,104 @@
+package workflows
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+)
+
+func TestNewGraph(t *testing.T) {
+	table := []struct {
+		steps []*v1alpha1.Step
+		deps  [][]int
+		err   string
+	}{
+		{
+			steps: []*v1alpha1.Step{
+				{ID: "a"}, {ID: "b"}, {ID: "c"},
+			},
+			deps: [][]int{nil, {0}, {0, 1}},
+		},
+		{
+			steps: []*v1alpha1.Step{
+				{ID: "a"},
+				{ID: "b", DependsOn: []string{"a"}},
+				{ID: "c", DependsOn: []string{"a"}},
+				{ID: "d"},
+			},
+			deps: [][]int{nil, {0}, {0}, {0, 1, 2}},
+		},
+		{
+			steps: []*v1alpha1.Step{
+				{ID: "a"},
+				{ID: "b", DependsOn: []string{}},
+			},
+			deps: [][]int{nil, nil},
+		},
+		{
+			steps: []*v1alpha1.Step{
+				{ID: "a"}, {ID: "a"},
+			},
+			err: `duplicate step id "a"`,
+		},
+		{
+			steps: []*v1alpha1.Step{
+				{ID: "a", DependsOn: []string{"x"}},
+			},
+			err: `step "a" depends on unknown step "x"`,
+		},
+		{
+			steps: []*v1alpha1.Step{
+				{ID: "a", DependsOn: []string{"a"}},
+			},
+			err: `step "a" depends on itself`,
+		},
+		{
+			steps: []*v1alpha1.Step{
+				{ID: "a", DependsOn: []string{"c"}},
+				{ID: "b", DependsOn: []string{"a"}},
+				{ID: "c", DependsOn: []string{"b"}},
+			},
+			err: "dependency cycle detected",
+		},
+	}
+
+	for i, tc := range table {
+		g, err := newGraph(tc.steps)
+		if len(tc.err) > 0 {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("[tc: %d] - got: %v, expected error: %v", i, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("[tc: %d] - unexpected error: %v", i, err)
+		}
+
+		for j := range tc.deps {
+			if !slices.Equal(g.deps[j], tc.deps[j]) {
+				t.Fatalf("[tc: %d] - step %d, got: %v, expected: %v", i, j, g.deps[j], tc.deps[j])
+			}
+		}
+	}
+}
+
+func TestGraphReverse(t *testing.T) {
+	g, err := newGraph([]*v1alpha1.Step{
+		{ID: "a"},
+		{ID: "b", DependsOn: []string{"a"}},
+		{ID: "c", DependsOn: []string{"a"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := g.reverse()
+	if got, want := r.deps[0], []int{1, 2}; !slices.Equal(got, want) {
+		t.Fatalf("got: %v, expected: %v", got, want)
+	}
+	if len(r.deps[1]) != 0 || len(r.deps[2]) != 0 {
+		t.Fatalf("expected no dependencies for b and c, got: %v", r.deps)
+	}
+}
@@ -0,0 +1,219 @@
+package workflows
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/itchyny/gojq"
+	"github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+	"github.com/krateoplatformops/installer/internal/expand"
+	"github.com/krateoplatformops/installer/pkg/workflows/steps"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// instance is a step generated by a forEach expansion.
+type instance struct {
+	step  *v1alpha1.Step
+	item  any
+	index int
+}
+
+// locals returns the values available to the when condition of the instance.
+func (el *instance) locals() map[string]any {
+	return map[string]any{
+		"item":  el.item,
+		"index": int64(el.index),
+	}
+}
+
+// idPattern restricts the keys of the forEach items, part of the
+// instance ids.
+var idPattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// stepIDs are the ids of the steps of a run and of the forEach instances
+// expanded so far, shared by the steps running at the same time.
+type stepIDs struct {
+	mu        sync.Mutex
+	steps     map[string]bool
+	instances map[string]string
+}
+
+func newStepIDs(all []*v1alpha1.Step) *stepIDs {
+	res := &stepIDs{
+		steps:     make(map[string]bool, len(all)),
+		instances: map[string]string{},
+	}
+	for _, x := range all {
+		res.steps[x.ID] = true
+	}
+	return res
+}
+
+// claim records the ids of the instances of a forEach step. It fails if
+// one of them is the id of a step or of an instance of another step.
+func (r *stepIDs) claim(parent string, all []instance) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, el := range all {
+		if r.steps[el.step.ID] {
+			return fmt.Errorf("instance %s has the id of another step", el.step.ID)
+		}
+		if p, ok := r.instances[el.step.ID]; ok && p != parent {
+			return fmt.Errorf("instance %s has the id of an instance of step %s", el.step.ID, p)
+		}
+	}
+	for _, el := range all {
+		r.instances[el.step.ID] = parent
+	}
+	return nil
+}
+
+// forEachInstances expands a forEach step in one step for each item.
+// Instances are named <id>-<key>, the key being the result of the
+// forEach key expression on the item or, without one, the index of the
+// item. In their with block $item, ${item.path.to.field} and $index are
+// replaced with the item values. An instance named as another step or
+// instance, or referencing a field its item doesn't have, is an error.
+func (wf *Workflow) forEachInstances(ctx context.Context, x *v1alpha1.Step, ids *stepIDs) ([]instance, error) {
+	items, err := wf.forEachItems(x.ForEach)
+	if err != nil {
+		return nil, fmt.Errorf("forEach: %w", err)
+	}
+
+	res := make([]instance, 0, len(items))
+	keys := make(map[string]int, len(items))
+	for i, item := range items {
+		key, err := itemKey(ctx, x.ForEach.Key, item, i)
+		if err != nil {
+			return nil, steps.Permanent(fmt.Errorf("forEach: item %d: %w", i, err))
+		}
+		if j, ok := keys[key]; ok {
+			return nil, steps.Permanent(fmt.Errorf("forEach: items %d and %d have the same key %q", j, i, key))
+		}
+		keys[key] = i
+
+		el := x.DeepCopy()
+		el.ID = x.ID + "-" + key
+		el.ForEach = nil
+
+		if x.With != nil {
+			missing := []string{}
+			raw := expand.Known(string(x.With.Raw), func(k string) (string, bool) {
+				val, ok := lookupItem(k, item, i)
+				if !ok && strings.HasPrefix(k, "item.") {
+					missing = append(missing, "${"+k+"}")
+				}
+				return expand.JSONEscape(val), ok
+			})
+			if len(missing) > 0 {
+				return nil, steps.Permanent(fmt.Errorf("forEach: item %d has no value for %s",
+					i, strings.Join(missing, ", ")))
+			}
+			el.With = &runtime.RawExtension{Raw: []byte(raw)}
+		}
+
+		res = append(res, instance{step: el, item: item, index: i})
+	}
+
+	if err := ids.claim(x.ID, res); err != nil {
+		return nil, steps.Permanent(fmt.Errorf("forEach: %w", err))
+	}
+
+	return res, nil
+}
+
+// itemKey returns the first value of the key expression on the item,
+// a string or a number, or the index without an expression.
+func itemKey(ctx context.Context, key string, item any, index int) (string, error) {
+	if len(key) == 0 {
+		return strconv.Itoa(index), nil
+	}
+
+	query, err := gojq.Parse(key)
+	if err != nil {
+		return "", fmt.Errorf("invalid key %q: %w", key, err)
+	}
+
+	val, _ := query.RunWithContext(ctx, item).Next()
+	if err, ok := val.(error); ok {
+		return "", fmt.Errorf("key %q: %w", key, err)
+	}
+
+	var res string
+	switch v := val.(type) {
+	case string:
+		res = v
+	case int, float64:
+		res = fmt.Sprint(v)
+	default:
+		return "", fmt.Errorf("key %q: expected a string or a number, got %v", key, val)
+	}
+	if !idPattern.MatchString(res) {
+		return "", fmt.Errorf("key %q: %q is not made of letters, digits, '.', '_' or '-'", key, res)
+	}
+
+	return res, nil
+}
+
+func (wf *Workflow) forEachItems(fe *v1alpha1.ForEach) ([]any, error) {
+	if len(fe.Var) == 0 {
+		res := make([]any, len(fe.Items))
+		for i, el := range fe.Items {
+			if err := json.Unmarshal(el.Raw, &res[i]); err != nil {
+				return nil, fmt.Errorf("invalid item at index %d: %w", i, err)
+			}
+		}
+		return res, nil
+	}
+
+	val, ok := wf.env.Get(fe.Var)
+	if !ok {
+		return nil, fmt.Errorf("variable %q is not defined", fe.Var)
+	}
+
+	res := []any{}
+	if err := json.Unmarshal([]byte(val), &res); err != nil {
+		return nil, fmt.Errorf("variable %q does not hold a JSON array: %w", fe.Var, err)
+	}
+
+	return res, nil
+}
+
+// lookupItem resolves the item, index and item.path.to.field names.
+func lookupItem(name string, item any, index int) (string, bool) {
+	if name == "index" {
+		return strconv.Itoa(index), true
+	}
+
+	path := strings.Split(name, ".")
+	if path[0] != "item" {
+		return "", false
+	}
+
+	cur := item
+	for _, el := range path[1:] {
+		obj, ok := cur.(map[string]any)
+		if !ok {
+			return "", false
+		}
+		if cur, ok = obj[el]; !ok {
+			return "", false
+		}
+	}
+
+	if str, ok := cur.(string); ok {
+		return str, true
+	}
+
+	dat, err := json.Marshal(cur)
+	if err != nil {
+		return "", false
+	}
+	return string(dat), true
+}
@@ -0,0 +1,419 @@
+package fragments
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+	"github.com/krateoplatformops/installer/internal/expand"
+	"github.com/krateoplatformops/installer/pkg/dynamic/getter"
+	"github.com/krateoplatformops/installer/pkg/workflows/steps"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+// maxDepth limits the nesting of the fragments including other fragments.
+const maxDepth = 5
+
+type Resolver struct {
+	dyn *getter.Getter
+	ns  string
+	// loadFn, if set, replaces load (used by tests).
+	loadFn func(ctx context.Context, in *v1alpha1.IncludeSpec) ([]byte, string, error)
+}
+
+// NewResolver returns a resolver loading the fragments with the given
+// getter, ConfigMaps without namespace are looked up in ns.
+func NewResolver(dyn *getter.Getter, ns string) *Resolver {
+	return &Resolver{dyn: dyn, ns: ns}
+}
+
+// Resolve returns a copy of the spec where each include step is replaced
+// by the steps of its fragment:
+//   - ${params.<name>} is replaced with the value of the parameter;
+//   - step ids are prefixed with <include-id>. and the steps depending on
+//     the include step depend on all the steps of the fragment;
+//   - the variables set by the fragment are named <include-id>.<name>,
+//     references inside the fragment are renamed accordingly, outside
+//     they are available as ${<include-id>.<name>}.
+//
+// Fragments can include other fragments. The spec is returned as is if it
+// has no include steps.
+func (r *Resolver) Resolve(ctx context.Context, spec *v1alpha1.WorkflowSpec) (*v1alpha1.WorkflowSpec, error) {
+	if !hasIncludes(spec.Steps) {
+		return spec, nil
+	}
+
+	res := spec.DeepCopy()
+	all, err := r.resolve(ctx, res.Steps, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	res.Steps = all
+
+	return res, nil
+}
+
+// ResolveAvailable is like Resolve, but the include steps whose fragment
+// can't be resolved are dropped instead of failing: it returns the spec
+// with the other steps and the errors of the dropped include steps.
+// It is meant for deleting a workflow whose fragments have been removed.
+func (r *Resolver) ResolveAvailable(ctx context.Context, spec *v1alpha1.WorkflowSpec) (*v1alpha1.WorkflowSpec, error) {
+	if !hasIncludes(spec.Steps) {
+		return spec, nil
+	}
+
+	var dropped []error
+	res := spec.DeepCopy()
+	all, err := r.resolve(ctx, res.Steps, nil, &dropped)
+	if err != nil {
+		return nil, err
+	}
+	res.Steps = all
+
+	return res, errors.Join(dropped...)
+}
+
+func hasIncludes(all []*v1alpha1.Step) bool {
+	return slices.ContainsFunc(all, func(x *v1alpha1.Step) bool {
+		return x.Type == v1alpha1.TypeInclude
+	})
+}
+
+// resolve expands the include steps of the list, sources is the chain of
+// the fragments being resolved (to detect cycles). If dropped is not nil,
+// the include steps failing are dropped and their errors appended to it.
+func (r *Resolver) resolve(ctx context.Context, all []*v1alpha1.Step, sources []string, dropped *[]error) ([]*v1alpha1.Step, error) {
+	res := make([]*v1alpha1.Step, 0, len(all))
+	// included maps the include step ids to the ids of their steps
+	included := map[string][]string{}
+
+	for _, x := range all {
+		if x.Type != v1alpha1.TypeInclude {
+			res = append(res, x)
+			continue
+		}
+
+		// the steps that don't set dependsOn wait for all the steps declared before them
+		after := x.DependsOn
+		if after == nil {
+			after = idsOf(res)
+		}
+
+		got, err := r.include(ctx, x, after, sources, dropped)
+		if err != nil {
+			err = fmt.Errorf("include step %s: %w", x.ID, err)
+			if dropped == nil {
+				return nil, err
+			}
+			*dropped = append(*dropped, err)
+		}
+
+		ids := make([]string, 0, len(got))
+		for _, el := range got {
+			ids = append(ids, el.ID)
+		}
+		included[x.ID] = ids
+		res = append(res, got...)
+	}
+
+	for _, x := range res {
+		if x.DependsOn == nil {
+			continue
+		}
+		deps := make([]string, 0, len(x.DependsOn))
+		for _, id := range x.DependsOn {
+			if ids, ok := included[id]; ok {
+				deps = append(deps, ids...)
+				continue
+			}
+			deps = append(deps, id)
+		}
+		x.DependsOn = deps
+	}
+
+	return res, nil
+}
+
+// include returns the steps of the fragment included by x,
+// all of them wait for the steps listed in after.
+func (r *Resolver) include(ctx context.Context, x *v1alpha1.Step, after, sources []string, dropped *[]error) ([]*v1alpha1.Step, error) {
+	if x.ForEach != nil {
+		return nil, steps.Permanent(fmt.Errorf("forEach is not supported"))
+	}
+
+	in := v1alpha1.IncludeSpec{}
+	if x.With != nil && len(x.With.Raw) > 0 {
+		if err := json.Unmarshal(x.With.Raw, &in); err != nil {
+			return nil, steps.Permanent(err)
+		}
+	}
+
+	load := r.load
+	if r.loadFn != nil {
+		load = r.loadFn
+	}
+	dat, src, err := load(ctx, &in)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load fragment %s: %w", src, err)
+	}
+	if slices.Contains(sources, src) {
+		return nil, steps.Permanent(fmt.Errorf("fragment %s includes itself", src))
+	}
+	if len(sources) >= maxDepth {
+		return nil, steps.Permanent(fmt.Errorf("fragment %s: too many nested includes (max %d)", src, maxDepth))
+	}
+
+	all, err := parse(dat, in.Parameters)
+	if err != nil {
+		return nil, steps.Permanent(fmt.Errorf("fragment %s: %w", src, err))
+	}
+
+	all, err = r.resolve(ctx, all, append(sources, src), dropped)
+	if err != nil {
+		return nil, err
+	}
+
+	return scope(x, after, all)
+}
+
+// parse decodes the fragment replacing the ${params.<name>} references.
+func parse(dat []byte, params []*v1alpha1.Data) ([]*v1alpha1.Step, error) {
+	doc := struct {
+		Parameters []v1alpha1.FragmentParameter `json:"parameters,omitempty"`
+		Steps      json.RawMessage              `json:"steps,omitempty"`
+	}{}
+	if err := yaml.Unmarshal(dat, &doc); err != nil {
+		return nil, err
+	}
+
+	vals := make(map[string]string, len(doc.Parameters))
+	for _, el := range doc.Parameters {
+		if el.Default != nil {
+			vals[el.Name] = *el.Default
+		}
+	}
+	for _, el := range params {
+		if !slices.ContainsFunc(doc.Parameters, func(p v1alpha1.FragmentParameter) bool {
+			return p.Name == el.Name
+		}) {
+			return nil, fmt.Errorf("unknown parameter %q", el.Name)
+		}
+		vals[el.Name] = el.Value
+	}
+	for _, el := range doc.Parameters {
+		if _, ok := vals[el.Name]; !ok {
+			return nil, fmt.Errorf("parameter %q is required", el.Name)
+		}
+	}
+
+	raw := subst(string(doc.Steps), func(k string) (string, bool) {
+		name, ok := strings.CutPrefix(k, "params.")
+		if !ok {
+			return "", false
+		}
+		val, ok := vals[name]
+		return val, ok
+	})
+
+	all := []*v1alpha1.Step{}
+	if err := json.Unmarshal([]byte(raw), &all); err != nil {
+		return nil, err
+	}
+
+	return all, nil
+}
+
+// scope prefixes the ids and the variables of the fragment steps, set by
+// var steps or step outputs, with the id of the include step, and applies
+// to them the include step settings.
+func scope(x *v1alpha1.Step, after []string, all []*v1alpha1.Step) ([]*v1alpha1.Step, error) {
+	prefix := x.ID + "."
+
+	ids, vars := map[string]bool{}, map[string]bool{}
+	for _, el := range all {
+		ids[el.ID] = true
+		names, err := varsOf(el)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range names {
+			vars[k] = true
+		}
+	}
+
+	rename := func(k string) (string, bool) {
+		if vars[k] {
+			return prefix + k, true
+		}
+		return "", false
+	}
+
+	for i, el := range all {
+		deps := slices.Clone(after)
+		if el.DependsOn == nil {
+			// the steps declared before it in the fragment, already scoped
+			deps = append(deps, idsOf(all[:i])...)
+		}
+		for _, dep := range el.DependsOn {
+			if ids[dep] {
+				dep = prefix + dep
+			}
+			deps = append(deps, dep)
+		}
+		el.DependsOn = deps
+		el.ID = prefix + el.ID
+
+		if el.With != nil {
+			raw := subst(string(el.With.Raw), func(k string) (string, bool) {
+				val, ok := rename(k)
+				return "${" + val + "}", ok
+			})
+			el.With = &runtime.RawExtension{Raw: []byte(raw)}
+
+			if err := renameVars(el, prefix); err != nil {
+				return nil, err
+			}
+		}
+		if el.ForEach != nil && vars[el.ForEach.Var] {
+			el.ForEach.Var = prefix + el.ForEach.Var
+		}
+
+		inherit(el, x)
+	}
+
+	return all, nil
+}
+
+func idsOf(all []*v1alpha1.Step) []string {
+	res := make([]string, 0, len(all))
+	for _, x := range all {
+		res = append(res, x.ID)
+	}
+	return res
+}
+
+// varsOf returns the variables set by a step: the name of a var step,
+// the outputs of any step and the variables of the generated values.
+func varsOf(x *v1alpha1.Step) ([]string, error) {
+	if x.With == nil {
+		return nil, nil
+	}
+
+	in := struct {
+		Name    string `json:"name"`
+		Outputs []struct {
+			Name string `json:"name"`
+		} `json:"outputs"`
+		Values json.RawMessage `json:"values"`
+	}{}
+	if err := json.Unmarshal(x.With.Raw, &in); err != nil {
+		return nil, fmt.Errorf("step %s: %w", x.ID, err)
+	}
+
+	res := []string{}
+	if x.Type == v1alpha1.TypeVar {
+		res = append(res, in.Name)
+	}
+	for _, el := range in.Outputs {
+		res = append(res, el.Name)
+	}
+	if x.Type == v1alpha1.TypeGenerate && len(in.Values) > 0 {
+		values := []struct {
+			Var string `json:"var"`
+		}{}
+		if err := json.Unmarshal(in.Values, &values); err != nil {
+			return nil, fmt.Errorf("step %s: %w", x.ID, err)
+		}
+		for _, el := range values {
+			if len(el.Var) > 0 {
+				res = append(res, el.Var)
+			}
+		}
+	}
+
+	return res, nil
+}
+
+// renameVars prefixes the names of the variables set by a step.
+func renameVars(x *v1alpha1.Step, prefix string) error {
+	obj := map[string]any{}
+	if err := json.Unmarshal(x.With.Raw, &obj); err != nil {
+		return fmt.Errorf("step %s: %w", x.ID, err)
+	}
+
+	changed := false
+	rename := func(m map[string]any, key string) {
+		if val, ok := m[key].(string); ok && len(val) > 0 {
+			m[key], changed = prefix+val, true
+		}
+	}
+
+	if x.Type == v1alpha1.TypeVar {
+		rename(obj, "name")
+	}
+	if all, ok := obj["outputs"].([]any); ok {
+		for _, el := range all {
+			if m, ok := el.(map[string]any); ok {
+				rename(m, "name")
+			}
+		}
+	}
+	if all, ok := obj["values"].([]any); ok && x.Type == v1alpha1.TypeGenerate {
+		for _, el := range all {
+			if m, ok := el.(map[string]any); ok {
+				rename(m, "var")
+			}
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	x.With = &runtime.RawExtension{Raw: raw}
+	return nil
+}
+
+// inherit applies the settings of the include step the fragment step
+// doesn't set on its own.
+func inherit(el, x *v1alpha1.Step) {
+	if len(x.When) > 0 {
+		if len(el.When) > 0 {
+			el.When = fmt.Sprintf("(%s) and (%s)", x.When, el.When)
+		} else {
+			el.When = x.When
+		}
+	}
+	if len(el.PrunePolicy) == 0 {
+		el.PrunePolicy = x.PrunePolicy
+	}
+	if el.Retry == nil {
+		el.Retry = x.Retry
+	}
+	if el.Timeout == nil {
+		el.Timeout = x.Timeout
+	}
+	if len(el.DeletionPolicy) == 0 {
+		el.DeletionPolicy = x.DeletionPolicy
+	}
+	if len(el.OnFailure) == 0 {
+		el.OnFailure = x.OnFailure
+	}
+}
+
+// subst replaces the variable references found by lookup, leaving the
+// other ones as they are. Values are escaped for a JSON string.
+func subst(s string, lookup func(string) (string, bool)) string {
+	return expand.Known(s, func(k string) (string, bool) {
+		val, ok := lookup(k)
+		return expand.JSONEscape(val), ok
+	})
+}
@@ -0,0 +1,287 @@
+package fragments
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+	"github.com/krateoplatformops/installer/pkg/workflows/steps"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const dbFragment = `
+parameters:
+- name: namespace
+- name: version
+  default: 1.0.0
+steps:
+- id: password
+  type: var
+  with:
+    name: password
+    value: secret-${params.namespace}
+- id: chart
+  type: chart
+  with:
+    name: postgresql
+    version: ${params.version}
+    set:
+    - name: auth.password
+      value: $password
+    - name: region
+      value: $REGION
+`
+
+const appFragment = `
+steps:
+- id: db
+  type: include
+  with:
+    configMapRef:
+      name: db
+    parameters:
+    - name: namespace
+      value: app
+- id: settings
+  type: object
+  with:
+    set:
+    - name: data.password
+      value: ${db.password}
+`
+
+// fakeResolver loads the fragments from the ConfigMap names.
+func fakeResolver(sources map[string]string) *Resolver {
+	return &Resolver{
+		loadFn: func(_ context.Context, in *v1alpha1.IncludeSpec) ([]byte, string, error) {
+			src := "configmap " + in.ConfigMapRef.Name
+			dat, ok := sources[in.ConfigMapRef.Name]
+			if !ok {
+				return nil, src, fmt.Errorf("not found")
+			}
+			return []byte(dat), src, nil
+		},
+	}
+}
+
+func includeStep(id, name string, deps []string, params ...string) *v1alpha1.Step {
+	all := make([]string, 0, len(params))
+	for i := 0; i+1 < len(params); i += 2 {
+		all = append(all, fmt.Sprintf(`{"name":%q,"value":%q}`, params[i], params[i+1]))
+	}
+	return &v1alpha1.Step{
+		ID: id, Type: v1alpha1.TypeInclude, DependsOn: deps,
+		With: &runtime.RawExtension{Raw: []byte(fmt.Sprintf(
+			`{"configMapRef":{"name":%q},"parameters":[%s]}`, name, strings.Join(all, ",")))},
+	}
+}
+
+func TestResolve(t *testing.T) {
+	r := fakeResolver(map[string]string{"db": dbFragment})
+
+	spec := &v1alpha1.WorkflowSpec{Steps: []*v1alpha1.Step{
+		{ID: "ns", Type: v1alpha1.TypeObject},
+		includeStep("db", "db", nil, "namespace", "krateo"),
+		{ID: "app", Type: v1alpha1.TypeObject, DependsOn: []string{"db"},
+			With: &runtime.RawExtension{Raw: []byte(`{"value":"${db.password}"}`)}},
+	}}
+
+	got, err := r.Resolve(context.Background(), spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(spec.Steps) != 3 || spec.Steps[1].Type != v1alpha1.TypeInclude {
+		t.Fatal("the spec must not be modified")
+	}
+
+	ids := idsOf(got.Steps)
+	if fmt.Sprint(ids) != "[ns db.password db.chart app]" {
+		t.Fatalf("unexpected steps: %v", ids)
+	}
+
+	deps := map[string]string{}
+	for _, x := range got.Steps {
+		deps[x.ID] = fmt.Sprint(x.DependsOn)
+	}
+	exp := map[string]string{
+		"ns":          "[]",
+		"db.password": "[ns]",
+		"db.chart":    "[ns db.password]",
+		"app":         "[db.password db.chart]",
+	}
+	for id, want := range exp {
+		if deps[id] != want {
+			t.Errorf("step %s: got dependsOn %s, expected %s", id, deps[id], want)
+		}
+	}
+
+	if got := string(got.Steps[1].With.Raw); got != `{"name":"db.password","value":"secret-krateo"}` {
+		t.Errorf("unexpected var step: %s", got)
+	}
+
+	chart := string(got.Steps[2].With.Raw)
+	for _, s := range []string{`"version":"1.0.0"`, `"value":"${db.password}"`, `"value":"$REGION"`} {
+		if !strings.Contains(chart, s) {
+			t.Errorf("expected %s in chart step: %s", s, chart)
+		}
+	}
+}
+
+const endpointFragment = `
+steps:
+- id: service
+  type: object
+  with:
+    kind: Service
+    outputs:
+    - name: url
+      selector: .spec.clusterIP
+- id: token
+  type: generate
+  with:
+    values:
+    - name: signing
+      type: keyPair
+      var: publicKey
+- id: register
+  type: http
+  with:
+    url: http://$url/register
+    body: $publicKey
+`
+
+func TestResolveOutputs(t *testing.T) {
+	r := fakeResolver(map[string]string{"endpoint": endpointFragment})
+
+	spec := &v1alpha1.WorkflowSpec{Steps: []*v1alpha1.Step{
+		includeStep("a", "endpoint", nil),
+		includeStep("b", "endpoint", nil),
+	}}
+
+	got, err := r.Resolve(context.Background(), spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	with := map[string]string{}
+	for _, x := range got.Steps {
+		with[x.ID] = string(x.With.Raw)
+	}
+
+	for _, id := range []string{"a", "b"} {
+		exp := map[string][]string{
+			id + ".service":  {`"outputs":[{"name":"` + id + `.url","selector":".spec.clusterIP"}]`},
+			id + ".token":    {`"var":"` + id + `.publicKey"`},
+			id + ".register": {`"url":"http://${` + id + `.url}/register"`, `"body":"${` + id + `.publicKey}"`},
+		}
+		for step, all := range exp {
+			for _, want := range all {
+				if !strings.Contains(with[step], want) {
+					t.Errorf("step %s: expected %s in %s", step, want, with[step])
+				}
+			}
+		}
+	}
+}
+
+func TestResolveNested(t *testing.T) {
+	r := fakeResolver(map[string]string{"db": dbFragment, "app": appFragment})
+
+	spec := &v1alpha1.WorkflowSpec{Steps: []*v1alpha1.Step{
+		includeStep("app", "app", []string{}),
+	}}
+
+	got, err := r.Resolve(context.Background(), spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := idsOf(got.Steps)
+	if fmt.Sprint(ids) != "[app.db.password app.db.chart app.settings]" {
+		t.Fatalf("unexpected steps: %v", ids)
+	}
+	if got := string(got.Steps[0].With.Raw); got != `{"name":"app.db.password","value":"secret-app"}` {
+		t.Errorf("unexpected var step: %s", got)
+	}
+	if got := string(got.Steps[2].With.Raw); !strings.Contains(got, `"value":"${app.db.password}"`) {
+		t.Errorf("unexpected object step: %s", got)
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		step *v1alpha1.Step
+		err  string
+	}{
+		{
+			name: "missing parameter",
+			step: includeStep("db", "db", nil),
+			err:  `parameter "namespace" is required`,
+		},
+		{
+			name: "unknown parameter",
+			step: includeStep("db", "db", nil, "namespace", "x", "foo", "bar"),
+			err:  `unknown parameter "foo"`,
+		},
+		{
+			name: "cycle",
+			step: includeStep("loop", "loop", nil),
+			err:  "includes itself",
+		},
+		{
+			name: "not found",
+			step: includeStep("x", "missing", nil),
+			err:  "failed to load fragment configmap missing",
+		},
+	}
+
+	r := fakeResolver(map[string]string{
+		"db":   dbFragment,
+		"loop": "steps:\n- id: again\n  type: include\n  with:\n    configMapRef:\n      name: loop\n",
+	})
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			spec := &v1alpha1.WorkflowSpec{Steps: []*v1alpha1.Step{tc.step}}
+			_, err := r.Resolve(context.Background(), spec)
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("expected error containing %q, got: %v", tc.err, err)
+			}
+			if tc.name != "not found" && !steps.IsPermanent(err) {
+				t.Fatalf("expected permanent error, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestResolveAvailable(t *testing.T) {
+	r := fakeResolver(map[string]string{"db": dbFragment})
+
+	spec := &v1alpha1.WorkflowSpec{Steps: []*v1alpha1.Step{
+		{ID: "ns", Type: v1alpha1.TypeObject},
+		includeStep("db", "db", nil, "namespace", "demo"),
+		includeStep("cache", "missing", nil),
+		{ID: "app", Type: v1alpha1.TypeObject, DependsOn: []string{"cache", "db"}},
+	}}
+
+	if _, err := r.Resolve(context.Background(), spec); err == nil {
+		t.Fatal("expected Resolve to fail")
+	}
+
+	got, err := r.ResolveAvailable(context.Background(), spec)
+	if err == nil || !strings.Contains(err.Error(), "include step cache: failed to load fragment configmap missing") {
+		t.Fatalf("expected the missing fragment to be reported, got: %v", err)
+	}
+
+	ids := idsOf(got.Steps)
+	if fmt.Sprint(ids) != "[ns db.password db.chart app]" {
+		t.Fatalf("unexpected steps: %v", ids)
+	}
+	if deps := fmt.Sprint(got.Steps[3].DependsOn); deps != "[db.password db.chart]" {
+		t.Fatalf("unexpected dependencies: %s", deps)
+	}
+}
@@ -0,0 +1,112 @@
+package fragments
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+
+	"github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+	helmgetter "github.com/krateoplatformops/installer/internal/helm/getter"
+	"github.com/krateoplatformops/installer/internal/resolvers"
+	"github.com/krateoplatformops/installer/pkg/cache"
+	"github.com/krateoplatformops/installer/pkg/dynamic/getter"
+	"github.com/krateoplatformops/plumbing/ptr"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// defaultKey is the ConfigMap key and the artifact file holding the fragment.
+const defaultKey = "fragment.yaml"
+
+// artifacts keeps the fragments of the artifacts with a version, so that
+// they are not downloaded again at each reconcile.
+var artifacts = cache.New[string, []byte]()
+
+// load returns the fragment source as YAML and a key identifying it.
+func (r *Resolver) load(ctx context.Context, in *v1alpha1.IncludeSpec) ([]byte, string, error) {
+	switch {
+	case in.ConfigMapRef != nil:
+		return r.fromConfigMap(ctx, in.ConfigMapRef)
+	case in.Artifact != nil:
+		return r.fromArtifact(ctx, in.Artifact)
+	}
+
+	return nil, "", fmt.Errorf("either configMapRef or artifact must be set")
+}
+
+func (r *Resolver) fromConfigMap(ctx context.Context, ref *v1alpha1.FragmentConfigMapRef) ([]byte, string, error) {
+	namespace, key := ref.Namespace, ref.Key
+	if len(namespace) == 0 {
+		namespace = r.ns
+	}
+	if len(key) == 0 {
+		key = defaultKey
+	}
+	src := fmt.Sprintf("configmap %s/%s[%s]", namespace, ref.Name, key)
+
+	obj, err := r.dyn.Get(ctx, getter.GetOptions{
+		GVK:       corev1.SchemeGroupVersion.WithKind("ConfigMap"),
+		Namespace: namespace,
+		Name:      ref.Name,
+	})
+	if err != nil {
+		return nil, src, err
+	}
+
+	val, ok, err := unstructured.NestedString(obj.Object, "data", key)
+	if err != nil {
+		return nil, src, err
+	}
+	if !ok {
+		return nil, src, fmt.Errorf("key %q not found in configmap %s/%s", key, namespace, ref.Name)
+	}
+
+	return []byte(val), src, nil
+}
+
+func (r *Resolver) fromArtifact(ctx context.Context, ref *v1alpha1.FragmentArtifact) ([]byte, string, error) {
+	src := ref.URL
+	if len(ref.Version) > 0 {
+		src = fmt.Sprintf("%s:%s", ref.URL, ref.Version)
+		if dat, ok := artifacts.Get(src); ok {
+			return dat, src, nil
+		}
+	}
+
+	opts := helmgetter.GetOptions{
+		URI:                   ref.URL,
+		Version:               ref.Version,
+		InsecureSkipVerifyTLS: ptr.Deref(ref.InsecureSkipTLSVerify, false),
+	}
+	if ref.Credentials != nil {
+		secret, err := resolvers.GetSecret(ctx, r.dyn.Get, ref.Credentials.PasswordRef)
+		if err != nil {
+			return nil, src, fmt.Errorf("failed to get secret: %w", err)
+		}
+		opts.Username = ref.Credentials.Username
+		opts.Password = secret
+		opts.PassCredentialsAll = true
+	}
+
+	dat, _, err := helmgetter.Get(opts)
+	if err != nil {
+		return nil, src, err
+	}
+
+	chart, err := loader.LoadArchive(bytes.NewReader(dat))
+	if err != nil {
+		return nil, src, err
+	}
+	for _, el := range chart.Raw {
+		if path.Clean(el.Name) == defaultKey {
+			if len(ref.Version) > 0 {
+				artifacts.Set(src, el.Data)
+			}
+			return el.Data, src, nil
+		}
+	}
+
+	return nil, src, fmt.Errorf("%s not found in artifact %s", defaultKey, src)
+}
@@ -3,7 +3,7 @@ package workflows
 import (
 	"fmt"
 
-	"github.com/krateoplatformops/installer/internal/helmclient"
+	"github.com/krateoplatformops/installer/pkg/helmclient"
 	"github.com/krateoplatformops/provider-runtime/pkg/logging"
 	"k8s.io/client-go/rest"
 )
@@ -0,0 +1,61 @@
+package workflows
+
+import (
+	"context"
+	"testing"
+
+	"github.com/krateoplatformops/installer/pkg/workflows/steps"
+)
+
+// planHandler is a fakeHandler that supports plan mode.
+type planHandler struct {
+	undoHandler
+	dryRun bool
+}
+
+func (h *planHandler) DryRun(on bool) {
+	h.dryRun = on
+}
+
+func TestRunPlanMode(t *testing.T) {
+	hdl := &planHandler{}
+	wf := newFakeWorkflow(&hdl.fakeHandler, 1, steps.Update)
+	setObjectHandler(wf, hdl)
+	wf.DryRun(true)
+
+	spec := objectSteps(nil, "a", "b")
+	spec.RollbackOnFailure = true
+
+	results := wf.Run(context.Background(), spec, noSkip)
+	if err := Err(results); err != nil {
+		t.Fatal(err)
+	}
+
+	if !hdl.dryRun {
+		t.Fatal("expected the handler to be switched to dry-run")
+	}
+	// no rollback is prepared in plan mode
+	if len(hdl.calls) != 2 {
+		t.Fatalf("unexpected calls: %v", hdl.calls)
+	}
+	for _, x := range results {
+		if x.undo != nil {
+			t.Fatalf("unexpected rollback for step %s", x.ID())
+		}
+	}
+}
+
+func TestRunPlanModeUnsupported(t *testing.T) {
+	hdl := &fakeHandler{}
+	wf := newFakeWorkflow(hdl, 1, steps.Update)
+	wf.DryRun(true)
+
+	results := wf.Run(context.Background(), objectSteps(nil, "a"), noSkip)
+	err := Err(results)
+	if !steps.IsPermanent(err) {
+		t.Fatalf("expected permanent error, got: %v", err)
+	}
+	if len(hdl.calls) != 0 {
+		t.Fatalf("expected no step to be executed, got: %v", hdl.calls)
+	}
+}
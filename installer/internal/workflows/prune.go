@@ -0,0 +1,78 @@
+package workflows
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+	"github.com/krateoplatformops/installer/pkg/dynamic/deletor"
+	"github.com/krateoplatformops/installer/pkg/locker"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Prune deletes the given objects and uninstalls the given releases.
+// Objects and releases already gone are ignored.
+func (wf *Workflow) Prune(ctx context.Context, releases []v1alpha1.Release, objects []v1alpha1.ObjectStatus) error {
+	for _, x := range objects {
+		gv, err := schema.ParseGroupVersion(x.APIVersion)
+		if err != nil {
+			return err
+		}
+
+		gvk := gv.WithKind(x.Kind)
+		err = wf.del.Delete(ctx, deletor.DeleteOptions{
+			GVK:       gvk,
+			Namespace: x.Metadata.Namespace,
+			Name:      x.Metadata.Name,
+		})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("%s: failed to prune %s %s/%s: %w",
+				x.Step, x.Kind, x.Metadata.Namespace, x.Metadata.Name, err)
+		}
+		if err := wf.lock.Release(ctx, locker.ObjectKey(gvk, x.Metadata.Namespace, x.Metadata.Name)); err != nil {
+			return fmt.Errorf("%s: %w", x.Step, err)
+		}
+
+		wf.logr.Info(fmt.Sprintf("pruned %s %s/%s of step %s",
+			x.Kind, x.Metadata.Namespace, x.Metadata.Name, x.Step))
+	}
+
+	for _, x := range releases {
+		err := wf.helm.UninstallReleaseByName(x.ReleaseName)
+		if err != nil && !strings.Contains(err.Error(), "release: not found") {
+			return fmt.Errorf("%s: failed to prune release %s: %w", x.Step, x.ReleaseName, err)
+		}
+		if err := wf.lock.Release(ctx, locker.ReleaseKey(x.Namespace, x.ReleaseName)); err != nil {
+			return fmt.Errorf("%s: %w", x.Step, err)
+		}
+
+		wf.logr.Info(fmt.Sprintf("pruned release %s of step %s", x.ReleaseName, x.Step))
+	}
+
+	return nil
+}
+
+// Disown gives up the leases of the given objects and releases, left in
+// place by their prune or deletion policy, so that other workflows can
+// take them over.
+func (wf *Workflow) Disown(ctx context.Context, releases []v1alpha1.Release, objects []v1alpha1.ObjectStatus) error {
+	for _, x := range objects {
+		gv, err := schema.ParseGroupVersion(x.APIVersion)
+		if err != nil {
+			return err
+		}
+		if err := wf.lock.Release(ctx, locker.ObjectKey(gv.WithKind(x.Kind), x.Metadata.Namespace, x.Metadata.Name)); err != nil {
+			return fmt.Errorf("%s: %w", x.Step, err)
+		}
+	}
+
+	for _, x := range releases {
+		if err := wf.lock.Release(ctx, locker.ReleaseKey(x.Namespace, x.ReleaseName)); err != nil {
+			return fmt.Errorf("%s: %w", x.Step, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,57 @@
+package workflows
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+	"github.com/krateoplatformops/installer/pkg/workflows/steps"
+)
+
+const (
+	defaultRetryBackoff  = 5 * time.Second
+	defaultRetryMaxDelay = time.Minute
+)
+
+// retry calls fn until it succeeds, it fails with a permanent error
+// or the attempts allowed by the step retry policy are exhausted.
+func (wf *Workflow) retry(ctx context.Context, x *v1alpha1.Step, fn func() error) error {
+	attempts, backoff, maxDelay := 1, defaultRetryBackoff, defaultRetryMaxDelay
+	if r := x.Retry; r != nil {
+		attempts = max(r.Attempts, 1)
+		if r.Backoff != nil {
+			backoff = r.Backoff.Duration
+		}
+		if r.MaxDelay != nil {
+			maxDelay = r.MaxDelay.Duration
+		}
+	}
+
+	delay := backoff
+	for i := 1; ; i++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		if steps.IsPermanent(err) {
+			return steps.Permanent(err)
+		}
+		if i >= attempts {
+			return err
+		}
+
+		delay = min(delay, maxDelay)
+		wf.logr.Debug(fmt.Sprintf("step with id: %s failed (attempt %d/%d), retrying in %s: %s",
+			x.ID, i, attempts, delay, err.Error()))
+
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,70 @@
+package workflows
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+	"github.com/krateoplatformops/installer/pkg/workflows/steps"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func retrySpec(attempts int) *v1alpha1.WorkflowSpec {
+	spec := objectSteps(nil, "a", "b")
+	spec.Steps[0].Retry = &v1alpha1.RetryPolicy{
+		Attempts: attempts,
+		Backoff:  &metav1.Duration{Duration: time.Millisecond},
+	}
+	return spec
+}
+
+func TestRunRetriesTransientErrors(t *testing.T) {
+	hdl := &fakeHandler{flaky: map[string]int{"a": 2}}
+	wf := newFakeWorkflow(hdl, 1, steps.Create)
+
+	results := wf.Run(context.Background(), retrySpec(3), noSkip)
+	if err := Err(results); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := fmt.Sprint(hdl.calls); got != "[a a a b]" {
+		t.Fatalf("got: %s, expected: [a a a b]", got)
+	}
+}
+
+func TestRunRetryAttemptsExhausted(t *testing.T) {
+	hdl := &fakeHandler{flaky: map[string]int{"a": 3}}
+	wf := newFakeWorkflow(hdl, 1, steps.Create)
+
+	results := wf.Run(context.Background(), retrySpec(2), noSkip)
+	err := Err(results)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if steps.IsPermanent(err) {
+		t.Fatalf("expected transient error, got: %v", err)
+	}
+
+	if got := fmt.Sprint(hdl.calls); got != "[a a]" {
+		t.Fatalf("got: %s, expected: [a a]", got)
+	}
+}
+
+func TestRunPermanentErrorsNotRetried(t *testing.T) {
+	hdl := &fakeHandler{failing: map[string]error{
+		"a": steps.Permanent(fmt.Errorf("key \"x\" has no value")),
+	}}
+	wf := newFakeWorkflow(hdl, 1, steps.Create)
+
+	results := wf.Run(context.Background(), retrySpec(5), noSkip)
+	err := Err(results)
+	if !steps.IsPermanent(err) {
+		t.Fatalf("expected permanent error, got: %v", err)
+	}
+
+	if got := fmt.Sprint(hdl.calls); got != "[a]" {
+		t.Fatalf("got: %s, expected: [a]", got)
+	}
+}
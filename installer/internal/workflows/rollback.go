@@ -0,0 +1,45 @@
+package workflows
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+	"github.com/krateoplatformops/installer/pkg/workflows/steps"
+)
+
+// prepareRollback captures the state a step is about to change,
+// it returns nil if the step type can't be rolled back.
+func (wf *Workflow) prepareRollback(ctx context.Context, x *v1alpha1.Step) (steps.Rollback, error) {
+	rb, ok := wf.handlerFor(x.Type).(steps.Rollbacker)
+	if !ok {
+		return nil, nil
+	}
+
+	return rb.Prepare(ctx, x.ID, x.With)
+}
+
+// undo rolls back the changes of a step, recording the outcome in its result.
+func (wf *Workflow) undo(ctx context.Context, res *StepResult[any]) {
+	wf.logr.Debug(fmt.Sprintf("rolling back step with id: %s", res.id))
+
+	res.rollbackErr = res.undo(ctx)
+	res.phase = v1alpha1.StepRolledBack
+	if res.rollbackErr != nil {
+		wf.logr.Info(fmt.Sprintf("WARN: rollback of step with id: %s failed: %s", res.id, res.rollbackErr.Error()))
+	}
+}
+
+// undoCompleted rolls back, in reverse completion order,
+// the steps executed successfully by the run.
+func (wf *Workflow) undoCompleted(ctx context.Context, nodes [][]StepResult[any], order []int) {
+	for k := len(order) - 1; k >= 0; k-- {
+		all := nodes[order[k]]
+		for j := len(all) - 1; j >= 0; j-- {
+			res := &all[j]
+			if res.err == nil && res.phase == v1alpha1.StepSucceeded && res.undo != nil {
+				wf.undo(ctx, res)
+			}
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+	"github.com/krateoplatformops/installer/pkg/workflows/steps"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// undoHandler is a fakeHandler whose steps can be rolled back,
+// rollbacks are recorded as "undo:<id>" calls.
+type undoHandler struct {
+	fakeHandler
+}
+
+func (h *undoHandler) Prepare(_ context.Context, id string, _ *runtime.RawExtension) (steps.Rollback, error) {
+	return func(context.Context) error {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.calls = append(h.calls, "undo:"+id)
+		return nil
+	}, nil
+}
+
+func newUndoWorkflow(hdl *undoHandler) *Workflow {
+	wf := newFakeWorkflow(&hdl.fakeHandler, 1, steps.Create)
+	setObjectHandler(wf, hdl)
+	return wf
+}
+
+func TestRunRollbackOnFailure(t *testing.T) {
+	hdl := &undoHandler{fakeHandler{failing: map[string]error{"c": errors.New("boom")}}}
+	wf := newUndoWorkflow(hdl)
+
+	spec := objectSteps(nil, "a", "b", "c", "d")
+	spec.RollbackOnFailure = true
+
+	results := wf.Run(context.Background(), spec, noSkip)
+	if err := Err(results); err == nil {
+		t.Fatal("expected error")
+	}
+
+	if got, want := fmt.Sprint(hdl.calls), "[a b c undo:c undo:b undo:a]"; got != want {
+		t.Fatalf("got: %s, expected: %s", got, want)
+	}
+
+	for _, x := range results[:3] {
+		if x.Phase() != v1alpha1.StepRolledBack {
+			t.Fatalf("step %s, got phase: %s, expected: %s", x.ID(), x.Phase(), v1alpha1.StepRolledBack)
+		}
+	}
+}
+
+func TestRunRollbackFailedStepOnly(t *testing.T) {
+	hdl := &undoHandler{fakeHandler{failing: map[string]error{"b": errors.New("boom")}}}
+	wf := newUndoWorkflow(hdl)
+
+	spec := objectSteps(nil, "a", "b")
+	spec.Steps[1].OnFailure = v1alpha1.FailureRollback
+
+	results := wf.Run(context.Background(), spec, noSkip)
+	if err := Err(results); err == nil {
+		t.Fatal("expected error")
+	}
+
+	if got, want := fmt.Sprint(hdl.calls), "[a b undo:b]"; got != want {
+		t.Fatalf("got: %s, expected: %s", got, want)
+	}
+	if results[0].Phase() != v1alpha1.StepSucceeded {
+		t.Fatalf("expected step a to be kept, got phase: %s", results[0].Phase())
+	}
+}
+
+func TestRunNoRollbackOnDelete(t *testing.T) {
+	hdl := &undoHandler{fakeHandler{failing: map[string]error{"a": errors.New("boom")}}}
+	wf := newUndoWorkflow(hdl)
+	wf.op = steps.Delete
+
+	spec := objectSteps(nil, "a", "b")
+	spec.RollbackOnFailure = true
+
+	wf.Run(context.Background(), spec, noSkip)
+
+	if got, want := fmt.Sprint(hdl.calls), "[b a]"; got != want {
+		t.Fatalf("got: %s, expected: %s", got, want)
+	}
+}
@@ -0,0 +1,518 @@
+package workflows
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+	"github.com/krateoplatformops/installer/pkg/cache"
+	"github.com/krateoplatformops/installer/pkg/workflows/steps"
+	"github.com/krateoplatformops/provider-runtime/pkg/logging"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// fakeHandler records the order in which steps are handled.
+type fakeHandler struct {
+	mu      sync.Mutex
+	calls   []string
+	active  int
+	peak    int
+	delay   time.Duration
+	failing map[string]error
+	// flaky counts the calls failing with a transient error before the step succeeds.
+	flaky map[string]int
+}
+
+func (h *fakeHandler) Namespace(string) {}
+
+func (h *fakeHandler) Op(steps.Op) {}
+
+func (h *fakeHandler) Handle(ctx context.Context, id string, _ *runtime.RawExtension) (*steps.ObjectResult, error) {
+	h.mu.Lock()
+	h.calls = append(h.calls, id)
+	h.active++
+	h.peak = max(h.peak, h.active)
+	h.mu.Unlock()
+
+	time.Sleep(h.delay)
+
+	h.mu.Lock()
+	h.active--
+	if h.flaky[id] > 0 {
+		h.flaky[id]--
+		h.mu.Unlock()
+		return nil, fmt.Errorf("%s: connection refused", id)
+	}
+	h.mu.Unlock()
+
+	return &steps.ObjectResult{Name: id}, h.failing[id]
+}
+
+func newFakeWorkflow(hdl *fakeHandler, parallel int, op steps.Op) *Workflow {
+	return &Workflow{
+		logr: logging.NewNopLogger(),
+		env:  cache.New[string, string](),
+		handlers: map[v1alpha1.StepType]steps.Handler[steps.Result]{
+			v1alpha1.TypeVar:    steps.Adapt[*steps.VarResult](&varFake{}),
+			v1alpha1.TypeObject: steps.Adapt[*steps.ObjectResult](hdl),
+			v1alpha1.TypeChart:  steps.Adapt[*steps.ChartResult](&chartFake{}),
+		},
+		parallel: parallel,
+		op:       op,
+	}
+}
+
+// setObjectHandler replaces the handler of the object steps.
+func setObjectHandler(wf *Workflow, hdl steps.Handler[*steps.ObjectResult]) {
+	wf.handlers[v1alpha1.TypeObject] = steps.Adapt(hdl)
+}
+
+type varFake struct{ fakeHandler }
+
+func (h *varFake) Handle(context.Context, string, *runtime.RawExtension) (*steps.VarResult, error) {
+	return nil, nil
+}
+
+type chartFake struct{ fakeHandler }
+
+func (h *chartFake) Handle(context.Context, string, *runtime.RawExtension) (*steps.ChartResult, error) {
+	return nil, nil
+}
+
+func objectSteps(deps map[string][]string, ids ...string) *v1alpha1.WorkflowSpec {
+	spec := &v1alpha1.WorkflowSpec{}
+	for _, id := range ids {
+		spec.Steps = append(spec.Steps, &v1alpha1.Step{
+			ID: id, Type: v1alpha1.TypeObject, DependsOn: deps[id],
+		})
+	}
+	return spec
+}
+
+func noSkip(*v1alpha1.Step) bool { return false }
+
+func TestRunSequentialByDefault(t *testing.T) {
+	hdl := &fakeHandler{delay: 5 * time.Millisecond}
+	wf := newFakeWorkflow(hdl, 4, steps.Create)
+
+	results := wf.Run(context.Background(), objectSteps(nil, "a", "b", "c"), noSkip)
+	if err := Err(results); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := fmt.Sprint(hdl.calls); got != "[a b c]" {
+		t.Fatalf("got: %s, expected: [a b c]", got)
+	}
+	if hdl.peak != 1 {
+		t.Fatalf("expected sequential execution, got %d steps in parallel", hdl.peak)
+	}
+}
+
+func TestRunParallel(t *testing.T) {
+	deps := map[string][]string{
+		"b": {"a"}, "c": {"a"}, "d": {"a"},
+	}
+
+	hdl := &fakeHandler{delay: 20 * time.Millisecond}
+	wf := newFakeWorkflow(hdl, 2, steps.Create)
+
+	results := wf.Run(context.Background(), objectSteps(deps, "a", "b", "c", "d", "e"), noSkip)
+	if err := Err(results); err != nil {
+		t.Fatal(err)
+	}
+
+	if hdl.calls[0] != "a" || hdl.calls[4] != "e" {
+		t.Fatalf("unexpected execution order: %v", hdl.calls)
+	}
+	if hdl.peak != 2 {
+		t.Fatalf("expected 2 steps in parallel, got %d", hdl.peak)
+	}
+}
+
+func TestRunDeleteReverse(t *testing.T) {
+	deps := map[string][]string{
+		"b": {"a"}, "c": {"b"},
+	}
+
+	hdl := &fakeHandler{}
+	wf := newFakeWorkflow(hdl, 1, steps.Delete)
+
+	results := wf.Run(context.Background(), objectSteps(deps, "a", "b", "c"), noSkip)
+	if err := Err(results); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := fmt.Sprint(hdl.calls); got != "[c b a]" {
+		t.Fatalf("got: %s, expected: [c b a]", got)
+	}
+}
+
+func TestRunUnknownType(t *testing.T) {
+	spec := objectSteps(nil, "a", "b", "c")
+	spec.Steps[1].Type = "echo"
+
+	wf := newFakeWorkflow(&fakeHandler{}, 1, steps.Create)
+	err := wf.Validate(spec)
+	if err == nil || err.Error() != `step b: handler for step of type "echo" not found` {
+		t.Fatalf("got: %v, expected an unknown type error", err)
+	}
+
+	// on delete the step is skipped instead of blocking the deletion
+	hdl := &fakeHandler{}
+	wf = newFakeWorkflow(hdl, 1, steps.Delete)
+	results := wf.Run(context.Background(), spec, noSkip)
+	if err := Err(results); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(hdl.calls); got != "[c a]" {
+		t.Fatalf("got: %s, expected: [c a]", got)
+	}
+	if results[1].Phase() != v1alpha1.StepSkipped {
+		t.Fatalf("got phase %q, expected %q", results[1].Phase(), v1alpha1.StepSkipped)
+	}
+}
+
+func TestRunStopsOnFailure(t *testing.T) {
+	hdl := &fakeHandler{failing: map[string]error{"b": fmt.Errorf("boom")}}
+	wf := newFakeWorkflow(hdl, 1, steps.Create)
+
+	results := wf.Run(context.Background(), objectSteps(nil, "a", "b", "c"), noSkip)
+
+	err := Err(results)
+	if err == nil || err.Error() != "b: boom" {
+		t.Fatalf("got: %v, expected: b: boom", err)
+	}
+	if got := fmt.Sprint(hdl.calls); got != "[a b]" {
+		t.Fatalf("got: %s, expected: [a b]", got)
+	}
+}
+
+func TestRunInvalidGraph(t *testing.T) {
+	hdl := &fakeHandler{}
+	wf := newFakeWorkflow(hdl, 1, steps.Create)
+
+	deps := map[string][]string{"a": {"missing"}}
+	results := wf.Run(context.Background(), objectSteps(deps, "a"), noSkip)
+
+	if err := Err(results); err == nil {
+		t.Fatal("expected error for unknown dependency")
+	}
+	if len(hdl.calls) != 0 {
+		t.Fatalf("expected no step executed, got: %v", hdl.calls)
+	}
+}
+
+func TestDigestTracksInputVars(t *testing.T) {
+	wf := newFakeWorkflow(&fakeHandler{}, 1, steps.Create)
+
+	x := &v1alpha1.Step{
+		ID: "a", Type: v1alpha1.TypeObject,
+		With: &runtime.RawExtension{Raw: []byte(`{"set": [{"name": "data.url", "value": "$URL"}]}`)},
+	}
+
+	wf.env.Set("URL", "https://one.example.com")
+	one := wf.Digest(x)
+
+	wf.env.Set("OTHER", "ignored")
+	if got := wf.Digest(x); got != one {
+		t.Fatalf("digest changed for an unreferenced variable: %s != %s", got, one)
+	}
+
+	wf.env.Set("URL", "https://two.example.com")
+	if got := wf.Digest(x); got == one {
+		t.Fatal("expected digest to change when an input variable changed")
+	}
+}
+
+func TestRunSkippedSteps(t *testing.T) {
+	hdl := &fakeHandler{}
+	wf := newFakeWorkflow(hdl, 1, steps.Update)
+
+	results := wf.Run(context.Background(), objectSteps(nil, "a", "b"), func(s *v1alpha1.Step) bool {
+		return s.ID == "a"
+	})
+	if err := Err(results); err != nil {
+		t.Fatal(err)
+	}
+
+	if results[0].Phase() != v1alpha1.StepUnchanged || results[1].Phase() != v1alpha1.StepSucceeded {
+		t.Fatalf("expected only step a to be skipped, got: %s, %s", results[0].Phase(), results[1].Phase())
+	}
+	if len(results[0].Digest()) == 0 {
+		t.Fatal("expected digest for skipped step")
+	}
+	if got := fmt.Sprint(hdl.calls); got != "[b]" {
+		t.Fatalf("got: %s, expected: [b]", got)
+	}
+}
+
+func TestRunWhen(t *testing.T) {
+	hdl := &fakeHandler{}
+	wf := newFakeWorkflow(hdl, 1, steps.Create)
+	wf.ns = "krateo-system"
+	wf.env.Set("COMPOSABLE_PORTAL", "false")
+
+	spec := objectSteps(nil, "a", "b", "c", "d")
+	spec.Steps[0].When = `.vars.COMPOSABLE_PORTAL == "true"`
+	spec.Steps[1].When = `.cluster.namespace == "krateo-system"`
+	spec.Steps[2].When = `.vars.UNDEFINED`
+
+	results := wf.Run(context.Background(), spec, noSkip)
+	if err := Err(results); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []v1alpha1.StepPhase{
+		v1alpha1.StepSkipped, v1alpha1.StepSucceeded, v1alpha1.StepSkipped, v1alpha1.StepSucceeded,
+	}
+	for i := range want {
+		if got := results[i].Phase(); got != want[i] {
+			t.Fatalf("step %s, got: %s, expected: %s", results[i].ID(), got, want[i])
+		}
+	}
+	if got := fmt.Sprint(hdl.calls); got != "[b d]" {
+		t.Fatalf("got: %s, expected: [b d]", got)
+	}
+}
+
+// TestRunWhenChanged checks that a step skipped by its condition records no
+// digest, so that it runs once the condition is edited to true.
+func TestRunWhenChanged(t *testing.T) {
+	hdl := &fakeHandler{}
+	wf := newFakeWorkflow(hdl, 1, steps.Update)
+
+	spec := objectSteps(nil, "a", "b")
+	spec.Steps[1].When = `false`
+
+	digests := map[string]string{}
+	skip := func(s *v1alpha1.Step) bool {
+		got, ok := digests[s.ID]
+		return ok && got == wf.Digest(s)
+	}
+
+	for _, x := range wf.Run(context.Background(), spec, skip) {
+		digests[x.ID()] = x.Digest()
+	}
+	if got := fmt.Sprint(hdl.calls); got != "[a]" {
+		t.Fatalf("got: %s, expected: [a]", got)
+	}
+
+	spec.Steps[1].When = `true`
+	results := wf.Run(context.Background(), spec, skip)
+	if err := Err(results); err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Phase() != v1alpha1.StepUnchanged || results[1].Phase() != v1alpha1.StepSucceeded {
+		t.Fatalf("expected only step b to run, got: %s, %s", results[0].Phase(), results[1].Phase())
+	}
+	if got := fmt.Sprint(hdl.calls); got != "[a b]" {
+		t.Fatalf("got: %s, expected: [a b]", got)
+	}
+}
+
+func TestRunWhenInvalid(t *testing.T) {
+	wf := newFakeWorkflow(&fakeHandler{}, 1, steps.Create)
+
+	spec := objectSteps(nil, "a")
+	spec.Steps[0].When = `.vars | invalid(`
+
+	if err := Err(wf.Run(context.Background(), spec, noSkip)); err == nil {
+		t.Fatal("expected error for invalid when expression")
+	}
+}
+
+// recordingHandler keeps the with block of each handled step.
+type recordingHandler struct {
+	fakeHandler
+	with map[string]string
+}
+
+func (h *recordingHandler) Handle(ctx context.Context, id string, ext *runtime.RawExtension) (*steps.ObjectResult, error) {
+	h.mu.Lock()
+	if h.with == nil {
+		h.with = map[string]string{}
+	}
+	if ext != nil {
+		h.with[id] = string(ext.Raw)
+	}
+	h.mu.Unlock()
+
+	return h.fakeHandler.Handle(ctx, id, ext)
+}
+
+func TestRunForEach(t *testing.T) {
+	hdl := &recordingHandler{}
+	wf := newFakeWorkflow(&fakeHandler{}, 1, steps.Create)
+	setObjectHandler(wf, hdl)
+	wf.env.Set("TENANTS", `[{"name": "acme", "quota": 3}, {"name": "ev\"il", "quota": {"cpu": 1}}]`)
+
+	spec := &v1alpha1.WorkflowSpec{
+		Steps: []*v1alpha1.Step{
+			{
+				ID: "tenant", Type: v1alpha1.TypeObject,
+				ForEach: &v1alpha1.ForEach{Var: "TENANTS"},
+				When:    `.item.name != "skipped"`,
+				With: &runtime.RawExtension{
+					Raw: []byte(`{"name": "${item.name}-$index", "quota": "${item.quota}", "ns": "$NAMESPACE"}`),
+				},
+			},
+			{
+				ID: "after", Type: v1alpha1.TypeObject,
+			},
+		},
+	}
+
+	results := wf.Run(context.Background(), spec, noSkip)
+	if err := Err(results); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+	if results[1].ID() != "tenant-1" || results[1].Parent() != "tenant" {
+		t.Fatalf("unexpected instance id: %s (parent: %s)", results[1].ID(), results[1].Parent())
+	}
+
+	want := map[string]string{
+		"tenant-0": `{"name": "acme-0", "quota": "3", "ns": "$NAMESPACE"}`,
+		"tenant-1": `{"name": "ev\"il-1", "quota": "{\"cpu\":1}", "ns": "$NAMESPACE"}`,
+	}
+	for id, w := range want {
+		if got := hdl.with[id]; got != w {
+			t.Fatalf("instance %s, got: %s, expected: %s", id, got, w)
+		}
+	}
+}
+
+func TestRunForEachLiteral(t *testing.T) {
+	hdl := &fakeHandler{}
+	wf := newFakeWorkflow(hdl, 1, steps.Delete)
+
+	spec := objectSteps(nil, "a")
+	spec.Steps[0].ForEach = &v1alpha1.ForEach{
+		Items: []apiextensionsv1.JSON{{Raw: []byte(`"x"`)}, {Raw: []byte(`"y"`)}},
+	}
+
+	results := wf.Run(context.Background(), spec, noSkip)
+	if err := Err(results); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := fmt.Sprint(hdl.calls); got != "[a-1 a-0]" {
+		t.Fatalf("got: %s, expected: [a-1 a-0]", got)
+	}
+	if results[0].ID() != "a-0" {
+		t.Fatalf("expected results in declaration order, got: %s first", results[0].ID())
+	}
+}
+
+func TestRunForEachMissingField(t *testing.T) {
+	hdl := &fakeHandler{}
+	wf := newFakeWorkflow(hdl, 1, steps.Create)
+	wf.env.Set("TENANTS", `[{"name": "acme", "quota": 3}, {"name": "umbrella"}]`)
+
+	spec := objectSteps(nil, "tenant")
+	spec.Steps[0].ForEach = &v1alpha1.ForEach{Var: "TENANTS"}
+	spec.Steps[0].With = &runtime.RawExtension{Raw: []byte(`{"name": "${item.name}", "quota": "${item.quota}"}`)}
+
+	err := Err(wf.Run(context.Background(), spec, noSkip))
+	if err == nil || err.Error() != `tenant: forEach: item 1 has no value for ${item.quota}` || !steps.IsPermanent(err) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(hdl.calls) != 0 {
+		t.Fatalf("expected no instance executed, got: %v", hdl.calls)
+	}
+}
+
+func TestRunForEachIDCollision(t *testing.T) {
+	hdl := &fakeHandler{}
+	wf := newFakeWorkflow(hdl, 1, steps.Create)
+
+	spec := objectSteps(nil, "a", "a-1")
+	spec.Steps[0].ForEach = &v1alpha1.ForEach{
+		Items: []apiextensionsv1.JSON{{Raw: []byte(`"x"`)}, {Raw: []byte(`"y"`)}},
+	}
+
+	err := Err(wf.Run(context.Background(), spec, noSkip))
+	if err == nil || err.Error() != `a: forEach: instance a-1 has the id of another step` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(hdl.calls) != 0 {
+		t.Fatalf("expected no step executed, got: %v", hdl.calls)
+	}
+}
+
+func TestRunForEachKey(t *testing.T) {
+	hdl := &recordingHandler{}
+	wf := newFakeWorkflow(&fakeHandler{}, 1, steps.Create)
+	setObjectHandler(wf, hdl)
+
+	spec := objectSteps(nil, "tenant")
+	spec.Steps[0].ForEach = &v1alpha1.ForEach{Var: "TENANTS", Key: ".name"}
+	spec.Steps[0].With = &runtime.RawExtension{Raw: []byte(`{"name": "${item.name}"}`)}
+
+	// the instance of umbrella keeps its id once acme is removed
+	for _, tenants := range []string{`[{"name": "acme"}, {"name": "umbrella"}]`, `[{"name": "umbrella"}]`} {
+		wf.env.Set("TENANTS", tenants)
+
+		results := wf.Run(context.Background(), spec, noSkip)
+		if err := Err(results); err != nil {
+			t.Fatal(err)
+		}
+		if last := results[len(results)-1]; last.ID() != "tenant-umbrella" {
+			t.Fatalf("unexpected instance id: %s", last.ID())
+		}
+	}
+	if got := hdl.with["tenant-umbrella"]; got != `{"name": "umbrella"}` {
+		t.Fatalf("unexpected with: %s", got)
+	}
+
+	tests := []struct {
+		tenants string
+		err     string
+	}{
+		{`[{"name": "acme"}, {"name": "acme"}]`, `tenant: forEach: items 0 and 1 have the same key "acme"`},
+		{`[{"name": "acme"}, {"id": 1}]`, `tenant: forEach: item 1: key ".name": expected a string or a number, got <nil>`},
+		{`[{"name": "a c/me"}]`, `tenant: forEach: item 0: key ".name": "a c/me" is not made of letters, digits, '.', '_' or '-'`},
+	}
+	for _, tc := range tests {
+		wf.env.Set("TENANTS", tc.tenants)
+
+		err := Err(wf.Run(context.Background(), spec, noSkip))
+		if err == nil || err.Error() != tc.err || !steps.IsPermanent(err) {
+			t.Errorf("%s: unexpected error: %v", tc.tenants, err)
+		}
+	}
+}
+
+func TestRunForEachInstanceCollision(t *testing.T) {
+	hdl := &fakeHandler{}
+	wf := newFakeWorkflow(hdl, 1, steps.Create)
+
+	// a-1-x is an instance of both steps
+	spec := objectSteps(nil, "a", "a-1")
+	spec.Steps[0].ForEach = &v1alpha1.ForEach{Items: []apiextensionsv1.JSON{{Raw: []byte(`"1-x"`)}}, Key: "."}
+	spec.Steps[1].ForEach = &v1alpha1.ForEach{Items: []apiextensionsv1.JSON{{Raw: []byte(`"x"`)}}, Key: "."}
+
+	err := Err(wf.Run(context.Background(), spec, noSkip))
+	if err == nil || err.Error() != `a-1: forEach: instance a-1-x has the id of an instance of step a` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestRunForEachUndefinedVar(t *testing.T) {
+	wf := newFakeWorkflow(&fakeHandler{}, 1, steps.Create)
+
+	spec := objectSteps(nil, "a")
+	spec.Steps[0].ForEach = &v1alpha1.ForEach{Var: "MISSING"}
+
+	err := Err(wf.Run(context.Background(), spec, noSkip))
+	if err == nil || err.Error() != `a: forEach: variable "MISSING" is not defined` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
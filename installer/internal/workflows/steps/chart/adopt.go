@@ -0,0 +1,180 @@
+package steps
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/krateoplatformops/installer/pkg/dynamic/applier"
+	"github.com/krateoplatformops/installer/pkg/dynamic/getter"
+	"github.com/krateoplatformops/installer/pkg/helmclient"
+	"github.com/krateoplatformops/installer/pkg/locker"
+	"github.com/krateoplatformops/installer/pkg/workflows/steps"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/yaml"
+)
+
+// The ownership metadata Helm checks before taking over an existing resource.
+const (
+	releaseNameAnnotation      = "meta.helm.sh/release-name"
+	releaseNamespaceAnnotation = "meta.helm.sh/release-namespace"
+	managedByLabel             = "app.kubernetes.io/managed-by"
+	hookAnnotation             = "helm.sh/hook"
+)
+
+// adopt renders the chart and gives the resources that already exist
+// the ownership metadata of the release, so that the install or upgrade
+// takes them over instead of failing. Resources already owned by the
+// release are left as they are. Resources owned by another helm release,
+// unless opts.ForceAdopt is set, or locked by another KrateoPlatformOps
+// are refused: nothing is adopted if any is. It returns the adopted
+// resources, in plan mode the ones that would be adopted, and the
+// refused ones with the reason.
+func (r *chartStepHandler) adopt(ctx context.Context, id string, spec *helmclient.ChartSpec, opts adoptOptions) (adopted, refused []string, err error) {
+	manifest, err := r.cli.TemplateChart(spec, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("rendering chart %s: %w", spec.ChartName, err)
+	}
+
+	all, err := adoptable(manifest)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"annotations": map[string]string{
+				releaseNameAnnotation:      spec.ReleaseName,
+				releaseNamespaceAnnotation: spec.Namespace,
+			},
+			"labels": map[string]string{
+				managedByLabel: "Helm",
+			},
+		},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	todo := []*unstructured.Unstructured{}
+	for _, x := range all {
+		gvk := x.GroupVersionKind()
+		// helm installs the namespaced resources without a namespace in the release namespace
+		namespace := x.GetNamespace()
+		if len(namespace) == 0 {
+			namespace = spec.Namespace
+		}
+
+		cur, err := r.get(ctx, getter.GetOptions{GVK: gvk, Namespace: namespace, Name: x.GetName()})
+		if apierrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if owned(cur, spec) {
+			continue
+		}
+
+		name := steps.ResourceName(cur.GetKind(), cur.GetNamespace(), cur.GetName())
+		if from := cur.GetAnnotations()[releaseNameAnnotation]; len(from) > 0 {
+			release := fmt.Sprintf("%s/%s", cur.GetAnnotations()[releaseNamespaceAnnotation], from)
+			if !opts.ForceAdopt {
+				refused = append(refused, fmt.Sprintf("%s (owned by release %s)", name, release))
+				continue
+			}
+			r.logr.Info(fmt.Sprintf("WARN: %s moves from release %s to release %s/%s", name,
+				release, spec.Namespace, spec.ReleaseName))
+		}
+
+		err = r.lock.Check(ctx, locker.ObjectKey(gvk, cur.GetNamespace(), cur.GetName()))
+		if locker.IsConflict(err) {
+			refused = append(refused, fmt.Sprintf("%s (%s)", name, err.Error()))
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		todo = append(todo, cur)
+		adopted = append(adopted, name)
+	}
+
+	if spec.DryRun || len(refused) > 0 {
+		return adopted, refused, nil
+	}
+
+	for i, cur := range todo {
+		_, err = r.patch(ctx, applier.PatchOptions{
+			GVK:       cur.GroupVersionKind(),
+			Namespace: cur.GetNamespace(),
+			Name:      cur.GetName(),
+			Type:      types.MergePatchType,
+			Data:      patch,
+		})
+		if err != nil {
+			return adopted[:i], nil, fmt.Errorf("adopting %s: %w", adopted[i], err)
+		}
+
+		r.logr.Debug(fmt.Sprintf("[chart:%s]: %s adopted by release %s", id, adopted[i], spec.ReleaseName))
+	}
+
+	return adopted, nil, nil
+}
+
+// adoptOptions tell if a chart step adopts the existing resources.
+type adoptOptions struct {
+	Adopt      bool `json:"adopt,omitempty"`
+	ForceAdopt bool `json:"forceAdopt,omitempty"`
+}
+
+// adoptOf returns the adoption options of a chart step.
+func adoptOf(in []byte) (adoptOptions, error) {
+	res := adoptOptions{}
+	err := json.Unmarshal(in, &res)
+	return res, err
+}
+
+// adoptable returns the resources of a rendered chart in manifest order,
+// hooks excluded since they are not part of the release.
+func adoptable(manifest []byte) ([]unstructured.Unstructured, error) {
+	docs := releaseutil.SplitManifests(string(manifest))
+	keys := make([]string, 0, len(docs))
+	for k := range docs {
+		keys = append(keys, k)
+	}
+	sort.Sort(releaseutil.BySplitManifestsOrder(keys))
+
+	all := []unstructured.Unstructured{}
+	for _, k := range keys {
+		obj := map[string]any{}
+		if err := yaml.Unmarshal([]byte(docs[k]), &obj); err != nil {
+			return nil, err
+		}
+		if len(obj) == 0 {
+			continue
+		}
+
+		u := unstructured.Unstructured{Object: obj}
+		if _, ok := u.GetAnnotations()[hookAnnotation]; ok {
+			continue
+		}
+		all = append(all, u)
+	}
+
+	return all, nil
+}
+
+// owned tells if the resource already belongs to the release.
+func owned(obj *unstructured.Unstructured, spec *helmclient.ChartSpec) bool {
+	annotations := obj.GetAnnotations()
+	return annotations[releaseNameAnnotation] == spec.ReleaseName &&
+		annotations[releaseNamespaceAnnotation] == spec.Namespace &&
+		obj.GetLabels()[managedByLabel] == "Helm"
+}
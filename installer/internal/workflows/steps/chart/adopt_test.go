@@ -0,0 +1,163 @@
+package steps
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/krateoplatformops/installer/pkg/dynamic/applier"
+	"github.com/krateoplatformops/installer/pkg/dynamic/getter"
+	"github.com/krateoplatformops/installer/pkg/helmclient"
+	mockhelmclient "github.com/krateoplatformops/installer/pkg/helmclient/mock"
+	"github.com/krateoplatformops/provider-runtime/pkg/logging"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const authnManifest = `---
+# Source: authn/crds/users.yaml
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: users.basic.authn.krateo.io
+---
+# Source: authn/crds/configs.yaml
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: configs.oauth.authn.krateo.io
+---
+# Source: authn/templates/service.yaml
+apiVersion: v1
+kind: Service
+metadata:
+  name: authn
+---
+# Source: authn/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: authn
+---
+# Source: authn/templates/hook.yaml
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: authn-upgrade
+  annotations:
+    helm.sh/hook: pre-upgrade
+`
+
+func TestAdopt(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	cli := mockhelmclient.NewMockClient(ctrl)
+	cli.EXPECT().TemplateChart(gomock.Any(), gomock.Any()).Return([]byte(authnManifest), nil).AnyTimes()
+
+	existing := map[string]map[string]string{
+		// created by the pre-upgrade script of another chart
+		"CustomResourceDefinition /users.basic.authn.krateo.io": {releaseNameAnnotation: "krateo", releaseNamespaceAnnotation: "krateo-system"},
+		"Job krateo-system/authn-upgrade":                       nil,
+		"Service krateo-system/authn":                           nil,
+		// already owned by the release
+		"Deployment krateo-system/authn": {releaseNameAnnotation: "authn", releaseNamespaceAnnotation: "krateo-system"},
+	}
+
+	patched := []applier.PatchOptions{}
+	hdl := &chartStepHandler{
+		cli:  cli,
+		logr: logging.NewNopLogger(),
+		get: func(_ context.Context, opts getter.GetOptions) (*unstructured.Unstructured, error) {
+			namespace := opts.Namespace
+			if opts.GVK.Kind == "CustomResourceDefinition" {
+				namespace = ""
+			}
+			annotations, ok := existing[opts.GVK.Kind+" "+namespace+"/"+opts.Name]
+			if !ok {
+				return nil, apierrors.NewNotFound(schema.GroupResource{Resource: opts.GVK.Kind}, opts.Name)
+			}
+
+			obj := &unstructured.Unstructured{}
+			obj.SetGroupVersionKind(opts.GVK)
+			obj.SetNamespace(namespace)
+			obj.SetName(opts.Name)
+			obj.SetAnnotations(annotations)
+			if opts.GVK.Kind == "Deployment" {
+				obj.SetLabels(map[string]string{managedByLabel: "Helm"})
+			}
+			return obj, nil
+		},
+		patch: func(_ context.Context, opts applier.PatchOptions) (*unstructured.Unstructured, error) {
+			patched = append(patched, opts)
+			return &unstructured.Unstructured{}, nil
+		},
+	}
+
+	spec := &helmclient.ChartSpec{ReleaseName: "authn", Namespace: "krateo-system", ChartName: "authn"}
+
+	// resources owned by another release are refused
+	got, refused, err := hdl.adopt(context.Background(), "authn", spec, adoptOptions{Adopt: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := "Service krateo-system/authn"; strings.Join(got, ",") != exp {
+		t.Fatalf("got adoptable %v, expected %s", got, exp)
+	}
+	if exp := "CustomResourceDefinition users.basic.authn.krateo.io (owned by release krateo-system/krateo)"; strings.Join(refused, ",") != exp {
+		t.Fatalf("got refused %v, expected %s", refused, exp)
+	}
+	if len(patched) > 0 {
+		t.Fatalf("nothing must be patched when a resource is refused, got: %+v", patched)
+	}
+
+	force := adoptOptions{Adopt: true, ForceAdopt: true}
+
+	// plan mode reports without patching
+	spec.DryRun = true
+	got, refused, err = hdl.adopt(context.Background(), "authn", spec, force)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := "CustomResourceDefinition users.basic.authn.krateo.io,Service krateo-system/authn"
+	if strings.Join(got, ",") != exp || len(refused) > 0 {
+		t.Fatalf("got planned adoptions %v (refused: %v), expected %s", got, refused, exp)
+	}
+	if len(patched) > 0 {
+		t.Fatalf("nothing must be patched in plan mode, got: %+v", patched)
+	}
+
+	spec.DryRun = false
+	got, _, err = hdl.adopt(context.Background(), "authn", spec, force)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got, ",") != exp {
+		t.Fatalf("got adopted %v, expected %s", got, exp)
+	}
+	if len(patched) != 2 {
+		t.Fatalf("got %d patches, expected 2", len(patched))
+	}
+	if data := string(patched[1].Data); data != `{"metadata":{"annotations":{"meta.helm.sh/release-name":"authn","meta.helm.sh/release-namespace":"krateo-system"},"labels":{"app.kubernetes.io/managed-by":"Helm"}}}` {
+		t.Fatalf("unexpected patch: %s", data)
+	}
+	if patched[1].Namespace != "krateo-system" || patched[1].GVK.Kind != "Service" {
+		t.Fatalf("unexpected patch options: %+v", patched[1])
+	}
+}
+
+func TestAdoptOf(t *testing.T) {
+	for raw, exp := range map[string]adoptOptions{
+		`{"repository": "https://charts.krateo.io", "name": "authn", "adopt": true}`:                     {Adopt: true},
+		`{"repository": "https://charts.krateo.io", "name": "authn", "adopt": true, "forceAdopt": true}`: {Adopt: true, ForceAdopt: true},
+		`{"repository": "https://charts.krateo.io", "name": "authn"}`:                                    {},
+	} {
+		got, err := adoptOf([]byte(raw))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != exp {
+			t.Errorf("%s: got %+v, expected %+v", raw, got, exp)
+		}
+	}
+}
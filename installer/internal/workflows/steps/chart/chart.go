@@ -8,55 +8,83 @@ import (
 	"time"
 
 	"github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
-	"github.com/krateoplatformops/installer/internal/cache"
-	"github.com/krateoplatformops/installer/internal/dynamic/getter"
 	"github.com/krateoplatformops/installer/internal/expand"
-	"github.com/krateoplatformops/installer/internal/helmclient"
-	"github.com/krateoplatformops/installer/internal/helmclient/values"
 	"github.com/krateoplatformops/installer/internal/resolvers"
-	"github.com/krateoplatformops/installer/internal/workflows/steps"
+	"github.com/krateoplatformops/installer/pkg/cache"
+	"github.com/krateoplatformops/installer/pkg/dynamic/applier"
+	"github.com/krateoplatformops/installer/pkg/dynamic/getter"
+	"github.com/krateoplatformops/installer/pkg/helmclient"
+	"github.com/krateoplatformops/installer/pkg/helmclient/values"
+	"github.com/krateoplatformops/installer/pkg/locker"
+	"github.com/krateoplatformops/installer/pkg/workflows/steps"
 	"github.com/krateoplatformops/plumbing/ptr"
 	"github.com/krateoplatformops/provider-runtime/pkg/logging"
+	"helm.sh/helm/v3/pkg/chartutil"
+	helmgetter "helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/release"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 )
 
+func init() {
+	steps.Register(v1alpha1.TypeChart, func(opts steps.HandlerOptions) steps.Handler[steps.Result] {
+		return steps.Adapt(ChartHandler(ChartHandlerOptions{
+			HelmClient: opts.HelmClient,
+			Env:        opts.Env,
+			Log:        opts.Log,
+			Dyn:        opts.Getter,
+			Applier:    opts.Applier,
+			Locker:     opts.Locker,
+		}))
+	})
+}
+
 type ChartHandlerOptions struct {
 	Dyn        *getter.Getter
 	HelmClient helmclient.Client
 	Env        *cache.Cache[string, string]
 	Log        logging.Logger
+	// Applier patches the resources adopted by the release.
+	Applier *applier.Applier
+	// Locker, if set, acquires the ownership of the releases.
+	Locker *locker.Locker
 }
 
 func ChartHandler(opts ChartHandlerOptions) steps.Handler[*steps.ChartResult] {
 	hdl := &chartStepHandler{
-		cli:  opts.HelmClient,
-		env:  opts.Env,
-		logr: opts.Log,
-		dyn:  opts.Dyn,
-	}
-	hdl.subst = func(k string) string {
-		if v, ok := hdl.env.Get(k); ok {
-			return v
-		}
-
-		return "$" + k
+		cli:   opts.HelmClient,
+		env:   opts.Env,
+		logr:  opts.Log,
+		dyn:   opts.Dyn,
+		lock:  opts.Locker,
+		get:   opts.Dyn.Get,
+		patch: opts.Applier.Patch,
 	}
+	hdl.subst = steps.Subst(hdl.env)
 
 	return hdl
 }
 
-var _ steps.Handler[*steps.ChartResult] = (*chartStepHandler)(nil)
+var (
+	_ steps.Handler[*steps.ChartResult] = (*chartStepHandler)(nil)
+	_ steps.Rollbacker                  = (*chartStepHandler)(nil)
+	_ steps.Planner                     = (*chartStepHandler)(nil)
+)
 
 type chartStepHandler struct {
 	cli    helmclient.Client
 	env    *cache.Cache[string, string]
 	ns     string
 	op     steps.Op
-	subst  func(k string) string
+	subst  func(k string) (string, bool)
 	render bool
+	plan   bool
 	logr   logging.Logger
 	dyn    *getter.Getter
+	lock   *locker.Locker
+	get    func(ctx context.Context, opts getter.GetOptions) (*unstructured.Unstructured, error)
+	patch  func(ctx context.Context, opts applier.PatchOptions) (*unstructured.Unstructured, error)
 }
 
 func (r *chartStepHandler) Namespace(ns string) {
@@ -67,6 +95,10 @@ func (r *chartStepHandler) Op(op steps.Op) {
 	r.op = op
 }
 
+func (r *chartStepHandler) DryRun(on bool) {
+	r.plan = on
+}
+
 func (r *chartStepHandler) Handle(ctx context.Context, id string, ext *runtime.RawExtension) (*steps.ChartResult, error) {
 	spec, err := r.toChartSpec(ctx, id, ext)
 	if err != nil {
@@ -75,9 +107,42 @@ func (r *chartStepHandler) Handle(ctx context.Context, id string, ext *runtime.R
 
 	result := &steps.ChartResult{}
 
+	adopt, err := adoptOf(ext.Raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.op != steps.Delete && r.plan {
+		result.Operation = "plan"
+		result.Plan, err = r.planRelease(ctx, id, spec, adopt)
+		return result, err
+	}
+
+	key := locker.ReleaseKey(spec.Namespace, spec.ReleaseName)
+
 	if r.op != steps.Delete {
 		result.Operation = "install/upgrade"
 
+		if err := r.lock.Acquire(ctx, key); err != nil {
+			return result, err
+		}
+
+		if adopt.Adopt {
+			result.Adopted, result.Refused, err = r.adopt(ctx, id, spec, adopt)
+			if err != nil {
+				return result, err
+			}
+			if len(result.Refused) > 0 {
+				result.Adopted = nil
+				return result, steps.Permanent(fmt.Errorf("release %s can't adopt %s",
+					spec.ReleaseName, strings.Join(result.Refused, ", ")))
+			}
+			if len(result.Adopted) > 0 {
+				r.logr.Info(fmt.Sprintf("[chart:%s]: release %s adopted %s", id,
+					spec.ReleaseName, strings.Join(result.Adopted, ", ")))
+			}
+		}
+
 		release, err := r.cli.InstallOrUpgradeChart(ctx, spec, nil)
 		if err != nil {
 			return result, err
@@ -92,6 +157,21 @@ func (r *chartStepHandler) Handle(ctx context.Context, id string, ext *runtime.R
 			result.Namespace = release.Namespace
 			result.Updated = metav1.NewTime(release.Info.LastDeployed.Time)
 			result.Revision = release.Version
+
+			outputs, err := steps.OutputsOf(ext.Raw)
+			if err != nil {
+				return result, err
+			}
+			if len(outputs) > 0 {
+				src, err := releaseOutputs(release)
+				if err != nil {
+					return result, err
+				}
+				result.Outputs, err = steps.SetOutputs(ctx, r.env, outputs, src)
+				if err != nil {
+					return result, err
+				}
+			}
 		}
 
 		r.logr.Debug(fmt.Sprintf(
@@ -103,17 +183,29 @@ func (r *chartStepHandler) Handle(ctx context.Context, id string, ext *runtime.R
 
 	result.Operation = "uninstall"
 
+	// the release now belongs to another KrateoPlatformOps
+	if err := r.lock.Acquire(ctx, key); locker.IsConflict(err) {
+		r.logr.Info(fmt.Sprintf("WARN: not uninstalling, %s", err.Error()))
+		result.Status = "not_owned"
+		return result, nil
+	} else if err != nil {
+		return result, err
+	}
+
 	err = r.cli.UninstallRelease(spec)
 	if err != nil {
 		r.logr.Info(fmt.Sprintf("WARN: %s (%s)", err.Error(), spec.ChartName))
-		if strings.Contains(err.Error(), "release: not found") {
-			result.Status = "not_found"
-			return result, nil
+		if !strings.Contains(err.Error(), "release: not found") {
+			return result, err
 		}
-		return result, err
+		result.Status = "not_found"
+		return result, r.lock.Release(ctx, key)
 	}
 
 	result.Status = "uninstalled"
+	if err := r.lock.Release(ctx, key); err != nil {
+		return result, err
+	}
 
 	r.logr.Debug(fmt.Sprintf(
 		"[chart:%s]: uninstall operation completed for release %s",
@@ -122,6 +214,150 @@ func (r *chartStepHandler) Handle(ctx context.Context, id string, ext *runtime.R
 	return result, nil
 }
 
+// releaseOutputs returns the release data the outputs are evaluated against.
+func releaseOutputs(rel *release.Release) (map[string]any, error) {
+	values, err := chartutil.CoalesceValues(rel.Chart, rel.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	src := map[string]any{
+		"name":      rel.Name,
+		"namespace": rel.Namespace,
+		"revision":  rel.Version,
+		"manifest":  rel.Manifest,
+		"values":    values.AsMap(),
+		"chart": map[string]any{
+			"name":       rel.Chart.Metadata.Name,
+			"version":    rel.Chart.Metadata.Version,
+			"appVersion": rel.Chart.Metadata.AppVersion,
+		},
+	}
+	if rel.Info != nil {
+		src["status"] = string(rel.Info.Status)
+		src["notes"] = rel.Info.Notes
+	}
+
+	// plain JSON values, as expected by the selectors
+	dat, err := json.Marshal(src)
+	if err != nil {
+		return nil, err
+	}
+	res := map[string]any{}
+	err = json.Unmarshal(dat, &res)
+	return res, err
+}
+
+// planRelease compares the release with the result of a dry-run install or upgrade.
+// The resources that would be adopted, or refused, are listed as changes.
+func (r *chartStepHandler) planRelease(ctx context.Context, id string, spec *helmclient.ChartSpec, adopt adoptOptions) (*v1alpha1.PlannedChange, error) {
+	spec.DryRun = true
+	spec.UpgradeCRDs = false
+	spec.Wait = false
+
+	change := &v1alpha1.PlannedChange{
+		Action:   "install",
+		Resource: fmt.Sprintf("release %s/%s", spec.Namespace, spec.ReleaseName),
+	}
+
+	adopted := []string{}
+	if adopt.Adopt {
+		all, refused, err := r.adopt(ctx, id, spec, adopt)
+		if err != nil {
+			return nil, err
+		}
+		for _, el := range all {
+			adopted = append(adopted, "~ "+el+" (adopt)")
+		}
+		for _, el := range refused {
+			adopted = append(adopted, "! "+el+" (refused)")
+		}
+	}
+
+	cur, err := r.cli.GetRelease(spec.ReleaseName)
+	if err != nil {
+		if !strings.Contains(err.Error(), "release: not found") {
+			return nil, err
+		}
+	}
+
+	rel, err := r.cli.InstallOrUpgradeChart(ctx, spec, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if cur == nil {
+		change.ChartVersion = rel.Chart.Metadata.Version
+		change.Changes = append(change.Changes, adopted...)
+		return change, nil
+	}
+
+	change.Action = "upgrade"
+	if from, to := cur.Chart.Metadata.Version, rel.Chart.Metadata.Version; from != to {
+		change.ChartVersion = fmt.Sprintf("%s -> %s", from, to)
+	}
+	change.Values = steps.DiffPaths(cur.Config, rel.Config)
+	change.Changes, err = steps.DiffManifests(cur.Manifest, rel.Manifest)
+	if err != nil {
+		return nil, err
+	}
+	change.Changes = append(change.Changes, adopted...)
+
+	if len(change.ChartVersion) == 0 && len(change.Values) == 0 && len(change.Changes) == 0 {
+		change.Action = "none"
+	}
+
+	return change, nil
+}
+
+// Prepare records the current revision of the release. The returned
+// rollback restores that revision if the step created new ones,
+// or uninstalls the release if it didn't exist.
+func (r *chartStepHandler) Prepare(ctx context.Context, id string, ext *runtime.RawExtension) (steps.Rollback, error) {
+	if r.op == steps.Delete {
+		return nil, nil
+	}
+
+	spec, err := r.toChartSpec(ctx, id, ext)
+	if err != nil {
+		return nil, err
+	}
+
+	rel, err := r.cli.GetRelease(spec.ReleaseName)
+	if err != nil {
+		if !strings.Contains(err.Error(), "release: not found") {
+			return nil, err
+		}
+
+		return func(ctx context.Context) error {
+			r.logr.Debug(fmt.Sprintf("[chart:%s]: rollback, uninstalling release %s", id, spec.ReleaseName))
+
+			err := r.cli.UninstallRelease(spec)
+			if err != nil && strings.Contains(err.Error(), "release: not found") {
+				return nil
+			}
+			return err
+		}, nil
+	}
+
+	revision := rel.Version
+
+	return func(ctx context.Context) error {
+		cur, err := r.cli.GetRelease(spec.ReleaseName)
+		if err != nil {
+			return err
+		}
+		if cur.Version == revision {
+			return nil
+		}
+
+		r.logr.Debug(fmt.Sprintf("[chart:%s]: rollback, restoring revision %d of release %s",
+			id, revision, spec.ReleaseName))
+
+		return r.cli.RollbackReleaseTo(spec, revision)
+	}, nil
+}
+
 func (r *chartStepHandler) toChartSpec(ctx context.Context, id string, ext *runtime.RawExtension) (*helmclient.ChartSpec, error) {
 	res := v1alpha1.ChartSpec{}
 	err := json.Unmarshal(ext.Raw, &res)
@@ -145,6 +381,10 @@ func (r *chartStepHandler) toChartSpec(ctx context.Context, id string, ext *runt
 	if res.WaitTimeout != nil {
 		timeout = res.WaitTimeout.Duration
 	}
+	// don't wait beyond the step timeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = min(timeout, time.Until(deadline))
+	}
 
 	spec := &helmclient.ChartSpec{
 		ReleaseName:     res.Name,
@@ -159,9 +399,13 @@ func (r *chartStepHandler) toChartSpec(ctx context.Context, id string, ext *runt
 		Timeout:         timeout,
 		Repository:      res.Name,
 	}
+	if _, err := spec.ValuesOptions.MergeValues(helmgetter.Providers{}); err != nil {
+		return nil, steps.Permanent(err)
+	}
 	if res.InsecureSkipTLSVerify != nil {
 		spec.InsecureSkipTLSverify = *res.InsecureSkipTLSVerify
 	}
+	spec.KeepHistory = res.KeepHistory
 	if res.URL != "" {
 		spec.ChartName = res.URL
 		spec.ReleaseName = steps.DeriveReleaseName(res.URL)
@@ -171,7 +415,7 @@ func (r *chartStepHandler) toChartSpec(ctx context.Context, id string, ext *runt
 	}
 
 	if res.Credentials != nil {
-		secret, err := resolvers.GetSecret(ctx, *r.dyn, res.Credentials.PasswordRef)
+		secret, err := resolvers.GetSecret(ctx, r.dyn.Get, res.Credentials.PasswordRef)
 		if err != nil {
 			return nil, fmt.Errorf("failed to get secret: %w", err)
 		}
@@ -188,7 +432,7 @@ func (r *chartStepHandler) valuesOptions(id string, res []*v1alpha1.Data) (opts
 
 	for _, el := range res {
 		if len(el.Value) > 0 {
-			val := expand.Expand(el.Value, "", r.subst)
+			val := expand.Known(el.Value, r.subst)
 			line := fmt.Sprintf("%s=%s", el.Name, val)
 			if ptr.Deref(el.AsString, false) {
 				opts.StringValues = append(opts.StringValues, line)
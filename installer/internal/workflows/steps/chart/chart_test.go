@@ -20,10 +20,10 @@ import (
 	"sigs.k8s.io/e2e-framework/pkg/features"
 	"sigs.k8s.io/e2e-framework/support/kind"
 
-	"github.com/krateoplatformops/installer/internal/cache"
-	"github.com/krateoplatformops/installer/internal/dynamic/getter"
-	"github.com/krateoplatformops/installer/internal/helmclient"
-	"github.com/krateoplatformops/installer/internal/workflows/steps"
+	"github.com/krateoplatformops/installer/pkg/cache"
+	"github.com/krateoplatformops/installer/pkg/dynamic/getter"
+	"github.com/krateoplatformops/installer/pkg/helmclient"
+	"github.com/krateoplatformops/installer/pkg/workflows/steps"
 	"github.com/krateoplatformops/provider-runtime/pkg/logging"
 )
 
@@ -0,0 +1,59 @@
+package steps
+
+import (
+	"context"
+	"testing"
+
+	"github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+	"github.com/krateoplatformops/installer/pkg/cache"
+	"github.com/krateoplatformops/installer/pkg/workflows/steps"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/release"
+)
+
+func TestReleaseOutputs(t *testing.T) {
+	rel := &release.Release{
+		Name:      "postgresql",
+		Namespace: "krateo-system",
+		Version:   3,
+		Manifest:  "---\nkind: Service\n",
+		Info:      &release.Info{Status: release.StatusDeployed, Notes: "connect to postgresql:5432"},
+		Config:    map[string]any{"auth": map[string]any{"database": "krateo"}},
+		Chart: &chart.Chart{
+			Metadata: &chart.Metadata{Name: "postgresql", Version: "12.1.0", AppVersion: "15.1"},
+			Values:   map[string]any{"auth": map[string]any{"database": "postgres", "port": 5432}},
+		},
+	}
+
+	src, err := releaseOutputs(rel)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := cache.New[string, string]()
+	_, err = steps.SetOutputs(context.Background(), env, []*v1alpha1.Output{
+		{Name: "revision", Selector: ".revision"},
+		{Name: "database", Selector: ".values.auth.database"},
+		{Name: "port", Selector: ".values.auth.port"},
+		{Name: "version", Selector: ".chart.version"},
+		{Name: "notes", Selector: ".notes"},
+		{Name: "status", Selector: ".status"},
+	}, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := map[string]string{
+		"revision": "3",
+		"database": "krateo",
+		"port":     "5432",
+		"version":  "12.1.0",
+		"notes":    "connect to postgresql:5432",
+		"status":   "deployed",
+	}
+	for k, v := range exp {
+		if got, _ := env.Get(k); got != v {
+			t.Errorf("output %s: got %q, expected %q", k, got, v)
+		}
+	}
+}
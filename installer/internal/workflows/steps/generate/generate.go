@@ -0,0 +1,313 @@
+package steps
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+	"github.com/krateoplatformops/installer/internal/expand"
+	"github.com/krateoplatformops/installer/pkg/cache"
+	"github.com/krateoplatformops/installer/pkg/dynamic/applier"
+	"github.com/krateoplatformops/installer/pkg/dynamic/deletor"
+	"github.com/krateoplatformops/installer/pkg/dynamic/getter"
+	"github.com/krateoplatformops/installer/pkg/locker"
+	"github.com/krateoplatformops/installer/pkg/workflows/steps"
+	"github.com/krateoplatformops/plumbing/ptr"
+	"github.com/krateoplatformops/provider-runtime/pkg/logging"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// AnnotationGeneratedAt records on the Secret when its values have been
+// generated, the rotation schedule starts from it.
+const AnnotationGeneratedAt = "krateo.io/generated-at"
+
+var (
+	_ steps.Handler[*steps.GenerateResult] = (*generateStepHandler)(nil)
+	_ steps.Planner                        = (*generateStepHandler)(nil)
+)
+
+func init() {
+	steps.Register(v1alpha1.TypeGenerate, func(opts steps.HandlerOptions) steps.Handler[steps.Result] {
+		return steps.Adapt(GenerateHandler(opts.Applier, opts.Deletor, opts.Getter, opts.Locker, opts.Env, opts.Log))
+	})
+}
+
+// GenerateHandler returns the handler of the generate steps, lock can be nil.
+func GenerateHandler(app *applier.Applier, del *deletor.Deletor, dyn *getter.Getter, lock *locker.Locker, env *cache.Cache[string, string], logr logging.Logger) steps.Handler[*steps.GenerateResult] {
+	return &generateStepHandler{
+		objs: steps.Objects{
+			Apply: app.ApplyObject, Get: dyn.Get, Delete: del.Delete,
+			Reset: func() { app.Reset(); dyn.Reset() },
+			Lock:  lock, Log: logr,
+		},
+		env:   env,
+		now:   time.Now,
+		subst: steps.SubstJSON(env),
+		logr:  logr,
+	}
+}
+
+type generateStepHandler struct {
+	objs  steps.Objects
+	env   *cache.Cache[string, string]
+	now   func() time.Time
+	ns    string
+	op    steps.Op
+	plan  bool
+	subst func(k string) (string, bool)
+	logr  logging.Logger
+}
+
+func (r *generateStepHandler) Namespace(ns string) {
+	r.ns = ns
+}
+
+func (r *generateStepHandler) Op(op steps.Op) {
+	r.op = op
+}
+
+func (r *generateStepHandler) DryRun(on bool) {
+	r.plan = on
+}
+
+// Handle creates the Secret if it doesn't exist, adds the values missing
+// from an existing one and regenerates all of them when the rotation is due.
+// The values already in the Secret are never changed otherwise.
+func (r *generateStepHandler) Handle(ctx context.Context, id string, ext *runtime.RawExtension) (*steps.GenerateResult, error) {
+	raw := expand.Known(string(ext.Raw), r.subst)
+
+	spec := v1alpha1.GenerateSpec{}
+	if err := json.Unmarshal([]byte(raw), &spec); err != nil {
+		return nil, err
+	}
+	if err := validate(&spec); err != nil {
+		return nil, steps.Permanent(err)
+	}
+
+	namespace := spec.Metadata.Namespace
+	if len(namespace) == 0 {
+		namespace = r.ns
+	}
+
+	result := &steps.GenerateResult{Name: spec.Metadata.Name, Namespace: namespace}
+
+	secret := unstructured.Unstructured{}
+	secret.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("Secret"))
+	secret.SetName(spec.Metadata.Name)
+	secret.SetNamespace(namespace)
+
+	if r.op == steps.Delete {
+		result.Operation = "delete"
+		return result, r.objs.DeleteAll(ctx, []unstructured.Unstructured{secret})
+	}
+
+	cur, err := r.objs.Get(ctx, getter.GetOptions{
+		GVK:       secret.GroupVersionKind(),
+		Namespace: namespace,
+		Name:      spec.Metadata.Name,
+	})
+	if apierrors.IsNotFound(err) {
+		cur = nil
+	} else if err != nil {
+		return result, err
+	}
+
+	now := r.now().UTC().Truncate(time.Second)
+	values, generatedAt, err := valuesOf(cur)
+	if err != nil {
+		return result, err
+	}
+
+	rotate := cur != nil && spec.RotateEvery != nil && !now.Before(generatedAt.Add(spec.RotateEvery.Duration))
+	if rotate {
+		values = map[string][]byte{}
+	}
+	if cur == nil || rotate {
+		generatedAt = now
+	}
+
+	missing := []string{}
+	for _, x := range spec.Values {
+		for _, k := range keysOf(&x) {
+			if _, ok := values[k]; !ok {
+				missing = append(missing, k)
+			}
+		}
+	}
+
+	if r.plan {
+		result.Operation = "plan"
+		result.Plan = planSecret(steps.ResourceName("Secret", namespace, spec.Metadata.Name), cur == nil, rotate, missing)
+		return result, nil
+	}
+
+	result.Operation = "none"
+	if len(missing) > 0 {
+		if err := generate(values, spec.Values, now); err != nil {
+			return result, err
+		}
+
+		data := make(map[string]any, len(values))
+		for k, v := range values {
+			data[k] = base64.StdEncoding.EncodeToString(v)
+		}
+		secret.SetAnnotations(map[string]string{AnnotationGeneratedAt: generatedAt.Format(time.RFC3339)})
+		secret.Object["type"] = string(secretType(&spec, cur))
+		secret.Object["data"] = data
+
+		if _, err := r.objs.ApplyAll(ctx, []unstructured.Unstructured{secret}); err != nil {
+			return result, err
+		}
+
+		switch {
+		case cur == nil:
+			result.Operation = "create"
+		case rotate:
+			result.Operation = "rotate"
+		default:
+			result.Operation = "update"
+		}
+
+		r.logr.Debug(fmt.Sprintf("[generate:%s]: %s secret %s/%s (keys: %v)",
+			id, result.Operation, namespace, spec.Metadata.Name, missing))
+	}
+
+	result.GeneratedAt = metav1.NewTime(generatedAt)
+	if spec.RotateEvery != nil {
+		result.RotateAt = ptr.To(metav1.NewTime(generatedAt.Add(spec.RotateEvery.Duration)))
+	}
+
+	for _, x := range spec.Values {
+		if len(x.Var) == 0 {
+			continue
+		}
+		val := string(values[publicKeyOf(&x)])
+		r.env.Set(x.Var, val)
+		result.Outputs = append(result.Outputs, steps.Output{Data: v1alpha1.Data{Name: x.Var, Value: val}})
+	}
+
+	return result, nil
+}
+
+// valuesOf returns the decoded values of the Secret and when they have
+// been generated, the creation time of Secrets not created by a step.
+func valuesOf(cur *unstructured.Unstructured) (map[string][]byte, time.Time, error) {
+	res := map[string][]byte{}
+	if cur == nil {
+		return res, time.Time{}, nil
+	}
+
+	data, _, err := unstructured.NestedStringMap(cur.Object, "data")
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	for k, v := range data {
+		res[k], err = base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("secret %s/%s, key %q: %w", cur.GetNamespace(), cur.GetName(), k, err)
+		}
+	}
+
+	at := cur.GetCreationTimestamp().Time
+	if val, ok := cur.GetAnnotations()[AnnotationGeneratedAt]; ok {
+		if t, err := time.Parse(time.RFC3339, val); err == nil {
+			at = t
+		}
+	}
+
+	return res, at, nil
+}
+
+// secretType keeps the type of an existing Secret, since it is immutable.
+func secretType(spec *v1alpha1.GenerateSpec, cur *unstructured.Unstructured) corev1.SecretType {
+	if cur != nil {
+		if val, ok, _ := unstructured.NestedString(cur.Object, "type"); ok && len(val) > 0 {
+			return corev1.SecretType(val)
+		}
+	}
+	if len(spec.Type) > 0 {
+		return spec.Type
+	}
+	return corev1.SecretTypeOpaque
+}
+
+// planSecret reports the keys that would be generated, never the values.
+func planSecret(resource string, create, rotate bool, missing []string) *v1alpha1.PlannedChange {
+	change := &v1alpha1.PlannedChange{Action: "none", Resource: resource}
+
+	sign := "+ "
+	switch {
+	case create:
+		change.Action = "create"
+	case rotate:
+		change.Action, sign = "rotate", "~ "
+	case len(missing) > 0:
+		change.Action = "update"
+	}
+	for _, k := range missing {
+		change.Changes = append(change.Changes, sign+k)
+	}
+
+	return change
+}
+
+func validate(spec *v1alpha1.GenerateSpec) error {
+	if len(spec.Metadata.Name) == 0 {
+		return fmt.Errorf("metadata.name is required")
+	}
+	if len(spec.Values) == 0 {
+		return fmt.Errorf("values are required")
+	}
+	if spec.RotateEvery != nil && spec.RotateEvery.Duration <= 0 {
+		return fmt.Errorf("rotateEvery must be positive")
+	}
+
+	types := map[string]v1alpha1.GenerateType{}
+	for _, x := range spec.Values {
+		if len(x.Name) == 0 {
+			return fmt.Errorf("values: name is required")
+		}
+		if _, ok := types[x.Name]; ok {
+			return fmt.Errorf("value %s: duplicate name", x.Name)
+		}
+
+		switch x.Type {
+		case v1alpha1.GenerateRandom:
+			if len(x.Var) > 0 {
+				return fmt.Errorf("value %s: random values can't be exposed as variables", x.Name)
+			}
+			if x.Length != nil && (*x.Length <= 0 || *x.Length > 4096) {
+				return fmt.Errorf("value %s: length must be between 1 and 4096", x.Name)
+			}
+		case v1alpha1.GenerateCertificate:
+			if types[x.CA] != v1alpha1.GenerateCA {
+				return fmt.Errorf("value %s: ca must name a ca value before it", x.Name)
+			}
+		case v1alpha1.GenerateKeyPair, v1alpha1.GenerateCA:
+		default:
+			return fmt.Errorf("value %s: unknown type %q", x.Name, x.Type)
+		}
+
+		if !slices.Contains([]string{"", "ecdsa", "rsa", "ed25519"}, x.Algorithm) {
+			return fmt.Errorf("value %s: unknown algorithm %q", x.Name, x.Algorithm)
+		}
+		for _, ip := range x.IPAddresses {
+			if net.ParseIP(ip) == nil {
+				return fmt.Errorf("value %s: invalid ip address %q", x.Name, ip)
+			}
+		}
+
+		types[x.Name] = x.Type
+	}
+
+	return nil
+}
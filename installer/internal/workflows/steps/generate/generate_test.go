@@ -0,0 +1,335 @@
+package steps
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+	"github.com/krateoplatformops/installer/pkg/cache"
+	"github.com/krateoplatformops/installer/pkg/dynamic/applier"
+	"github.com/krateoplatformops/installer/pkg/dynamic/deletor"
+	"github.com/krateoplatformops/installer/pkg/dynamic/getter"
+	"github.com/krateoplatformops/installer/pkg/workflows/steps"
+	"github.com/krateoplatformops/provider-runtime/pkg/logging"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const tlsSpec = `{
+	"metadata": {"name": "snowplow-tls"},
+	"values": [
+		{"name": "JWT_SIGN_KEY", "type": "random", "length": 12},
+		{"name": "ca", "type": "ca", "commonName": "krateo-ca", "var": "CA_BUNDLE"},
+		{"name": "tls", "type": "certificate", "ca": "ca", "dnsNames": ["snowplow.$KRATEO_NAMESPACE.svc"], "ipAddresses": ["10.0.0.1"]},
+		{"name": "sign", "type": "keypair", "algorithm": "ed25519", "var": "SIGN_PUBLIC_KEY"}
+	],
+	"rotateEvery": "720h"
+}`
+
+// now is the time of the first run of the tests.
+var now = time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+
+// fakeHandler returns a handler storing the applied Secret, so that
+// the next get returns it, and recording the applied and deleted objects.
+func fakeHandler(t *testing.T) (*generateStepHandler, *map[string]any, *[]string) {
+	t.Helper()
+
+	var stored map[string]any
+	calls := []string{}
+
+	env := cache.New[string, string]()
+	env.Set("KRATEO_NAMESPACE", "krateo-system")
+
+	hdl := GenerateHandler(nil, nil, nil, nil, env, logging.NewNopLogger()).(*generateStepHandler)
+	hdl.Namespace("krateo-system")
+	hdl.now = func() time.Time { return now }
+	hdl.objs.Apply = func(_ context.Context, content map[string]any, opts applier.ApplyOptions) (*unstructured.Unstructured, error) {
+		calls = append(calls, "apply "+steps.ResourceName(opts.GVK.Kind, opts.Namespace, opts.Name))
+		stored = content
+		return &unstructured.Unstructured{Object: content}, nil
+	}
+	hdl.objs.Delete = func(_ context.Context, opts deletor.DeleteOptions) error {
+		calls = append(calls, "delete "+steps.ResourceName(opts.GVK.Kind, opts.Namespace, opts.Name))
+		return nil
+	}
+	hdl.objs.Get = func(_ context.Context, opts getter.GetOptions) (*unstructured.Unstructured, error) {
+		if stored == nil {
+			return nil, apierrors.NewNotFound(schema.GroupResource{Resource: "secrets"}, opts.Name)
+		}
+		return &unstructured.Unstructured{Object: stored}, nil
+	}
+
+	return hdl, &stored, &calls
+}
+
+func raw(s string) *runtime.RawExtension {
+	return &runtime.RawExtension{Raw: []byte(s)}
+}
+
+func decoded(t *testing.T, secret map[string]any) map[string]string {
+	t.Helper()
+
+	data, _, _ := unstructured.NestedStringMap(secret, "data")
+	res := map[string]string{}
+	for k, v := range data {
+		dat, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res[k] = string(dat)
+	}
+	return res
+}
+
+func parseCert(t *testing.T, val string) *x509.Certificate {
+	t.Helper()
+
+	block, _ := pem.Decode([]byte(val))
+	if block == nil {
+		t.Fatalf("no certificate found in %q", val)
+	}
+	crt, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return crt
+}
+
+func TestGenerateCreate(t *testing.T) {
+	hdl, stored, calls := fakeHandler(t)
+
+	res, err := hdl.Handle(context.Background(), "tls", raw(tlsSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Operation != "create" || strings.Join(*calls, ",") != "apply Secret krateo-system/snowplow-tls" {
+		t.Fatalf("unexpected operation %s, calls: %v", res.Operation, *calls)
+	}
+
+	data := decoded(t, *stored)
+	if len(data) != 7 {
+		t.Fatalf("unexpected keys: %v", data)
+	}
+	if len(data["JWT_SIGN_KEY"]) != 12 {
+		t.Fatalf("unexpected random value: %q", data["JWT_SIGN_KEY"])
+	}
+
+	ca := parseCert(t, data["ca.crt"])
+	leaf := parseCert(t, data["tls.crt"])
+	if !ca.IsCA || ca.Subject.CommonName != "krateo-ca" {
+		t.Fatalf("unexpected ca: %+v", ca.Subject)
+	}
+	if err := leaf.CheckSignatureFrom(ca); err != nil {
+		t.Fatalf("the certificate must be signed by the ca: %v", err)
+	}
+	if err := leaf.VerifyHostname("snowplow.krateo-system.svc"); err != nil {
+		t.Fatal(err)
+	}
+	if err := leaf.VerifyHostname("10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(data["sign.pub"], "PUBLIC KEY") || !strings.Contains(data["sign.key"], "PRIVATE KEY") {
+		t.Fatalf("unexpected keypair: %v", data)
+	}
+
+	if v, _ := hdl.env.Get("CA_BUNDLE"); v != data["ca.crt"] {
+		t.Fatalf("expected the ca bundle in CA_BUNDLE, got: %s", v)
+	}
+	if v, _ := hdl.env.Get("SIGN_PUBLIC_KEY"); v != data["sign.pub"] {
+		t.Fatalf("expected the public key in SIGN_PUBLIC_KEY, got: %s", v)
+	}
+	for _, x := range res.Outputs {
+		if strings.Contains(x.Value, "PRIVATE") || x.Value == data["JWT_SIGN_KEY"] {
+			t.Fatalf("sensitive value exposed as %s", x.Name)
+		}
+	}
+
+	status := &v1alpha1.WorkflowStatus{}
+	res.PopulateStatus(status, "tls")
+	if len(status.SecretList) != 1 || !status.SecretList[0].RotateAt.Time.Equal(now.Add(720*time.Hour)) {
+		t.Fatalf("unexpected secret list: %+v", status.SecretList)
+	}
+	if len(status.ObjectList) != 0 || len(status.VarList) != 2 {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+}
+
+func TestGenerateKeepsValues(t *testing.T) {
+	hdl, stored, calls := fakeHandler(t)
+
+	if _, err := hdl.Handle(context.Background(), "tls", raw(tlsSpec)); err != nil {
+		t.Fatal(err)
+	}
+	before := decoded(t, *stored)
+
+	hdl.now = func() time.Time { return now.Add(24 * time.Hour) }
+
+	res, err := hdl.Handle(context.Background(), "tls", raw(tlsSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Operation != "none" || len(*calls) != 1 {
+		t.Fatalf("nothing must be applied again, got %s, calls: %v", res.Operation, *calls)
+	}
+	if v, _ := hdl.env.Get("CA_BUNDLE"); v != before["ca.crt"] {
+		t.Fatal("expected the existing ca bundle in CA_BUNDLE")
+	}
+
+	// a new value is added, the existing ones are kept
+	more := strings.Replace(tlsSpec, `"values": [`, `"values": [{"name": "COOKIE_KEY", "type": "random"},`, 1)
+	res, err = hdl.Handle(context.Background(), "tls", raw(more))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after := decoded(t, *stored)
+	if res.Operation != "update" || len(after["COOKIE_KEY"]) != 32 {
+		t.Fatalf("unexpected operation %s, keys: %v", res.Operation, after)
+	}
+	for k, v := range before {
+		if after[k] != v {
+			t.Fatalf("value %s changed", k)
+		}
+	}
+	if !res.GeneratedAt.Time.Equal(now) {
+		t.Fatalf("adding a value must not restart the rotation, generated at: %v", res.GeneratedAt)
+	}
+}
+
+func TestGenerateRotate(t *testing.T) {
+	hdl, stored, _ := fakeHandler(t)
+
+	if _, err := hdl.Handle(context.Background(), "tls", raw(tlsSpec)); err != nil {
+		t.Fatal(err)
+	}
+	before := decoded(t, *stored)
+
+	later := now.Add(721 * time.Hour)
+	hdl.now = func() time.Time { return later }
+
+	hdl.DryRun(true)
+	res, err := hdl.Handle(context.Background(), "tls", raw(tlsSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Plan == nil || res.Plan.Action != "rotate" || len(res.Plan.Changes) != 7 {
+		t.Fatalf("unexpected plan: %+v", res.Plan)
+	}
+
+	hdl.DryRun(false)
+	res, err = hdl.Handle(context.Background(), "tls", raw(tlsSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after := decoded(t, *stored)
+	if res.Operation != "rotate" || !res.RotateAt.Time.Equal(later.Add(720*time.Hour)) {
+		t.Fatalf("unexpected operation %s, rotate at: %v", res.Operation, res.RotateAt)
+	}
+	for k, v := range before {
+		if after[k] == v {
+			t.Fatalf("value %s not rotated", k)
+		}
+	}
+	if err := parseCert(t, after["tls.crt"]).CheckSignatureFrom(parseCert(t, after["ca.crt"])); err != nil {
+		t.Fatalf("the certificate must be signed by the new ca: %v", err)
+	}
+}
+
+func TestGenerateAdoptsSecret(t *testing.T) {
+	hdl, stored, _ := fakeHandler(t)
+	*stored = map[string]any{
+		"apiVersion": "v1", "kind": "Secret",
+		"metadata": map[string]any{"name": "jwt-sign-key", "namespace": "krateo-system"},
+		"type":     "Opaque",
+		"data":     map[string]any{"JWT_SIGN_KEY": base64.StdEncoding.EncodeToString([]byte("s3cr3t"))},
+	}
+
+	res, err := hdl.Handle(context.Background(), "jwt", raw(`{
+		"metadata": {"name": "jwt-sign-key"},
+		"values": [{"name": "JWT_SIGN_KEY", "type": "random", "length": 12}]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Operation != "none" || decoded(t, *stored)["JWT_SIGN_KEY"] != "s3cr3t" {
+		t.Fatalf("the existing value must be kept, got %s", res.Operation)
+	}
+}
+
+func TestGenerateDelete(t *testing.T) {
+	hdl, _, calls := fakeHandler(t)
+	hdl.Op(steps.Delete)
+
+	if _, err := hdl.Handle(context.Background(), "tls", raw(tlsSpec)); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(*calls, ","); got != "delete Secret krateo-system/snowplow-tls" {
+		t.Fatalf("unexpected calls: %s", got)
+	}
+}
+
+func TestGenerateInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		err  string
+	}{
+		{
+			name: "no name",
+			raw:  `{"values": [{"name": "KEY", "type": "random"}]}`,
+			err:  "metadata.name is required",
+		},
+		{
+			name: "no values",
+			raw:  `{"metadata": {"name": "x"}}`,
+			err:  "values are required",
+		},
+		{
+			name: "random as var",
+			raw:  `{"metadata": {"name": "x"}, "values": [{"name": "KEY", "type": "random", "var": "KEY"}]}`,
+			err:  "value KEY: random values can't be exposed as variables",
+		},
+		{
+			name: "ca after the certificate",
+			raw:  `{"metadata": {"name": "x"}, "values": [{"name": "tls", "type": "certificate", "ca": "ca"}, {"name": "ca", "type": "ca"}]}`,
+			err:  "value tls: ca must name a ca value before it",
+		},
+		{
+			name: "duplicate",
+			raw:  `{"metadata": {"name": "x"}, "values": [{"name": "ca", "type": "ca"}, {"name": "ca", "type": "keypair"}]}`,
+			err:  "value ca: duplicate name",
+		},
+		{
+			name: "unknown algorithm",
+			raw:  `{"metadata": {"name": "x"}, "values": [{"name": "ca", "type": "ca", "algorithm": "dsa"}]}`,
+			err:  `value ca: unknown algorithm "dsa"`,
+		},
+		{
+			name: "invalid ip",
+			raw:  `{"metadata": {"name": "x"}, "values": [{"name": "ca", "type": "ca", "ipAddresses": ["localhost"]}]}`,
+			err:  `value ca: invalid ip address "localhost"`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			hdl, _, calls := fakeHandler(t)
+
+			_, err := hdl.Handle(context.Background(), "x", raw(tc.raw))
+			if err == nil || !strings.Contains(err.Error(), tc.err) || !steps.IsPermanent(err) {
+				t.Fatalf("expected a permanent error containing %q, got: %v", tc.err, err)
+			}
+			if len(*calls) > 0 {
+				t.Fatal("nothing must be applied")
+			}
+		})
+	}
+}
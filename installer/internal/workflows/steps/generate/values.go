@@ -0,0 +1,228 @@
+package steps
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+
+	"github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+)
+
+const (
+	defaultLength     = 32
+	defaultCAValidity = 10 * 365 * 24 * time.Hour
+	defaultValidity   = 365 * 24 * time.Hour
+
+	alphanumeric = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+)
+
+// keysOf returns the Secret keys holding a value.
+func keysOf(x *v1alpha1.GeneratedValue) []string {
+	switch x.Type {
+	case v1alpha1.GenerateKeyPair:
+		return []string{x.Name + ".key", x.Name + ".pub"}
+	case v1alpha1.GenerateCA, v1alpha1.GenerateCertificate:
+		return []string{x.Name + ".crt", x.Name + ".key"}
+	}
+	return []string{x.Name}
+}
+
+// publicKeyOf returns the Secret key holding the public part of a value.
+func publicKeyOf(x *v1alpha1.GeneratedValue) string {
+	if x.Type == v1alpha1.GenerateKeyPair {
+		return x.Name + ".pub"
+	}
+	return x.Name + ".crt"
+}
+
+// generate adds the values missing from values. The certificates
+// of a CA generated again are generated again as well.
+func generate(values map[string][]byte, all []v1alpha1.GeneratedValue, now time.Time) error {
+	regenerated := map[string]bool{}
+	for _, x := range all {
+		done := true
+		for _, k := range keysOf(&x) {
+			if _, ok := values[k]; !ok {
+				done = false
+			}
+		}
+		if done && !(x.Type == v1alpha1.GenerateCertificate && regenerated[x.CA]) {
+			continue
+		}
+
+		if err := generateValue(values, &x, now); err != nil {
+			return fmt.Errorf("value %s: %w", x.Name, err)
+		}
+		regenerated[x.Name] = true
+	}
+
+	return nil
+}
+
+func generateValue(values map[string][]byte, x *v1alpha1.GeneratedValue, now time.Time) error {
+	if x.Type == v1alpha1.GenerateRandom {
+		n := defaultLength
+		if x.Length != nil {
+			n = *x.Length
+		}
+		val, err := randomString(n)
+		values[x.Name] = []byte(val)
+		return err
+	}
+
+	key, err := newKey(x.Algorithm)
+	if err != nil {
+		return err
+	}
+	keyPEM, err := encodeKey(key)
+	if err != nil {
+		return err
+	}
+
+	if x.Type == v1alpha1.GenerateKeyPair {
+		pub, err := x509.MarshalPKIXPublicKey(key.Public())
+		if err != nil {
+			return err
+		}
+		values[x.Name+".key"] = keyPEM
+		values[x.Name+".pub"] = pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub})
+		return nil
+	}
+
+	var (
+		parent *x509.Certificate
+		signer crypto.Signer
+	)
+	if x.Type == v1alpha1.GenerateCertificate {
+		parent, signer, err = parseCA(values[x.CA+".crt"], values[x.CA+".key"])
+		if err != nil {
+			return fmt.Errorf("ca %s: %w", x.CA, err)
+		}
+	}
+
+	crt, err := newCertificate(x, key, parent, signer, now)
+	if err != nil {
+		return err
+	}
+	values[x.Name+".crt"] = crt
+	values[x.Name+".key"] = keyPEM
+	return nil
+}
+
+// randomString returns n characters picked uniformly from alphanumeric.
+func randomString(n int) (string, error) {
+	max := big.NewInt(int64(len(alphanumeric)))
+	buf := make([]byte, n)
+	for i := range buf {
+		j, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		buf[i] = alphanumeric[j.Int64()]
+	}
+	return string(buf), nil
+}
+
+func newKey(algorithm string) (crypto.Signer, error) {
+	switch algorithm {
+	case "rsa":
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case "ed25519":
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	}
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+func encodeKey(key crypto.Signer) ([]byte, error) {
+	dat, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: dat}), nil
+}
+
+// newCertificate returns a CA certificate, self-signed, if parent is nil;
+// a server and client certificate signed by parent otherwise.
+func newCertificate(x *v1alpha1.GeneratedValue, key crypto.Signer, parent *x509.Certificate, signer crypto.Signer, now time.Time) ([]byte, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	validity := defaultValidity
+	if parent == nil {
+		validity = defaultCAValidity
+	}
+	if x.Validity != nil && x.Validity.Duration > 0 {
+		validity = x.Validity.Duration
+	}
+
+	cn := x.CommonName
+	if len(cn) == 0 {
+		cn = x.Name
+	}
+
+	tpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: cn},
+		DNSNames:              x.DNSNames,
+		NotBefore:             now,
+		NotAfter:              now.Add(validity),
+		BasicConstraintsValid: true,
+	}
+	for _, ip := range x.IPAddresses {
+		tpl.IPAddresses = append(tpl.IPAddresses, net.ParseIP(ip))
+	}
+
+	if parent == nil {
+		tpl.IsCA = true
+		tpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature
+		parent, signer = tpl, key
+	} else {
+		tpl.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+		tpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	}
+
+	dat, err := x509.CreateCertificate(rand.Reader, tpl, parent, key.Public(), signer)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: dat}), nil
+}
+
+func parseCA(crtPEM, keyPEM []byte) (*x509.Certificate, crypto.Signer, error) {
+	block, _ := pem.Decode(crtPEM)
+	if block == nil {
+		return nil, nil, fmt.Errorf("no certificate found")
+	}
+	crt, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	block, _ = pem.Decode(keyPEM)
+	if block == nil {
+		return nil, nil, fmt.Errorf("no private key found")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported private key %T", key)
+	}
+
+	return crt, signer, nil
+}
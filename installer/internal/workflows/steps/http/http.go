@@ -0,0 +1,279 @@
+package steps
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/itchyny/gojq"
+	"github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+	"github.com/krateoplatformops/installer/internal/expand"
+	"github.com/krateoplatformops/installer/pkg/cache"
+	"github.com/krateoplatformops/installer/pkg/dynamic/getter"
+	"github.com/krateoplatformops/installer/pkg/workflows/steps"
+	"github.com/krateoplatformops/plumbing/ptr"
+	"github.com/krateoplatformops/provider-runtime/pkg/logging"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const (
+	defaultRetries       = 3
+	defaultRetryInterval = 5 * time.Second
+	defaultTimeout       = 30 * time.Second
+	// maxBodyBytes bounds the response read.
+	maxBodyBytes = 1 << 20
+	// maxErrorBytes bounds the response reported on unexpected status.
+	maxErrorBytes = 256
+)
+
+var (
+	_ steps.Handler[*steps.HTTPResult] = (*httpStepHandler)(nil)
+	_ steps.Planner                    = (*httpStepHandler)(nil)
+)
+
+func init() {
+	steps.Register(v1alpha1.TypeHTTP, func(opts steps.HandlerOptions) steps.Handler[steps.Result] {
+		return steps.Adapt(HTTPHandler(opts.Getter, opts.Env, opts.Log))
+	})
+}
+
+// HTTPHandler returns the handler of the http steps.
+func HTTPHandler(dyn *getter.Getter, env *cache.Cache[string, string], logr logging.Logger) steps.Handler[*steps.HTTPResult] {
+	return &httpStepHandler{
+		get: dyn.Get, env: env,
+		subst: steps.Subst(env),
+		logr:  logr,
+	}
+}
+
+type httpStepHandler struct {
+	get   func(ctx context.Context, opts getter.GetOptions) (*unstructured.Unstructured, error)
+	env   *cache.Cache[string, string]
+	ns    string
+	op    steps.Op
+	plan  bool
+	subst func(k string) (string, bool)
+	logr  logging.Logger
+}
+
+func (r *httpStepHandler) Namespace(ns string) {
+	r.ns = ns
+}
+
+func (r *httpStepHandler) Op(op steps.Op) {
+	r.op = op
+}
+
+func (r *httpStepHandler) DryRun(on bool) {
+	r.plan = on
+}
+
+// Handle calls the endpoint, retrying on network errors and on the status
+// codes worth retrying, and stores the outputs selected from the JSON
+// response. Variables in a JSON body are escaped as JSON string content.
+// Nothing is called when the workflow is deleted.
+func (r *httpStepHandler) Handle(ctx context.Context, id string, ext *runtime.RawExtension) (*steps.HTTPResult, error) {
+	if r.op == steps.Delete {
+		return nil, nil
+	}
+
+	spec := v1alpha1.HTTPSpec{}
+	if err := json.Unmarshal(ext.Raw, &spec); err != nil {
+		return nil, err
+	}
+
+	method := strings.ToUpper(spec.Method)
+	if len(method) == 0 {
+		method = http.MethodGet
+	}
+
+	uri := expand.Known(spec.URL, r.subst)
+	if u, err := url.Parse(uri); err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return nil, steps.Permanent(fmt.Errorf("url must be an http(s) address, got %q", uri))
+	}
+	for _, x := range spec.Outputs {
+		if _, err := gojq.Parse(x.Selector); err != nil {
+			return nil, steps.Permanent(fmt.Errorf("output %s: invalid selector %q: %w", x.Name, x.Selector, err))
+		}
+	}
+
+	result := &steps.HTTPResult{Method: method, URL: uri}
+
+	if r.plan {
+		result.Plan = &v1alpha1.PlannedChange{
+			Action:   "call",
+			Resource: method + " " + uri,
+		}
+		return result, nil
+	}
+
+	headers, err := r.headers(ctx, &spec)
+	if err != nil {
+		return result, err
+	}
+
+	retries, interval, timeout := int32(defaultRetries), defaultRetryInterval, defaultTimeout
+	if spec.Retries != nil && *spec.Retries >= 0 {
+		retries = *spec.Retries
+	}
+	if spec.RetryInterval != nil && spec.RetryInterval.Duration > 0 {
+		interval = spec.RetryInterval.Duration
+	}
+	if spec.Timeout != nil && spec.Timeout.Duration > 0 {
+		timeout = spec.Timeout.Duration
+	}
+
+	cli := newClient(ptr.Deref(spec.InsecureSkipTLSVerify, false), timeout)
+
+	subst := r.subst
+	if strings.Contains(strings.ToLower(headers.Get("Content-Type")), "json") {
+		subst = steps.SubstJSON(r.env)
+	}
+	body := expand.Known(spec.Body, subst)
+
+	var dat []byte
+	for attempt := int32(0); ; attempt++ {
+		result.StatusCode, dat, err = call(ctx, cli, method, uri, headers, body, spec.ExpectedStatus)
+		if err == nil {
+			break
+		}
+		if attempt >= retries || steps.IsPermanent(err) {
+			return result, fmt.Errorf("%s %s: %w", method, uri, err)
+		}
+
+		r.logr.Debug(fmt.Sprintf("[http:%s]: attempt %d of %d failed: %s", id, attempt+1, retries+1, err.Error()))
+
+		select {
+		case <-ctx.Done():
+			return result, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+
+	r.logr.Debug(fmt.Sprintf("[http:%s]: %s %s returned %d", id, method, uri, result.StatusCode))
+
+	if len(spec.Outputs) == 0 {
+		return result, nil
+	}
+
+	// not wrapped: i.e. an error page, it may be fixed by a later run
+	src := map[string]any{}
+	if err := json.Unmarshal(dat, &src); err != nil {
+		return result, fmt.Errorf("%s %s: the response is not a JSON object: %s", method, uri, err.Error())
+	}
+
+	result.Outputs, err = steps.SetOutputs(ctx, r.env, spec.Outputs, src)
+	return result, err
+}
+
+// headers returns the headers of the request, the ones read
+// from the Secret take precedence over the inline ones.
+func (r *httpStepHandler) headers(ctx context.Context, spec *v1alpha1.HTTPSpec) (http.Header, error) {
+	res := http.Header{}
+	for k, v := range spec.Headers {
+		res.Set(k, expand.Known(v, r.subst))
+	}
+
+	ref := spec.HeadersFrom
+	if ref == nil {
+		return res, nil
+	}
+
+	namespace := ref.Namespace
+	if len(namespace) == 0 {
+		namespace = r.ns
+	}
+
+	secret, err := r.get(ctx, getter.GetOptions{
+		GVK:       corev1.SchemeGroupVersion.WithKind("Secret"),
+		Namespace: namespace,
+		Name:      ref.Name,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret: %w", err)
+	}
+
+	data, _, err := unstructured.NestedStringMap(secret.Object, "data")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret: %w", err)
+	}
+	for k, v := range data {
+		val, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, fmt.Errorf("secret %s/%s, key %q: %w", namespace, ref.Name, k, err)
+		}
+		res.Set(k, string(val))
+	}
+
+	return res, nil
+}
+
+// call makes a single request, it fails if the status code is not expected:
+// permanently unless the status is worth retrying.
+func call(ctx context.Context, cli *http.Client, method, uri string, headers http.Header, body string, expected []int) (int, []byte, error) {
+	var rd io.Reader
+	if len(body) > 0 {
+		rd = strings.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, uri, rd)
+	if err != nil {
+		return 0, nil, steps.Permanent(err)
+	}
+	req.Header = headers.Clone()
+
+	res, err := cli.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer res.Body.Close()
+
+	dat, err := io.ReadAll(io.LimitReader(res.Body, maxBodyBytes))
+	if err != nil {
+		return res.StatusCode, nil, err
+	}
+
+	ok := res.StatusCode >= 200 && res.StatusCode < 300
+	if len(expected) > 0 {
+		ok = slices.Contains(expected, res.StatusCode)
+	}
+	if !ok {
+		msg := strings.TrimSpace(string(dat[:min(len(dat), maxErrorBytes)]))
+		err := fmt.Errorf("unexpected status %d: %s", res.StatusCode, msg)
+		if !transient(res.StatusCode) {
+			err = steps.Permanent(err)
+		}
+		return res.StatusCode, dat, err
+	}
+
+	return res.StatusCode, dat, nil
+}
+
+// transient tells if a request failed with the status code may succeed
+// later, i.e. a service still starting or throttling the requests.
+func transient(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout, http.StatusTooEarly, http.StatusTooManyRequests:
+		return true
+	}
+	return code >= 500
+}
+
+func newClient(insecure bool, timeout time.Duration) *http.Client {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	if insecure {
+		tr.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	return &http.Client{Transport: tr, Timeout: timeout}
+}
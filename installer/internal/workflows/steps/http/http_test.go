@@ -0,0 +1,264 @@
+package steps
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+	"github.com/krateoplatformops/installer/pkg/cache"
+	"github.com/krateoplatformops/installer/pkg/dynamic/getter"
+	"github.com/krateoplatformops/installer/pkg/workflows/steps"
+	"github.com/krateoplatformops/provider-runtime/pkg/logging"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// fakeHandler returns a handler whose get returns the Secret
+// "snowplow-token" holding an Authorization header.
+func fakeHandler(t *testing.T) (*httpStepHandler, *cache.Cache[string, string]) {
+	t.Helper()
+
+	env := cache.New[string, string]()
+	env.Set("KRATEO_NAMESPACE", "krateo-system")
+
+	hdl := HTTPHandler(nil, env, logging.NewNopLogger()).(*httpStepHandler)
+	hdl.Namespace("demo")
+	hdl.get = func(_ context.Context, opts getter.GetOptions) (*unstructured.Unstructured, error) {
+		if opts.Name != "snowplow-token" || opts.Namespace != "demo" {
+			return nil, apierrors.NewNotFound(schema.GroupResource{Resource: "secrets"}, opts.Name)
+		}
+		return &unstructured.Unstructured{Object: map[string]any{
+			"data": map[string]any{
+				"Authorization": base64.StdEncoding.EncodeToString([]byte("Bearer s3cr3t")),
+			},
+		}}, nil
+	}
+
+	return hdl, env
+}
+
+func raw(s string, args ...any) *runtime.RawExtension {
+	return &runtime.RawExtension{Raw: []byte(fmt.Sprintf(s, args...))}
+}
+
+func TestHTTPCall(t *testing.T) {
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		got = fmt.Sprintf("%s %s %s %s %s", req.Method, req.URL.Path,
+			req.Header.Get("Authorization"), req.Header.Get("Content-Type"), body)
+
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"id": 42, "endpoint": {"url": "http://snowplow.krateo-system:8081"}}`)
+	}))
+	defer srv.Close()
+
+	hdl, env := fakeHandler(t)
+
+	res, err := hdl.Handle(context.Background(), "register", raw(`{
+		"method": "post",
+		"url": "%s/api/$KRATEO_NAMESPACE/endpoints",
+		"headers": {"Content-Type": "application/json", "Authorization": "Basic none"},
+		"headersFrom": {"name": "snowplow-token"},
+		"body": "{\"namespace\": \"$KRATEO_NAMESPACE\"}",
+		"expectedStatus": [200, 201],
+		"outputs": [
+			{"name": "ENDPOINT_ID", "selector": ".id"},
+			{"name": "ENDPOINT_URL", "selector": ".endpoint.url"}
+		]
+	}`, srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := `POST /api/krateo-system/endpoints Bearer s3cr3t application/json {"namespace": "krateo-system"}`
+	if got != exp {
+		t.Fatalf("got request %s, expected %s", got, exp)
+	}
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("unexpected status code: %d", res.StatusCode)
+	}
+
+	if v, _ := env.Get("ENDPOINT_ID"); v != "42" {
+		t.Fatalf("unexpected ENDPOINT_ID: %s", v)
+	}
+	if v, _ := env.Get("ENDPOINT_URL"); v != "http://snowplow.krateo-system:8081" {
+		t.Fatalf("unexpected ENDPOINT_URL: %s", v)
+	}
+
+	status := &v1alpha1.WorkflowStatus{}
+	res.PopulateStatus(status, "register")
+	if len(status.VarList) != 2 || status.VarList[0].Step != "register" {
+		t.Fatalf("unexpected var list: %+v", status.VarList)
+	}
+}
+
+func TestHTTPRetries(t *testing.T) {
+	calls := atomic.Int32{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch n := calls.Add(1); {
+		case req.URL.Path == "/missing":
+			http.Error(w, "no such endpoint", http.StatusBadRequest)
+		case n%3 != 0:
+			http.Error(w, "starting", http.StatusServiceUnavailable)
+		default:
+			fmt.Fprint(w, `{"status": "ok"}`)
+		}
+	}))
+	defer srv.Close()
+
+	hdl, _ := fakeHandler(t)
+
+	res, err := hdl.Handle(context.Background(), "health", raw(`{"url": "%s/health", "retryInterval": "1ms"}`, srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK || calls.Load() != 3 {
+		t.Fatalf("expected status 200 after 3 calls, got %d after %d", res.StatusCode, calls.Load())
+	}
+
+	calls.Store(0)
+	_, err = hdl.Handle(context.Background(), "health", raw(`{"url": "%s/health", "retries": 1, "retryInterval": "1ms"}`, srv.URL))
+	if err == nil || !strings.Contains(err.Error(), "unexpected status 503: starting") {
+		t.Fatalf("expected the status to be reported, got: %v", err)
+	}
+	if steps.IsPermanent(err) || calls.Load() != 2 {
+		t.Fatalf("expected a transient error after 2 calls, got %d calls: %v", calls.Load(), err)
+	}
+
+	calls.Store(0)
+	_, err = hdl.Handle(context.Background(), "health", raw(`{"url": "%s/missing", "retryInterval": "1ms"}`, srv.URL))
+	if !steps.IsPermanent(err) || calls.Load() != 1 {
+		t.Fatalf("expected a permanent error after 1 call, got %d calls: %v", calls.Load(), err)
+	}
+}
+
+func TestHTTPJSONBody(t *testing.T) {
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		got = string(body)
+	}))
+	defer srv.Close()
+
+	hdl, env := fakeHandler(t)
+	env.Set("NOTE", "say \"hi\"\n")
+
+	tests := []struct {
+		contentType string
+		exp         string
+	}{
+		{"application/json", `{"note": "say \"hi\"\n"}`},
+		{"text/plain", "{\"note\": \"say \"hi\"\n\"}"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.contentType, func(t *testing.T) {
+			_, err := hdl.Handle(context.Background(), "notify", raw(`{
+				"method": "post",
+				"url": "%s/notes",
+				"headers": {"Content-Type": "%s"},
+				"body": "{\"note\": \"$NOTE\"}"
+			}`, srv.URL, tc.contentType))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.exp {
+				t.Fatalf("got body %q, expected %q", got, tc.exp)
+			}
+		})
+	}
+}
+
+func TestHTTPPlanAndDelete(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		t.Errorf("unexpected %s %s", req.Method, req.URL.Path)
+	}))
+	defer srv.Close()
+
+	hdl, _ := fakeHandler(t)
+	hdl.DryRun(true)
+
+	res, err := hdl.Handle(context.Background(), "register", raw(`{"method": "PUT", "url": "%s/api"}`, srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Plan == nil || res.Plan.Action != "call" || res.Plan.Resource != "PUT "+srv.URL+"/api" {
+		t.Fatalf("unexpected plan: %+v", res.Plan)
+	}
+
+	hdl.DryRun(false)
+	hdl.Op(steps.Delete)
+	if res, err := hdl.Handle(context.Background(), "register", raw(`{"url": "%s/api"}`, srv.URL)); res != nil || err != nil {
+		t.Fatalf("expected nothing on delete, got: %v, %v", res, err)
+	}
+}
+
+func TestHTTPInvalid(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, `[1, 2, 3]`)
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name      string
+		raw       string
+		err       string
+		permanent bool
+	}{
+		{
+			name:      "no url",
+			raw:       `{}`,
+			err:       `url must be an http(s) address, got ""`,
+			permanent: true,
+		},
+		{
+			name:      "not http",
+			raw:       `{"url": "ftp://example.com"}`,
+			err:       `url must be an http(s) address`,
+			permanent: true,
+		},
+		{
+			name:      "invalid selector",
+			raw:       fmt.Sprintf(`{"url": "%s", "outputs": [{"name": "X", "selector": ".["}]}`, srv.URL),
+			err:       "output X: invalid selector",
+			permanent: true,
+		},
+		{
+			name:      "invalid method",
+			raw:       fmt.Sprintf(`{"method": "GET ME", "url": "%s"}`, srv.URL),
+			err:       "invalid method",
+			permanent: true,
+		},
+		{
+			name: "not an object",
+			raw:  fmt.Sprintf(`{"url": "%s", "outputs": [{"name": "X", "selector": ".[0]"}]}`, srv.URL),
+			err:  "the response is not a JSON object",
+		},
+		{
+			name: "missing secret",
+			raw:  fmt.Sprintf(`{"url": "%s", "headersFrom": {"name": "missing"}}`, srv.URL),
+			err:  "failed to get secret",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			hdl, _ := fakeHandler(t)
+
+			_, err := hdl.Handle(context.Background(), "x", &runtime.RawExtension{Raw: []byte(tc.raw)})
+			if err == nil || !strings.Contains(err.Error(), tc.err) || steps.IsPermanent(err) != tc.permanent {
+				t.Fatalf("expected an error containing %q (permanent: %v), got: %v", tc.err, tc.permanent, err)
+			}
+		})
+	}
+}
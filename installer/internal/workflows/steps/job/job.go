@@ -0,0 +1,381 @@
+package steps
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+	"github.com/krateoplatformops/installer/internal/expand"
+	"github.com/krateoplatformops/installer/pkg/cache"
+	"github.com/krateoplatformops/installer/pkg/locker"
+	"github.com/krateoplatformops/installer/pkg/workflows/steps"
+	"github.com/krateoplatformops/plumbing/ptr"
+	"github.com/krateoplatformops/provider-runtime/pkg/logging"
+	"github.com/twmb/murmur3"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	batchclient "k8s.io/client-go/kubernetes/typed/batch/v1"
+)
+
+// AnnotationDigest records on the Job the digest of the step that created it.
+const AnnotationDigest = "krateo.io/job-digest"
+
+const (
+	defaultInterval = 5 * time.Second
+	defaultTimeout  = 10 * time.Minute
+	defaultLogLines = 20
+	// maxLogBytes bounds the logs recorded in the status.
+	maxLogBytes = 4096
+)
+
+var (
+	_ steps.Handler[*steps.JobResult] = (*jobStepHandler)(nil)
+	_ steps.Planner                   = (*jobStepHandler)(nil)
+)
+
+func init() {
+	steps.Register(v1alpha1.TypeJob, func(opts steps.HandlerOptions) steps.Handler[steps.Result] {
+		return steps.Adapt(JobHandler(opts.Clientset, opts.Locker, opts.Env, opts.Log))
+	})
+}
+
+// JobHandler returns the handler of the job steps, lock can be nil.
+func JobHandler(kube kubernetes.Interface, lock *locker.Locker, env *cache.Cache[string, string], logr logging.Logger) steps.Handler[*steps.JobResult] {
+	return &jobStepHandler{
+		kube: kube, lock: lock, env: env,
+		// the references to other names are kept as they are,
+		// i.e. the shell variables of the container command
+		subst: steps.SubstJSON(env),
+		logr:  logr,
+	}
+}
+
+type jobStepHandler struct {
+	kube  kubernetes.Interface
+	lock  *locker.Locker
+	env   *cache.Cache[string, string]
+	ns    string
+	op    steps.Op
+	plan  bool
+	subst func(k string) (string, bool)
+	logr  logging.Logger
+}
+
+func (r *jobStepHandler) Namespace(ns string) {
+	r.ns = ns
+}
+
+func (r *jobStepHandler) Op(op steps.Op) {
+	r.op = op
+}
+
+func (r *jobStepHandler) DryRun(on bool) {
+	r.plan = on
+}
+
+func (r *jobStepHandler) Handle(ctx context.Context, id string, ext *runtime.RawExtension) (*steps.JobResult, error) {
+	if r.kube == nil {
+		return nil, fmt.Errorf("kubernetes client cannot be nil")
+	}
+
+	spec, err := r.toJobSpec(ext)
+	if err != nil {
+		return nil, err
+	}
+
+	job := r.toJob(spec)
+	result := &steps.JobResult{
+		Name:      job.Name,
+		Namespace: job.Namespace,
+	}
+	key := locker.ObjectKey(batchv1.SchemeGroupVersion.WithKind("Job"), job.Namespace, job.Name)
+	jobs := r.kube.BatchV1().Jobs(job.Namespace)
+
+	if r.op == steps.Delete {
+		result.Operation = "delete"
+
+		if err := r.lock.Acquire(ctx, key); locker.IsConflict(err) {
+			r.logr.Info(fmt.Sprintf("WARN: not deleting, %s", err.Error()))
+			return result, nil
+		} else if err != nil {
+			return result, err
+		}
+
+		if err := deleteJob(ctx, jobs, job.Name); err != nil {
+			return result, err
+		}
+		return result, r.lock.Release(ctx, key)
+	}
+
+	cur, err := jobs.Get(ctx, job.Name, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return result, err
+	}
+	if err != nil {
+		cur = nil
+	}
+
+	if r.plan {
+		result.Operation = "plan"
+		result.Plan = planJob(job, cur)
+		return result, nil
+	}
+
+	result.Operation = "run"
+	if err := r.lock.Acquire(ctx, key); err != nil {
+		return result, err
+	}
+
+	cur, err = r.start(ctx, jobs, job, cur)
+	if err != nil {
+		return result, err
+	}
+
+	interval, timeout := defaultInterval, defaultTimeout
+	if spec.Interval != nil && spec.Interval.Duration > 0 {
+		interval = spec.Interval.Duration
+	}
+	if spec.Timeout != nil && spec.Timeout.Duration > 0 {
+		timeout = spec.Timeout.Duration
+	}
+
+	var done *batchv1.JobCondition
+	err = wait.PollUntilContextTimeout(ctx, interval, timeout, true, func(ctx context.Context) (bool, error) {
+		if done = finished(cur); done != nil {
+			return true, nil
+		}
+		cur, err = jobs.Get(ctx, job.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		done = finished(cur)
+		return done != nil, nil
+	})
+	if err != nil {
+		// the Job keeps running, the next run waits for it again
+		if errors.Is(err, context.DeadlineExceeded) {
+			return result, fmt.Errorf("job %s did not complete within %s: %w", job.Name, timeout, err)
+		}
+		return result, err
+	}
+
+	result.Logs = r.logsOf(ctx, cur, spec)
+
+	if done.Type == batchv1.JobFailed {
+		if spec.CleanupPolicy == v1alpha1.JobCleanupDelete {
+			if err := deleteJob(ctx, jobs, job.Name); err != nil {
+				r.logr.Info(fmt.Sprintf("WARN: failed to delete job %s: %s", job.Name, err.Error()))
+			}
+		}
+		msg := fmt.Sprintf("job %s failed: %s", job.Name, done.Message)
+		if len(result.Logs) > 0 {
+			msg = fmt.Sprintf("%s, logs:\n%s", msg, result.Logs)
+		}
+		return result, steps.Permanent(errors.New(msg))
+	}
+
+	result.CompletionTime = cur.Status.CompletionTime
+	if spec.CleanupPolicy == v1alpha1.JobCleanupKeep {
+		result.Kept = true
+	} else if err := deleteJob(ctx, jobs, job.Name); err != nil {
+		return result, err
+	}
+
+	if len(spec.LogsVar) > 0 {
+		r.env.Set(spec.LogsVar, result.Logs)
+		result.Outputs = []steps.Output{{Data: v1alpha1.Data{Name: spec.LogsVar, Value: result.Logs}}}
+	}
+
+	r.logr.Debug(fmt.Sprintf("[job:%s]: job %s succeeded", id, job.Name))
+
+	return result, nil
+}
+
+// start creates the Job, unless cur is the Job created for the same spec
+// by a previous run and it didn't fail. A Job created for another spec,
+// or failed, is replaced.
+func (r *jobStepHandler) start(ctx context.Context, jobs batchclient.JobInterface, job, cur *batchv1.Job) (*batchv1.Job, error) {
+	if cur != nil {
+		digest, ok := cur.Annotations[AnnotationDigest]
+		if !ok {
+			return nil, steps.Permanent(fmt.Errorf("job %s already exists and was not created by a job step", job.Name))
+		}
+
+		cond := finished(cur)
+		if digest == job.Annotations[AnnotationDigest] && (cond == nil || cond.Type == batchv1.JobComplete) {
+			return cur, nil
+		}
+
+		if err := deleteJob(ctx, jobs, job.Name); err != nil {
+			return nil, err
+		}
+		// the name is available once the Job is gone
+		err := wait.PollUntilContextTimeout(ctx, time.Second, time.Minute, true, func(ctx context.Context) (bool, error) {
+			_, err := jobs.Get(ctx, job.Name, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				return true, nil
+			}
+			return false, err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("waiting for the previous job %s to be deleted: %w", job.Name, err)
+		}
+	}
+
+	return jobs.Create(ctx, job, metav1.CreateOptions{})
+}
+
+// logsOf returns the tail of the logs of the last pod of the Job.
+// Logs are best effort: the reason they are not available is returned instead.
+func (r *jobStepHandler) logsOf(ctx context.Context, job *batchv1.Job, spec *v1alpha1.JobSpec) string {
+	sel := labels.SelectorFromSet(labels.Set{"job-name": job.Name})
+	if job.Spec.Selector != nil {
+		if got, err := metav1.LabelSelectorAsSelector(job.Spec.Selector); err == nil {
+			sel = got
+		}
+	}
+
+	pods, err := r.kube.CoreV1().Pods(job.Namespace).List(ctx, metav1.ListOptions{LabelSelector: sel.String()})
+	if err != nil {
+		return fmt.Sprintf("logs not available: %s", err.Error())
+	}
+	if len(pods.Items) == 0 {
+		return ""
+	}
+
+	last := pods.Items[0]
+	for _, x := range pods.Items[1:] {
+		if last.CreationTimestamp.Before(&x.CreationTimestamp) {
+			last = x
+		}
+	}
+
+	opts := &corev1.PodLogOptions{
+		TailLines:  ptr.To(ptr.Deref(spec.LogLines, defaultLogLines)),
+		LimitBytes: ptr.To(int64(maxLogBytes)),
+	}
+	if len(spec.Template.Spec.Containers) > 0 {
+		opts.Container = spec.Template.Spec.Containers[0].Name
+	}
+
+	rc, err := r.kube.CoreV1().Pods(job.Namespace).GetLogs(last.Name, opts).Stream(ctx)
+	if err != nil {
+		return fmt.Sprintf("logs not available: %s", err.Error())
+	}
+	defer rc.Close()
+
+	dat, err := io.ReadAll(io.LimitReader(rc, maxLogBytes))
+	if err != nil {
+		return fmt.Sprintf("logs not available: %s", err.Error())
+	}
+
+	return strings.TrimRight(string(dat), "\n")
+}
+
+func (r *jobStepHandler) toJobSpec(ext *runtime.RawExtension) (*v1alpha1.JobSpec, error) {
+	raw := expand.Known(string(ext.Raw), r.subst)
+
+	spec := &v1alpha1.JobSpec{}
+	if err := json.Unmarshal([]byte(raw), spec); err != nil {
+		return nil, err
+	}
+
+	if len(spec.Metadata.Name) == 0 {
+		return nil, steps.Permanent(fmt.Errorf("metadata.name is required"))
+	}
+	if len(spec.Template.Spec.Containers) == 0 {
+		return nil, steps.Permanent(fmt.Errorf("template.spec.containers is required"))
+	}
+	if len(spec.Metadata.Namespace) == 0 {
+		spec.Metadata.Namespace = r.ns
+	}
+	if len(spec.CleanupPolicy) == 0 {
+		spec.CleanupPolicy = v1alpha1.JobCleanupDeleteOnSuccess
+	}
+
+	return spec, nil
+}
+
+func (r *jobStepHandler) toJob(spec *v1alpha1.JobSpec) *batchv1.Job {
+	tpl := *spec.Template.DeepCopy()
+	if len(tpl.Spec.RestartPolicy) == 0 {
+		tpl.Spec.RestartPolicy = corev1.RestartPolicyNever
+	}
+
+	job := &batchv1.Job{
+		TypeMeta: metav1.TypeMeta{APIVersion: "batch/v1", Kind: "Job"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      spec.Metadata.Name,
+			Namespace: spec.Metadata.Namespace,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: spec.BackoffLimit,
+			Template:     tpl,
+		},
+	}
+
+	dat, _ := json.Marshal(job.Spec)
+	hasher := murmur3.New64()
+	hasher.Write(dat)
+	job.Annotations = map[string]string{
+		AnnotationDigest: strconv.FormatUint(hasher.Sum64(), 16),
+	}
+
+	return job
+}
+
+// planJob reports whether the Job would be run.
+func planJob(job, cur *batchv1.Job) *v1alpha1.PlannedChange {
+	change := &v1alpha1.PlannedChange{
+		Action:   "create",
+		Resource: steps.ResourceName("Job", job.Namespace, job.Name),
+	}
+	if cur == nil {
+		return change
+	}
+
+	change.Action = "replace"
+	if cur.Annotations[AnnotationDigest] == job.Annotations[AnnotationDigest] {
+		if cond := finished(cur); cond == nil || cond.Type == batchv1.JobComplete {
+			change.Action = "none"
+		}
+	}
+	return change
+}
+
+// finished returns the condition telling the Job completed
+// or failed, nil if it is still running.
+func finished(job *batchv1.Job) *batchv1.JobCondition {
+	if job == nil {
+		return nil
+	}
+	for i, x := range job.Status.Conditions {
+		if (x.Type == batchv1.JobComplete || x.Type == batchv1.JobFailed) && x.Status == corev1.ConditionTrue {
+			return &job.Status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// deleteJob deletes the Job together with its pods.
+func deleteJob(ctx context.Context, jobs batchclient.JobInterface, name string) error {
+	err := jobs.Delete(ctx, name, metav1.DeleteOptions{
+		PropagationPolicy: ptr.To(metav1.DeletePropagationBackground),
+	})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,233 @@
+package steps
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/krateoplatformops/installer/pkg/cache"
+	"github.com/krateoplatformops/installer/pkg/workflows/steps"
+	"github.com/krateoplatformops/provider-runtime/pkg/logging"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const migrateJob = `{
+	"metadata": {"name": "migrate"},
+	"template": {"spec": {"containers": [{
+		"name": "main", "image": "dtzar/helm-kubectl",
+		"command": ["sh", "-c", "kubectl get ns $NAMESPACE && echo $HOME"]
+	}]}},
+	"interval": "10ms", "logsVar": "MIGRATION_LOGS"
+}`
+
+// newTestHandler returns a handler whose Jobs complete with the given
+// condition as soon as they are created, each with a pod.
+func newTestHandler(t *testing.T, outcome batchv1.JobConditionType) (*jobStepHandler, *fake.Clientset) {
+	t.Helper()
+
+	cs := fake.NewClientset()
+	cs.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		job := action.(k8stesting.CreateAction).GetObject().(*batchv1.Job)
+		job.Status.Conditions = []batchv1.JobCondition{{
+			Type: outcome, Status: corev1.ConditionTrue, Message: "BackoffLimitExceeded",
+		}}
+		if outcome == batchv1.JobComplete {
+			job.Status.CompletionTime = &metav1.Time{}
+		}
+
+		_, err := cs.Tracker().Get(corev1.SchemeGroupVersion.WithResource("pods"), job.Namespace, job.Name+"-x")
+		if apierrors.IsNotFound(err) {
+			err = cs.Tracker().Add(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Name: job.Name + "-x", Namespace: job.Namespace,
+				Labels: map[string]string{"job-name": job.Name},
+			}})
+		}
+		return false, nil, err
+	})
+
+	env := cache.New[string, string]()
+	env.Set("NAMESPACE", "krateo-system")
+
+	hdl := JobHandler(cs, nil, env, logging.NewNopLogger()).(*jobStepHandler)
+	hdl.Namespace("demo")
+	hdl.Op(steps.Create)
+
+	return hdl, cs
+}
+
+func TestJobSucceeded(t *testing.T) {
+	hdl, cs := newTestHandler(t, batchv1.JobComplete)
+
+	var created *batchv1.Job
+	cs.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		created = action.(k8stesting.CreateAction).GetObject().(*batchv1.Job).DeepCopy()
+		return false, nil, nil
+	})
+
+	res, err := hdl.Handle(context.Background(), "migrate", &runtime.RawExtension{Raw: []byte(migrateJob)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if created == nil {
+		t.Fatal("expected the job to be created")
+	}
+	if created.Namespace != "demo" || created.Spec.Template.Spec.RestartPolicy != corev1.RestartPolicyNever {
+		t.Fatalf("unexpected job: %+v", created)
+	}
+	if got := created.Spec.Template.Spec.Containers[0].Command[2]; got != "kubectl get ns krateo-system && echo $HOME" {
+		t.Fatalf("unexpected command: %s", got)
+	}
+
+	// the fake clientset returns "fake logs" for any pod
+	if res.Logs != "fake logs" {
+		t.Fatalf("got logs %q", res.Logs)
+	}
+	if v, _ := hdl.env.Get("MIGRATION_LOGS"); v != "fake logs" {
+		t.Fatalf("got var %q", v)
+	}
+	if len(res.Outputs) != 1 || res.Kept {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+
+	// deleted on success by default
+	_, err = cs.BatchV1().Jobs("demo").Get(context.Background(), "migrate", metav1.GetOptions{})
+	if !apierrors.IsNotFound(err) {
+		t.Fatalf("expected the job to be deleted, got: %v", err)
+	}
+}
+
+func TestJobFailed(t *testing.T) {
+	hdl, cs := newTestHandler(t, batchv1.JobFailed)
+
+	_, err := hdl.Handle(context.Background(), "migrate", &runtime.RawExtension{Raw: []byte(migrateJob)})
+	if err == nil || !steps.IsPermanent(err) {
+		t.Fatalf("expected a permanent error, got: %v", err)
+	}
+	if msg := err.Error(); !strings.Contains(msg, "job migrate failed: BackoffLimitExceeded") || !strings.Contains(msg, "fake logs") {
+		t.Fatalf("unexpected error: %s", msg)
+	}
+
+	// kept for inspection by default
+	if _, err := cs.BatchV1().Jobs("demo").Get(context.Background(), "migrate", metav1.GetOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := hdl.env.Get("MIGRATION_LOGS"); ok {
+		t.Fatal("the logs of a failed job must not be set")
+	}
+}
+
+func TestJobKeep(t *testing.T) {
+	hdl, cs := newTestHandler(t, batchv1.JobComplete)
+
+	raw := strings.Replace(migrateJob, `"interval"`, `"cleanupPolicy": "Keep", "interval"`, 1)
+	res, err := hdl.Handle(context.Background(), "migrate", &runtime.RawExtension{Raw: []byte(raw)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Kept {
+		t.Fatal("expected the job to be kept")
+	}
+
+	// the same spec reuses the completed job
+	creates := 0
+	cs.PrependReactor("create", "jobs", func(k8stesting.Action) (bool, runtime.Object, error) {
+		creates++
+		return false, nil, nil
+	})
+	if _, err := hdl.Handle(context.Background(), "migrate", &runtime.RawExtension{Raw: []byte(raw)}); err != nil {
+		t.Fatal(err)
+	}
+	if creates != 0 {
+		t.Fatal("expected the completed job to be reused")
+	}
+
+	// a different spec replaces it
+	hdl.env.Set("NAMESPACE", "other")
+	if _, err := hdl.Handle(context.Background(), "migrate", &runtime.RawExtension{Raw: []byte(raw)}); err != nil {
+		t.Fatal(err)
+	}
+	if creates != 1 {
+		t.Fatalf("expected the job to be replaced, got %d creates", creates)
+	}
+}
+
+func TestJobNotOwned(t *testing.T) {
+	hdl, cs := newTestHandler(t, batchv1.JobComplete)
+
+	_, err := cs.BatchV1().Jobs("demo").Create(context.Background(), &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "migrate", Namespace: "demo"},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = hdl.Handle(context.Background(), "migrate", &runtime.RawExtension{Raw: []byte(migrateJob)})
+	if err == nil || !strings.Contains(err.Error(), "was not created by a job step") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestJobPlan(t *testing.T) {
+	hdl, cs := newTestHandler(t, batchv1.JobComplete)
+	hdl.DryRun(true)
+
+	res, err := hdl.Handle(context.Background(), "migrate", &runtime.RawExtension{Raw: []byte(migrateJob)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Plan == nil || res.Plan.Action != "create" {
+		t.Fatalf("unexpected plan: %+v", res.Plan)
+	}
+
+	all, err := cs.BatchV1().Jobs("demo").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all.Items) > 0 {
+		t.Fatal("no job must be created in plan mode")
+	}
+}
+
+func TestJobDelete(t *testing.T) {
+	hdl, cs := newTestHandler(t, batchv1.JobComplete)
+
+	raw := strings.Replace(migrateJob, `"interval"`, `"cleanupPolicy": "Keep", "interval"`, 1)
+	if _, err := hdl.Handle(context.Background(), "migrate", &runtime.RawExtension{Raw: []byte(raw)}); err != nil {
+		t.Fatal(err)
+	}
+
+	hdl.Op(steps.Delete)
+	if _, err := hdl.Handle(context.Background(), "migrate", &runtime.RawExtension{Raw: []byte(raw)}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := cs.BatchV1().Jobs("demo").Get(context.Background(), "migrate", metav1.GetOptions{})
+	if !apierrors.IsNotFound(err) {
+		t.Fatalf("expected the job to be deleted, got: %v", err)
+	}
+
+	// already gone
+	if _, err := hdl.Handle(context.Background(), "migrate", &runtime.RawExtension{Raw: []byte(raw)}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJobInvalid(t *testing.T) {
+	hdl, _ := newTestHandler(t, batchv1.JobComplete)
+
+	for raw, exp := range map[string]string{
+		`{"template": {"spec": {"containers": [{"name": "x"}]}}}`: "metadata.name is required",
+		`{"metadata": {"name": "x"}}`:                             "template.spec.containers is required",
+	} {
+		_, err := hdl.Handle(context.Background(), "x", &runtime.RawExtension{Raw: []byte(raw)})
+		if err == nil || !strings.Contains(err.Error(), exp) || !steps.IsPermanent(err) {
+			t.Errorf("expected a permanent error containing %q, got: %v", exp, err)
+		}
+	}
+}
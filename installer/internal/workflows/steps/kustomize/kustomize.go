@@ -0,0 +1,144 @@
+package steps
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+
+	"github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+	"github.com/krateoplatformops/installer/internal/expand"
+	helmgetter "github.com/krateoplatformops/installer/internal/helm/getter"
+	"github.com/krateoplatformops/installer/pkg/cache"
+	"github.com/krateoplatformops/installer/pkg/dynamic/applier"
+	"github.com/krateoplatformops/installer/pkg/dynamic/deletor"
+	"github.com/krateoplatformops/installer/pkg/dynamic/getter"
+	"github.com/krateoplatformops/installer/pkg/locker"
+	"github.com/krateoplatformops/installer/pkg/workflows/steps"
+	"github.com/krateoplatformops/provider-runtime/pkg/logging"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/kustomize/api/krusty"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+var (
+	_ steps.Handler[*steps.ManifestResult] = (*kustomizeStepHandler)(nil)
+	_ steps.Planner                        = (*kustomizeStepHandler)(nil)
+)
+
+func init() {
+	steps.Register(v1alpha1.TypeKustomize, func(opts steps.HandlerOptions) steps.Handler[steps.Result] {
+		return steps.Adapt(KustomizeHandler(opts.Applier, opts.Deletor, opts.Getter, opts.Locker, opts.Env, opts.Inventory, opts.Log))
+	})
+}
+
+// KustomizeHandler returns the handler of the kustomize steps, lock can be nil.
+func KustomizeHandler(app *applier.Applier, del *deletor.Deletor, dyn *getter.Getter, lock *locker.Locker, env *cache.Cache[string, string], inv *cache.Cache[string, []v1alpha1.ObjectMeta], logr logging.Logger) steps.Handler[*steps.ManifestResult] {
+	return &kustomizeStepHandler{
+		objs: steps.Objects{
+			Apply: app.ApplyObject, Get: dyn.Get, Delete: del.Delete,
+			Reset: func() { app.Reset(); dyn.Reset() },
+			Lock:  lock, Log: logr,
+		},
+		inv:   inv,
+		fetch: helmgetter.Fetch,
+		subst: steps.Subst(env),
+		logr:  logr,
+	}
+}
+
+type kustomizeStepHandler struct {
+	objs  steps.Objects
+	inv   *cache.Cache[string, []v1alpha1.ObjectMeta]
+	fetch func(opts helmgetter.GetOptions) ([]byte, error)
+	ns    string
+	op    steps.Op
+	plan  bool
+	subst func(k string) (string, bool)
+	logr  logging.Logger
+}
+
+func (r *kustomizeStepHandler) Namespace(ns string) {
+	r.ns = ns
+}
+
+func (r *kustomizeStepHandler) Op(op steps.Op) {
+	r.op = op
+}
+
+func (r *kustomizeStepHandler) DryRun(on bool) {
+	r.plan = on
+}
+
+// Handle builds the kustomization and applies its objects, Namespaces
+// and CRDs first, or deletes them in the reverse order. On delete the
+// objects recorded by the last run are deleted without building again,
+// unless the step has no record, i.e. for the onDelete steps.
+func (r *kustomizeStepHandler) Handle(ctx context.Context, id string, ext *runtime.RawExtension) (*steps.ManifestResult, error) {
+	if r.op == steps.Delete {
+		if all, ok := r.inv.Get(id); ok {
+			return &steps.ManifestResult{Operation: "delete"}, r.objs.DeleteRecorded(ctx, all)
+		}
+	}
+
+	spec := v1alpha1.KustomizeSpec{}
+	if err := json.Unmarshal(ext.Raw, &spec); err != nil {
+		return nil, err
+	}
+
+	fs, src, err := r.load(ctx, &spec)
+	if err != nil {
+		return nil, err
+	}
+
+	dat, err := build(fs, spec.Path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", src, err)
+	}
+
+	all, err := steps.DecodeObjects([]byte(expand.Known(string(dat), r.subst)), r.ns)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", src, err)
+	}
+
+	result := &steps.ManifestResult{}
+
+	if r.op == steps.Delete {
+		result.Operation = "delete"
+		return result, r.objs.DeleteAll(ctx, all)
+	}
+
+	if r.plan {
+		result.Operation = "plan"
+		result.Plan, err = r.objs.Plan(ctx, src, all)
+		return result, err
+	}
+
+	result.Operation = "apply"
+	result.Objects, err = r.objs.ApplyAll(ctx, all)
+	if err != nil {
+		return result, err
+	}
+
+	r.logr.Debug(fmt.Sprintf("[kustomize:%s]: applied %d objects from %s", id, len(result.Objects), src))
+
+	return result, nil
+}
+
+// build runs kustomize on the directory dir of fs. Plugins, helm charts
+// and files outside the kustomization are not allowed.
+func build(fs filesys.FileSystem, dir string) ([]byte, error) {
+	dir = path.Join("/", dir)
+	if !fs.Exists(path.Join(dir, "kustomization.yaml")) &&
+		!fs.Exists(path.Join(dir, "kustomization.yml")) &&
+		!fs.Exists(path.Join(dir, "Kustomization")) {
+		return nil, steps.Permanent(fmt.Errorf("no kustomization found in %s", dir))
+	}
+
+	res, err := krusty.MakeKustomizer(krusty.MakeDefaultOptions()).Run(fs, dir)
+	if err != nil {
+		return nil, steps.Permanent(err)
+	}
+
+	return res.AsYaml()
+}
@@ -0,0 +1,329 @@
+package steps
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+	helmgetter "github.com/krateoplatformops/installer/internal/helm/getter"
+	"github.com/krateoplatformops/installer/pkg/cache"
+	"github.com/krateoplatformops/installer/pkg/dynamic/applier"
+	"github.com/krateoplatformops/installer/pkg/dynamic/deletor"
+	"github.com/krateoplatformops/installer/pkg/dynamic/getter"
+	"github.com/krateoplatformops/installer/pkg/workflows/steps"
+	rtv1 "github.com/krateoplatformops/provider-runtime/apis/common/v1"
+	"github.com/krateoplatformops/provider-runtime/pkg/logging"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var overlay = map[string]string{
+	"base/kustomization.yaml": "resources:\n- deployment.yaml\n",
+	"base/deployment.yaml": `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: api
+spec:
+  template:
+    spec:
+      containers:
+      - name: api
+        image: ghcr.io/krateoplatformops/api:$VERSION
+`,
+	"prod/kustomization.yaml": `namespace: krateo-system
+namePrefix: prod-
+labels:
+- pairs:
+    app.kubernetes.io/part-of: krateo
+resources:
+- ../base
+- namespace.yaml
+`,
+	"prod/namespace.yaml": "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: krateo-system\n",
+}
+
+// fakeHandler returns a handler recording the applied and deleted
+// objects, existing are the objects returned by get, by kind and name.
+func fakeHandler(t *testing.T, existing map[string]*unstructured.Unstructured) (*kustomizeStepHandler, *[]string) {
+	t.Helper()
+
+	env := cache.New[string, string]()
+	env.Set("VERSION", "1.2.0")
+
+	calls := []string{}
+	hdl := KustomizeHandler(nil, nil, nil, nil, env, cache.New[string, []v1alpha1.ObjectMeta](), logging.NewNopLogger()).(*kustomizeStepHandler)
+	hdl.Namespace("demo")
+	hdl.objs.Apply = func(_ context.Context, content map[string]any, opts applier.ApplyOptions) (*unstructured.Unstructured, error) {
+		if !opts.DryRun {
+			calls = append(calls, "apply "+steps.ResourceName(opts.GVK.Kind, opts.Namespace, opts.Name))
+		}
+		return &unstructured.Unstructured{Object: content}, nil
+	}
+	hdl.objs.Delete = func(_ context.Context, opts deletor.DeleteOptions) error {
+		calls = append(calls, "delete "+steps.ResourceName(opts.GVK.Kind, opts.Namespace, opts.Name))
+		return nil
+	}
+	hdl.objs.Get = func(_ context.Context, opts getter.GetOptions) (*unstructured.Unstructured, error) {
+		if obj, ok := existing[opts.GVK.Kind+" "+opts.Name]; ok {
+			return obj, nil
+		}
+		return nil, apierrors.NewNotFound(schema.GroupResource{Resource: opts.GVK.Kind}, opts.Name)
+	}
+	hdl.fetch = func(opts helmgetter.GetOptions) ([]byte, error) {
+		return nil, fmt.Errorf("unexpected fetch of %s", opts.URI)
+	}
+
+	return hdl, &calls
+}
+
+func inline(t *testing.T, files map[string]string, path string) *runtime.RawExtension {
+	t.Helper()
+
+	raw, err := json.Marshal(v1alpha1.KustomizeSpec{Files: files, Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &runtime.RawExtension{Raw: raw}
+}
+
+func tarball(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+
+	buf := bytes.Buffer{}
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	for name, content := range files {
+		err := tw.WriteHeader(&tar.Header{
+			Name: name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestKustomizeApply(t *testing.T) {
+	hdl, calls := fakeHandler(t, nil)
+
+	var deploy map[string]any
+	apply := hdl.objs.Apply
+	hdl.objs.Apply = func(ctx context.Context, content map[string]any, opts applier.ApplyOptions) (*unstructured.Unstructured, error) {
+		if opts.GVK.Kind == "Deployment" {
+			deploy = content
+		}
+		return apply(ctx, content, opts)
+	}
+
+	res, err := hdl.Handle(context.Background(), "api", inline(t, overlay, "prod"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []string{
+		"apply Namespace demo/krateo-system",
+		"apply Deployment krateo-system/prod-api",
+	}
+	if strings.Join(*calls, "\n") != strings.Join(exp, "\n") {
+		t.Fatalf("unexpected calls:\n%s", strings.Join(*calls, "\n"))
+	}
+
+	labels, _, _ := unstructured.NestedStringMap(deploy, "metadata", "labels")
+	if labels["app.kubernetes.io/part-of"] != "krateo" {
+		t.Fatalf("expected the labels of the overlay, got: %v", labels)
+	}
+	containers, _, _ := unstructured.NestedSlice(deploy, "spec", "template", "spec", "containers")
+	if got := fmt.Sprint(containers); !strings.Contains(got, "api:1.2.0") {
+		t.Fatalf("expected the variable to be expanded, got: %s", got)
+	}
+
+	status := &v1alpha1.WorkflowStatus{}
+	res.PopulateStatus(status, "api")
+	if len(status.ObjectList) != 2 || status.ObjectList[1].Metadata.Name != "prod-api" || status.ObjectList[1].Step != "api" {
+		t.Fatalf("unexpected object list: %+v", status.ObjectList)
+	}
+}
+
+func TestKustomizeDelete(t *testing.T) {
+	hdl, calls := fakeHandler(t, nil)
+	hdl.Op(steps.Delete)
+
+	if _, err := hdl.Handle(context.Background(), "api", inline(t, overlay, "prod")); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := "delete Deployment krateo-system/prod-api,delete Namespace demo/krateo-system"
+	if got := strings.Join(*calls, ","); got != exp {
+		t.Fatalf("got %s, expected %s", got, exp)
+	}
+}
+
+func TestKustomizeDeleteRecorded(t *testing.T) {
+	hdl, calls := fakeHandler(t, nil)
+	hdl.Op(steps.Delete)
+	hdl.inv.Set("api", []v1alpha1.ObjectMeta{
+		{APIVersion: "v1", Kind: "Namespace", Metadata: rtv1.Reference{Name: "krateo-system"}},
+		{APIVersion: "apps/v1", Kind: "Deployment", Metadata: rtv1.Reference{Name: "prod-api", Namespace: "krateo-system"}},
+	})
+
+	// the tarball is gone, the recorded objects are deleted anyway
+	ext := &runtime.RawExtension{Raw: []byte(`{"url": "https://example.com/gone.tgz"}`)}
+	if _, err := hdl.Handle(context.Background(), "api", ext); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := "delete Deployment krateo-system/prod-api,delete Namespace krateo-system"
+	if got := strings.Join(*calls, ","); got != exp {
+		t.Fatalf("got %s, expected %s", got, exp)
+	}
+}
+
+func TestKustomizePlan(t *testing.T) {
+	ns := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "v1", "kind": "Namespace",
+		"metadata": map[string]any{"name": "krateo-system"},
+	}}
+
+	hdl, calls := fakeHandler(t, map[string]*unstructured.Unstructured{
+		"Namespace krateo-system": ns,
+	})
+	hdl.DryRun(true)
+
+	res, err := hdl.Handle(context.Background(), "api", inline(t, overlay, "prod"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*calls) > 0 {
+		t.Fatalf("nothing must be applied in plan mode, got: %v", *calls)
+	}
+
+	exp := "~ Namespace demo/krateo-system,+ Deployment krateo-system/prod-api"
+	if res.Plan == nil || res.Plan.Action != "apply" || strings.Join(res.Plan.Changes, ",") != exp {
+		t.Fatalf("unexpected plan: %+v", res.Plan)
+	}
+}
+
+func TestKustomizeSources(t *testing.T) {
+	flat := map[string]string{
+		"kustomization.yaml": "namePrefix: cm-\nresources:\n- deployment.yaml\n",
+		"deployment.yaml":    overlay["base/deployment.yaml"],
+	}
+	configMap := &unstructured.Unstructured{Object: map[string]any{
+		"data": map[string]any{
+			"kustomization.yaml": flat["kustomization.yaml"],
+			"deployment.yaml":    flat["deployment.yaml"],
+		},
+	}}
+	secret := &unstructured.Unstructured{Object: map[string]any{
+		"data": map[string]any{"password": base64.StdEncoding.EncodeToString([]byte("s3cr3t"))},
+	}}
+
+	hdl, calls := fakeHandler(t, map[string]*unstructured.Unstructured{
+		"ConfigMap api":   configMap,
+		"Secret registry": secret,
+	})
+	hdl.fetch = func(opts helmgetter.GetOptions) ([]byte, error) {
+		if opts.Username != "krateo" || opts.Password != "s3cr3t" || !opts.PassCredentialsAll {
+			return nil, fmt.Errorf("unexpected credentials: %+v", opts)
+		}
+		files := map[string]string{}
+		for k, v := range overlay {
+			files["api-1.2.0/"+k] = v
+		}
+		return tarball(t, files), nil
+	}
+
+	for _, raw := range []string{
+		`{"configMapRef": {"name": "api"}}`,
+		`{"url": "https://example.com/api-1.2.0.tgz", "path": "api-1.2.0/base", "credentials": {"username": "krateo", "passwordRef": {"name": "registry", "namespace": "demo", "key": "password"}}}`,
+	} {
+		if _, err := hdl.Handle(context.Background(), "x", &runtime.RawExtension{Raw: []byte(raw)}); err != nil {
+			t.Fatalf("%s: %v", raw, err)
+		}
+	}
+
+	exp := "apply Deployment demo/cm-api,apply Deployment demo/api"
+	if got := strings.Join(*calls, ","); got != exp {
+		t.Fatalf("got %s, expected %s", got, exp)
+	}
+}
+
+func TestKustomizeEscape(t *testing.T) {
+	fs, err := untar(tarball(t, map[string]string{
+		"../../etc/kustomization.yaml": "resources: []\n",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !fs.Exists("/etc/kustomization.yaml") {
+		t.Fatal("expected the file to be written under the root")
+	}
+}
+
+func TestKustomizeInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		err  string
+	}{
+		{
+			name: "no source",
+			raw:  `{}`,
+			err:  "exactly one of files, configMapRef and url must be set",
+		},
+		{
+			name: "two sources",
+			raw:  `{"files": {"kustomization.yaml": ""}, "url": "https://example.com/api.tgz"}`,
+			err:  "exactly one of files, configMapRef and url must be set",
+		},
+		{
+			name: "not a tarball",
+			raw:  `{"url": "https://example.com/kustomization.yaml"}`,
+			err:  "url must be a .tgz or .tar.gz tarball",
+		},
+		{
+			name: "no kustomization",
+			raw:  `{"files": {"deployment.yaml": "kind: Deployment"}}`,
+			err:  "no kustomization found in /",
+		},
+		{
+			name: "missing resource",
+			raw:  `{"files": {"kustomization.yaml": "resources:\n- service.yaml\n"}}`,
+			err:  "service.yaml",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			hdl, calls := fakeHandler(t, nil)
+
+			_, err := hdl.Handle(context.Background(), "x", &runtime.RawExtension{Raw: []byte(tc.raw)})
+			if err == nil || !strings.Contains(err.Error(), tc.err) || !steps.IsPermanent(err) {
+				t.Fatalf("expected a permanent error containing %q, got: %v", tc.err, err)
+			}
+			if len(*calls) > 0 {
+				t.Fatal("nothing must be applied")
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package steps
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+	helmgetter "github.com/krateoplatformops/installer/internal/helm/getter"
+	"github.com/krateoplatformops/installer/internal/resolvers"
+	"github.com/krateoplatformops/installer/pkg/dynamic/getter"
+	"github.com/krateoplatformops/installer/pkg/workflows/steps"
+	"github.com/krateoplatformops/plumbing/ptr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+// maxArchiveSize is the maximum size of the files extracted from a tarball.
+const maxArchiveSize = 32 << 20
+
+// load returns an in-memory file system holding the files of the
+// kustomization and a description of their source.
+func (r *kustomizeStepHandler) load(ctx context.Context, spec *v1alpha1.KustomizeSpec) (filesys.FileSystem, string, error) {
+	sources := 0
+	for _, ok := range []bool{len(spec.Files) > 0, spec.ConfigMapRef != nil, len(spec.URL) > 0} {
+		if ok {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return nil, "", steps.Permanent(fmt.Errorf("exactly one of files, configMapRef and url must be set"))
+	}
+
+	switch {
+	case spec.ConfigMapRef != nil:
+		return r.fromConfigMap(ctx, spec.ConfigMapRef)
+	case len(spec.URL) > 0:
+		return r.fromURL(ctx, spec)
+	}
+
+	fs := filesys.MakeFsInMemory()
+	for k, v := range spec.Files {
+		if err := writeFile(fs, k, []byte(v)); err != nil {
+			return nil, "inline files", err
+		}
+	}
+
+	return fs, "inline files", nil
+}
+
+func (r *kustomizeStepHandler) fromConfigMap(ctx context.Context, ref *v1alpha1.KustomizeConfigMapRef) (filesys.FileSystem, string, error) {
+	namespace := ref.Namespace
+	if len(namespace) == 0 {
+		namespace = r.ns
+	}
+	src := fmt.Sprintf("ConfigMap %s/%s", namespace, ref.Name)
+
+	obj, err := r.objs.Get(ctx, getter.GetOptions{
+		GVK:       corev1.SchemeGroupVersion.WithKind("ConfigMap"),
+		Namespace: namespace,
+		Name:      ref.Name,
+	})
+	if err != nil {
+		return nil, src, err
+	}
+
+	data, _, err := unstructured.NestedStringMap(obj.Object, "data")
+	if err != nil {
+		return nil, src, err
+	}
+
+	fs := filesys.MakeFsInMemory()
+	for k, v := range data {
+		if err := writeFile(fs, k, []byte(v)); err != nil {
+			return nil, src, err
+		}
+	}
+
+	return fs, src, nil
+}
+
+func (r *kustomizeStepHandler) fromURL(ctx context.Context, spec *v1alpha1.KustomizeSpec) (filesys.FileSystem, string, error) {
+	src := spec.URL
+	if !strings.HasSuffix(src, ".tgz") && !strings.HasSuffix(src, ".tar.gz") {
+		return nil, src, steps.Permanent(fmt.Errorf("url must be a .tgz or .tar.gz tarball"))
+	}
+
+	opts := helmgetter.GetOptions{
+		URI:                   spec.URL,
+		InsecureSkipVerifyTLS: ptr.Deref(spec.InsecureSkipTLSVerify, false),
+	}
+	if spec.Credentials != nil {
+		ref := spec.Credentials.PasswordRef
+		if len(ref.Namespace) == 0 {
+			ref.Namespace = r.ns
+		}
+		secret, err := resolvers.GetSecret(ctx, r.objs.Get, ref)
+		if err != nil {
+			return nil, src, fmt.Errorf("failed to get secret: %w", err)
+		}
+		opts.Username = spec.Credentials.Username
+		opts.Password = secret
+		opts.PassCredentialsAll = true
+	}
+
+	dat, err := r.fetch(opts)
+	if err != nil {
+		return nil, src, err
+	}
+
+	fs, err := untar(dat)
+	return fs, src, err
+}
+
+// untar extracts the regular files of a gzipped tarball.
+func untar(dat []byte) (filesys.FileSystem, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(dat))
+	if err != nil {
+		return nil, steps.Permanent(err)
+	}
+	defer zr.Close()
+
+	fs := filesys.MakeFsInMemory()
+	tr := tar.NewReader(zr)
+	size := int64(0)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, steps.Permanent(err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		size += hdr.Size
+		if size > maxArchiveSize {
+			return nil, steps.Permanent(fmt.Errorf("the tarball exceeds %d bytes", maxArchiveSize))
+		}
+
+		buf, err := io.ReadAll(io.LimitReader(tr, hdr.Size))
+		if err != nil {
+			return nil, err
+		}
+		if err := writeFile(fs, hdr.Name, buf); err != nil {
+			return nil, err
+		}
+	}
+
+	return fs, nil
+}
+
+// writeFile writes a file, its path is relative to the root
+// whatever it is: files can't escape the file system.
+func writeFile(fs filesys.FileSystem, name string, dat []byte) error {
+	name = path.Join("/", name)
+	if err := fs.MkdirAll(path.Dir(name)); err != nil {
+		return err
+	}
+
+	return fs.WriteFile(name, dat)
+}
@@ -0,0 +1,201 @@
+package steps
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+	"github.com/krateoplatformops/installer/internal/expand"
+	helmgetter "github.com/krateoplatformops/installer/internal/helm/getter"
+	"github.com/krateoplatformops/installer/pkg/cache"
+	"github.com/krateoplatformops/installer/pkg/dynamic/applier"
+	"github.com/krateoplatformops/installer/pkg/dynamic/deletor"
+	"github.com/krateoplatformops/installer/pkg/dynamic/getter"
+	"github.com/krateoplatformops/installer/pkg/locker"
+	"github.com/krateoplatformops/installer/pkg/workflows/steps"
+	"github.com/krateoplatformops/plumbing/ptr"
+	"github.com/krateoplatformops/provider-runtime/pkg/logging"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+var (
+	_ steps.Handler[*steps.ManifestResult] = (*manifestStepHandler)(nil)
+	_ steps.Planner                        = (*manifestStepHandler)(nil)
+)
+
+func init() {
+	steps.Register(v1alpha1.TypeManifest, func(opts steps.HandlerOptions) steps.Handler[steps.Result] {
+		return steps.Adapt(ManifestHandler(opts.Applier, opts.Deletor, opts.Getter, opts.Locker, opts.Env, opts.Inventory, opts.Log))
+	})
+}
+
+// ManifestHandler returns the handler of the manifest steps, lock can be nil.
+func ManifestHandler(app *applier.Applier, del *deletor.Deletor, dyn *getter.Getter, lock *locker.Locker, env *cache.Cache[string, string], inv *cache.Cache[string, []v1alpha1.ObjectMeta], logr logging.Logger) steps.Handler[*steps.ManifestResult] {
+	return &manifestStepHandler{
+		objs: steps.Objects{
+			Apply: app.ApplyObject, Get: dyn.Get, Delete: del.Delete,
+			Reset: func() { app.Reset(); dyn.Reset() },
+			Lock:  lock, Log: logr,
+		},
+		inv:   inv,
+		fetch: helmgetter.Fetch,
+		subst: steps.Subst(env),
+		logr:  logr,
+	}
+}
+
+type manifestStepHandler struct {
+	objs  steps.Objects
+	inv   *cache.Cache[string, []v1alpha1.ObjectMeta]
+	fetch func(opts helmgetter.GetOptions) ([]byte, error)
+	ns    string
+	op    steps.Op
+	plan  bool
+	subst func(k string) (string, bool)
+	logr  logging.Logger
+}
+
+func (r *manifestStepHandler) Namespace(ns string) {
+	r.ns = ns
+}
+
+func (r *manifestStepHandler) Op(op steps.Op) {
+	r.op = op
+}
+
+func (r *manifestStepHandler) DryRun(on bool) {
+	r.plan = on
+}
+
+// Handle applies the objects of the manifest, Namespaces and CRDs first,
+// or deletes them in the reverse order. On delete the objects recorded
+// by the last run are deleted, the source is read only if the step has
+// no record, i.e. for the onDelete steps.
+func (r *manifestStepHandler) Handle(ctx context.Context, id string, ext *runtime.RawExtension) (*steps.ManifestResult, error) {
+	if r.op == steps.Delete {
+		if all, ok := r.inv.Get(id); ok {
+			return &steps.ManifestResult{Operation: "delete"}, r.objs.DeleteRecorded(ctx, all)
+		}
+	}
+
+	spec := v1alpha1.ManifestSpec{}
+	if err := json.Unmarshal(ext.Raw, &spec); err != nil {
+		return nil, err
+	}
+
+	dat, src, err := r.load(ctx, &spec)
+	if err != nil {
+		return nil, err
+	}
+
+	all, err := steps.DecodeObjects([]byte(expand.Known(string(dat), r.subst)), r.ns)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", src, err)
+	}
+
+	result := &steps.ManifestResult{}
+
+	if r.op == steps.Delete {
+		result.Operation = "delete"
+		return result, r.objs.DeleteAll(ctx, all)
+	}
+
+	if r.plan {
+		result.Operation = "plan"
+		result.Plan, err = r.objs.Plan(ctx, src, all)
+		return result, err
+	}
+
+	result.Operation = "apply"
+	result.Objects, err = r.objs.ApplyAll(ctx, all)
+	if err != nil {
+		return result, err
+	}
+
+	r.logr.Debug(fmt.Sprintf("[manifest:%s]: applied %d objects from %s", id, len(result.Objects), src))
+
+	return result, nil
+}
+
+// load returns the manifest and a description of its source.
+func (r *manifestStepHandler) load(ctx context.Context, spec *v1alpha1.ManifestSpec) ([]byte, string, error) {
+	sources := 0
+	for _, ok := range []bool{len(spec.Content) > 0, spec.ConfigMapRef != nil, spec.SecretRef != nil, len(spec.URL) > 0} {
+		if ok {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return nil, "", steps.Permanent(fmt.Errorf("exactly one of content, configMapRef, secretRef and url must be set"))
+	}
+
+	switch {
+	case spec.ConfigMapRef != nil:
+		return r.fromKeyRef(ctx, "ConfigMap", spec.ConfigMapRef)
+	case spec.SecretRef != nil:
+		return r.fromKeyRef(ctx, "Secret", spec.SecretRef)
+	case len(spec.URL) > 0:
+		opts := helmgetter.GetOptions{
+			URI:                   spec.URL,
+			InsecureSkipVerifyTLS: ptr.Deref(spec.InsecureSkipTLSVerify, false),
+		}
+		if spec.Credentials != nil {
+			ref := spec.Credentials.PasswordRef
+			pwd, _, err := r.fromKeyRef(ctx, "Secret", &v1alpha1.ManifestKeyRef{
+				Name: ref.Name, Namespace: ref.Namespace, Key: ref.Key,
+			})
+			if err != nil {
+				return nil, spec.URL, fmt.Errorf("failed to get secret: %w", err)
+			}
+			opts.Username = spec.Credentials.Username
+			opts.Password = string(pwd)
+			opts.PassCredentialsAll = true
+		}
+
+		dat, err := r.fetch(opts)
+		return dat, spec.URL, err
+	}
+
+	return []byte(spec.Content), "inline manifest", nil
+}
+
+// fromKeyRef returns the value of a ConfigMap or Secret key.
+func (r *manifestStepHandler) fromKeyRef(ctx context.Context, kind string, ref *v1alpha1.ManifestKeyRef) ([]byte, string, error) {
+	namespace := ref.Namespace
+	if len(namespace) == 0 {
+		namespace = r.ns
+	}
+	src := fmt.Sprintf("%s %s/%s[%s]", kind, namespace, ref.Name, ref.Key)
+
+	if len(ref.Name) == 0 || len(ref.Key) == 0 {
+		return nil, src, steps.Permanent(fmt.Errorf("name and key are required"))
+	}
+
+	obj, err := r.objs.Get(ctx, getter.GetOptions{
+		GVK:       corev1.SchemeGroupVersion.WithKind(kind),
+		Namespace: namespace,
+		Name:      ref.Name,
+	})
+	if err != nil {
+		return nil, src, err
+	}
+
+	val, ok, err := unstructured.NestedString(obj.Object, "data", ref.Key)
+	if err != nil {
+		return nil, src, err
+	}
+	if !ok {
+		return nil, src, fmt.Errorf("key %q not found in %s %s/%s", ref.Key, kind, namespace, ref.Name)
+	}
+
+	if kind != "Secret" {
+		return []byte(val), src, nil
+	}
+
+	dat, err := base64.StdEncoding.DecodeString(val)
+	return dat, src, err
+}
@@ -0,0 +1,318 @@
+package steps
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+	helmgetter "github.com/krateoplatformops/installer/internal/helm/getter"
+	"github.com/krateoplatformops/installer/pkg/cache"
+	"github.com/krateoplatformops/installer/pkg/dynamic/applier"
+	"github.com/krateoplatformops/installer/pkg/dynamic/deletor"
+	"github.com/krateoplatformops/installer/pkg/dynamic/getter"
+	"github.com/krateoplatformops/installer/pkg/workflows/steps"
+	rtv1 "github.com/krateoplatformops/provider-runtime/apis/common/v1"
+	"github.com/krateoplatformops/provider-runtime/pkg/logging"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const rbacManifest = `
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: krateo-installer
+subjects:
+- kind: ServiceAccount
+  name: krateo-installer
+roleRef:
+  kind: Role
+  name: krateo-installer
+  apiGroup: rbac.authorization.k8s.io
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: Role
+metadata:
+  name: krateo-installer
+rules:
+- apiGroups: ["apps"]
+  resources: ["deployments"]
+  verbs: ["get", "list", "$VERB"]
+---
+apiVersion: core.krateo.io/v1alpha1
+kind: CompositionDefinition
+metadata:
+  name: fireworksapp
+spec:
+  chart:
+    url: https://charts.krateo.io/fireworksapp-${VERSION}.tgz
+---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: $NAMESPACE
+`
+
+// fakeHandler returns a handler recording the applied and deleted
+// objects, existing are the objects returned by get, by kind and name.
+func fakeHandler(t *testing.T, existing map[string]*unstructured.Unstructured) (*manifestStepHandler, *[]string) {
+	t.Helper()
+
+	env := cache.New[string, string]()
+	env.Set("NAMESPACE", "krateo-system")
+	env.Set("VERB", "watch")
+
+	calls := []string{}
+	hdl := ManifestHandler(nil, nil, nil, nil, env, cache.New[string, []v1alpha1.ObjectMeta](), logging.NewNopLogger()).(*manifestStepHandler)
+	hdl.Namespace("demo")
+	hdl.objs.Apply = func(_ context.Context, content map[string]any, opts applier.ApplyOptions) (*unstructured.Unstructured, error) {
+		if !opts.DryRun {
+			calls = append(calls, "apply "+steps.ResourceName(opts.GVK.Kind, opts.Namespace, opts.Name))
+		}
+		return &unstructured.Unstructured{Object: content}, nil
+	}
+	hdl.objs.Delete = func(_ context.Context, opts deletor.DeleteOptions) error {
+		calls = append(calls, "delete "+steps.ResourceName(opts.GVK.Kind, opts.Namespace, opts.Name))
+		return nil
+	}
+	hdl.objs.Get = func(_ context.Context, opts getter.GetOptions) (*unstructured.Unstructured, error) {
+		if obj, ok := existing[opts.GVK.Kind+" "+opts.Name]; ok {
+			return obj, nil
+		}
+		return nil, apierrors.NewNotFound(schema.GroupResource{Resource: opts.GVK.Kind}, opts.Name)
+	}
+	hdl.fetch = func(opts helmgetter.GetOptions) ([]byte, error) {
+		return nil, fmt.Errorf("unexpected fetch of %s", opts.URI)
+	}
+
+	return hdl, &calls
+}
+
+func inline(content string) *runtime.RawExtension {
+	raw := fmt.Sprintf(`{"content": %q}`, content)
+	return &runtime.RawExtension{Raw: []byte(raw)}
+}
+
+func TestManifestApply(t *testing.T) {
+	hdl, calls := fakeHandler(t, nil)
+
+	var role map[string]any
+	apply := hdl.objs.Apply
+	hdl.objs.Apply = func(ctx context.Context, content map[string]any, opts applier.ApplyOptions) (*unstructured.Unstructured, error) {
+		if opts.GVK.Kind == "Role" {
+			role = content
+		}
+		return apply(ctx, content, opts)
+	}
+
+	res, err := hdl.Handle(context.Background(), "rbac", inline(rbacManifest))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []string{
+		"apply Namespace demo/krateo-system",
+		"apply Role demo/krateo-installer",
+		"apply RoleBinding demo/krateo-installer",
+		"apply CompositionDefinition demo/fireworksapp",
+	}
+	if strings.Join(*calls, "\n") != strings.Join(exp, "\n") {
+		t.Fatalf("unexpected calls:\n%s", strings.Join(*calls, "\n"))
+	}
+
+	verbs, _, _ := unstructured.NestedSlice(role, "rules")
+	if got := fmt.Sprint(verbs); !strings.Contains(got, "watch") {
+		t.Fatalf("expected the variable to be expanded, got: %s", got)
+	}
+
+	if len(res.Objects) != 4 || res.Objects[1].Kind != "Role" || res.Objects[1].APIVersion != "rbac.authorization.k8s.io/v1" {
+		t.Fatalf("unexpected objects: %+v", res.Objects)
+	}
+
+	status := &v1alpha1.WorkflowStatus{}
+	res.PopulateStatus(status, "rbac")
+	if len(status.ObjectList) != 4 || status.ObjectList[0].Step != "rbac" {
+		t.Fatalf("unexpected object list: %+v", status.ObjectList)
+	}
+}
+
+func TestManifestUnknownVars(t *testing.T) {
+	hdl, _ := fakeHandler(t, nil)
+
+	var url string
+	hdl.objs.Apply = func(_ context.Context, content map[string]any, opts applier.ApplyOptions) (*unstructured.Unstructured, error) {
+		if opts.GVK.Kind == "CompositionDefinition" {
+			url, _, _ = unstructured.NestedString(content, "spec", "chart", "url")
+		}
+		return &unstructured.Unstructured{Object: content}, nil
+	}
+
+	if _, err := hdl.Handle(context.Background(), "x", inline(rbacManifest)); err != nil {
+		t.Fatal(err)
+	}
+
+	if url != "https://charts.krateo.io/fireworksapp-${VERSION}.tgz" {
+		t.Fatalf("unknown variables must be kept, got: %s", url)
+	}
+}
+
+func TestManifestDelete(t *testing.T) {
+	hdl, calls := fakeHandler(t, nil)
+	hdl.Op(steps.Delete)
+
+	if _, err := hdl.Handle(context.Background(), "rbac", inline(rbacManifest)); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []string{
+		"delete RoleBinding demo/krateo-installer",
+		"delete Role demo/krateo-installer",
+		"delete Namespace demo/krateo-system",
+		"delete CompositionDefinition demo/fireworksapp",
+	}
+	if strings.Join(*calls, "\n") != strings.Join(exp, "\n") {
+		t.Fatalf("unexpected calls:\n%s", strings.Join(*calls, "\n"))
+	}
+}
+
+func TestManifestDeleteRecorded(t *testing.T) {
+	hdl, calls := fakeHandler(t, nil)
+	hdl.Op(steps.Delete)
+	hdl.inv.Set("rbac", []v1alpha1.ObjectMeta{
+		{APIVersion: "v1", Kind: "Namespace", Metadata: rtv1.Reference{Name: "krateo-system"}},
+		{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "Role", Metadata: rtv1.Reference{Name: "old-role", Namespace: "demo"}},
+	})
+
+	// the source is gone, the recorded objects are deleted anyway
+	ext := &runtime.RawExtension{Raw: []byte(`{"url": "https://example.com/gone.yaml"}`)}
+	if _, err := hdl.Handle(context.Background(), "rbac", ext); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := "delete Role demo/old-role,delete Namespace krateo-system"
+	if got := strings.Join(*calls, ","); got != exp {
+		t.Fatalf("got %s, expected %s", got, exp)
+	}
+}
+
+func TestManifestSources(t *testing.T) {
+	data := func(kind, val string) *unstructured.Unstructured {
+		if kind == "Secret" {
+			val = base64.StdEncoding.EncodeToString([]byte(val))
+		}
+		return &unstructured.Unstructured{Object: map[string]any{
+			"data": map[string]any{"manifest.yaml": val},
+		}}
+	}
+
+	hdl, calls := fakeHandler(t, map[string]*unstructured.Unstructured{
+		"ConfigMap webhooks": data("ConfigMap", "kind: Service\napiVersion: v1\nmetadata: {name: from-configmap}"),
+		"Secret webhooks":    data("Secret", "kind: Service\napiVersion: v1\nmetadata: {name: from-secret}"),
+		"Secret registry":    data("Secret", "s3cr3t"),
+	})
+	hdl.fetch = func(opts helmgetter.GetOptions) ([]byte, error) {
+		if opts.Username != "krateo" || opts.Password != "s3cr3t" || !opts.PassCredentialsAll {
+			return nil, fmt.Errorf("unexpected credentials: %+v", opts)
+		}
+		return []byte("kind: Service\napiVersion: v1\nmetadata: {name: from-url}"), nil
+	}
+
+	for _, raw := range []string{
+		`{"configMapRef": {"name": "webhooks", "key": "manifest.yaml"}}`,
+		`{"secretRef": {"name": "webhooks", "key": "manifest.yaml"}}`,
+		`{"url": "https://example.com/manifest.yaml", "credentials": {"username": "krateo", "passwordRef": {"name": "registry", "namespace": "demo", "key": "manifest.yaml"}}}`,
+	} {
+		if _, err := hdl.Handle(context.Background(), "x", &runtime.RawExtension{Raw: []byte(raw)}); err != nil {
+			t.Fatalf("%s: %v", raw, err)
+		}
+	}
+
+	exp := "apply Service demo/from-configmap,apply Service demo/from-secret,apply Service demo/from-url"
+	if got := strings.Join(*calls, ","); got != exp {
+		t.Fatalf("got %s, expected %s", got, exp)
+	}
+}
+
+func TestManifestPlan(t *testing.T) {
+	role := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "rbac.authorization.k8s.io/v1", "kind": "Role",
+		"metadata": map[string]any{"name": "krateo-installer", "namespace": "demo"},
+		"rules":    []any{},
+	}}
+	ns := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "v1", "kind": "Namespace",
+		"metadata": map[string]any{"name": "krateo-system", "namespace": "demo"},
+	}}
+
+	hdl, calls := fakeHandler(t, map[string]*unstructured.Unstructured{
+		"Role krateo-installer":   role,
+		"Namespace krateo-system": ns,
+	})
+	hdl.DryRun(true)
+
+	res, err := hdl.Handle(context.Background(), "rbac", inline(rbacManifest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*calls) > 0 {
+		t.Fatalf("nothing must be applied in plan mode, got: %v", *calls)
+	}
+
+	exp := "~ Role demo/krateo-installer,+ RoleBinding demo/krateo-installer,+ CompositionDefinition demo/fireworksapp"
+	if res.Plan == nil || res.Plan.Action != "apply" || strings.Join(res.Plan.Changes, ",") != exp {
+		t.Fatalf("unexpected plan: %+v", res.Plan)
+	}
+}
+
+func TestManifestInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		err  string
+	}{
+		{
+			name: "no source",
+			raw:  `{}`,
+			err:  "exactly one of content, configMapRef, secretRef and url must be set",
+		},
+		{
+			name: "two sources",
+			raw:  `{"content": "kind: Service", "url": "https://example.com"}`,
+			err:  "exactly one of content, configMapRef, secretRef and url must be set",
+		},
+		{
+			name: "missing name",
+			raw:  `{"content": "apiVersion: v1\nkind: Service"}`,
+			err:  "document 1: apiVersion, kind and metadata.name are required",
+		},
+		{
+			name: "empty",
+			raw:  `{"content": "---\n# nothing\n"}`,
+			err:  "no objects found",
+		},
+		{
+			name: "missing key",
+			raw:  `{"configMapRef": {"name": "webhooks"}}`,
+			err:  "name and key are required",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			hdl, calls := fakeHandler(t, nil)
+
+			_, err := hdl.Handle(context.Background(), "x", &runtime.RawExtension{Raw: []byte(tc.raw)})
+			if err == nil || !strings.Contains(err.Error(), tc.err) || !steps.IsPermanent(err) {
+				t.Fatalf("expected a permanent error containing %q, got: %v", tc.err, err)
+			}
+			if len(*calls) > 0 {
+				t.Fatal("nothing must be applied")
+			}
+		})
+	}
+}
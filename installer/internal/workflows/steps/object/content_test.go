@@ -0,0 +1,52 @@
+package steps
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPreviousContent(t *testing.T) {
+	applied := map[string]any{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata": map[string]any{
+			"name":      "demo",
+			"namespace": "default",
+			"labels":    map[string]any{"app": "demo"},
+		},
+		"data": map[string]any{
+			"key":   "new",
+			"added": "x",
+		},
+	}
+
+	prev := map[string]any{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata": map[string]any{
+			"name":            "demo",
+			"namespace":       "default",
+			"resourceVersion": "42",
+		},
+		"data": map[string]any{
+			"key":   "old",
+			"other": "y",
+		},
+	}
+
+	want := map[string]any{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata": map[string]any{
+			"name":      "demo",
+			"namespace": "default",
+		},
+		"data": map[string]any{
+			"key": "old",
+		},
+	}
+
+	if got := previousContent(applied, prev); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got: %v, expected: %v", got, want)
+	}
+}
@@ -6,43 +6,54 @@ import (
 	"fmt"
 
 	"github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
-	"github.com/krateoplatformops/installer/internal/cache"
-	"github.com/krateoplatformops/installer/internal/dynamic/applier"
-	"github.com/krateoplatformops/installer/internal/dynamic/deletor"
 	"github.com/krateoplatformops/installer/internal/expand"
-	"github.com/krateoplatformops/installer/internal/workflows/steps"
+	"github.com/krateoplatformops/installer/pkg/cache"
+	"github.com/krateoplatformops/installer/pkg/dynamic/applier"
+	"github.com/krateoplatformops/installer/pkg/dynamic/deletor"
+	"github.com/krateoplatformops/installer/pkg/dynamic/getter"
+	"github.com/krateoplatformops/installer/pkg/locker"
+	"github.com/krateoplatformops/installer/pkg/workflows/steps"
 	"github.com/krateoplatformops/plumbing/ptr"
 	"github.com/krateoplatformops/provider-runtime/pkg/logging"
 	"helm.sh/helm/v3/pkg/strvals"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
-var _ steps.Handler[*steps.ObjectResult] = (*objStepHandler)(nil)
+var (
+	_ steps.Handler[*steps.ObjectResult] = (*objStepHandler)(nil)
+	_ steps.Rollbacker                   = (*objStepHandler)(nil)
+	_ steps.Planner                      = (*objStepHandler)(nil)
+)
 
-func ObjectHandler(app *applier.Applier, del *deletor.Deletor, env *cache.Cache[string, string], logr logging.Logger) steps.Handler[*steps.ObjectResult] {
-	return &objStepHandler{
-		app: app, del: del, env: env,
-		subst: func(k string) string {
-			if v, ok := env.Get(k); ok {
-				return v
-			}
+func init() {
+	steps.Register(v1alpha1.TypeObject, func(opts steps.HandlerOptions) steps.Handler[steps.Result] {
+		return steps.Adapt(ObjectHandler(opts.Applier, opts.Deletor, opts.Getter, opts.Locker, opts.Env, opts.Log))
+	})
+}
 
-			return "$" + k
-		},
-		logr: logr,
+// ObjectHandler returns the handler of the object steps, lock can be nil.
+func ObjectHandler(app *applier.Applier, del *deletor.Deletor, dyn *getter.Getter, lock *locker.Locker, env *cache.Cache[string, string], logr logging.Logger) steps.Handler[*steps.ObjectResult] {
+	return &objStepHandler{
+		app: app, del: del, dyn: dyn, lock: lock, env: env,
+		subst: steps.Subst(env),
+		logr:  logr,
 	}
 }
 
 type objStepHandler struct {
 	app   *applier.Applier
 	del   *deletor.Deletor
+	dyn   *getter.Getter
+	lock  *locker.Locker
 	env   *cache.Cache[string, string]
 	ns    string
 	op    steps.Op
-	subst func(k string) string
+	plan  bool
+	subst func(k string) (string, bool)
 	logr  logging.Logger
 }
 
@@ -54,6 +65,10 @@ func (r *objStepHandler) Op(op steps.Op) {
 	r.op = op
 }
 
+func (r *objStepHandler) DryRun(on bool) {
+	r.plan = on
+}
+
 func (r *objStepHandler) Handle(ctx context.Context, id string, ext *runtime.RawExtension) (*steps.ObjectResult, error) {
 	uns, err := r.toUnstructured(id, ext)
 	if err != nil {
@@ -72,29 +87,179 @@ func (r *objStepHandler) Handle(ctx context.Context, id string, ext *runtime.Raw
 		Namespace:  uns.GetNamespace(),
 	}
 
+	key := locker.ObjectKey(gv.WithKind(uns.GetKind()), uns.GetNamespace(), uns.GetName())
+
 	if r.op == steps.Delete {
 		result.Operation = "delete"
+
+		// the object now belongs to another KrateoPlatformOps
+		if err := r.lock.Acquire(ctx, key); locker.IsConflict(err) {
+			r.logr.Info(fmt.Sprintf("WARN: not deleting, %s", err.Error()))
+			return result, nil
+		} else if err != nil {
+			return result, err
+		}
+
 		err := r.del.Delete(ctx, deletor.DeleteOptions{
 			GVK:       gv.WithKind(uns.GetKind()),
 			Namespace: uns.GetNamespace(),
 			Name:      uns.GetName(),
 		})
-		if apierrors.IsNotFound(err) {
-			err = nil
+		if err != nil && !apierrors.IsNotFound(err) {
+			return result, err
 		}
+		return result, r.lock.Release(ctx, key)
+	}
+
+	if r.plan {
+		result.Operation = "plan"
+		result.Plan, err = r.planApply(ctx, uns, gv.WithKind(uns.GetKind()))
 		return result, err
 	}
 
 	result.Operation = "apply"
-	err = r.app.Apply(ctx, uns.Object, applier.ApplyOptions{
+	if err := r.lock.Acquire(ctx, key); err != nil {
+		return result, err
+	}
+
+	got, err := r.app.ApplyObject(ctx, uns.Object, applier.ApplyOptions{
 		GVK:       gv.WithKind(uns.GetKind()),
 		Namespace: uns.GetNamespace(),
 		Name:      uns.GetName(),
 	})
+	if err != nil {
+		return result, err
+	}
+
+	outputs, err := steps.OutputsOf(ext.Raw)
+	if err != nil {
+		return result, err
+	}
+	result.Outputs, err = steps.SetOutputs(ctx, r.env, outputs, got.Object)
 
 	return result, err
 }
 
+// planApply compares the object with the result of a server-side dry-run apply.
+func (r *objStepHandler) planApply(ctx context.Context, uns *unstructured.Unstructured, gvk schema.GroupVersionKind) (*v1alpha1.PlannedChange, error) {
+	change := &v1alpha1.PlannedChange{
+		Action:   "create",
+		Resource: steps.ResourceName(uns.GetKind(), uns.GetNamespace(), uns.GetName()),
+	}
+
+	cur, err := r.dyn.Get(ctx, getter.GetOptions{
+		GVK:       gvk,
+		Namespace: uns.GetNamespace(),
+		Name:      uns.GetName(),
+	})
+	if err != nil && !apierrors.IsNotFound(err) && !meta.IsNoMatchError(err) {
+		return nil, err
+	}
+	if err == nil {
+		change.Action = "update"
+	}
+
+	got, err := r.app.ApplyObject(ctx, uns.Object, applier.ApplyOptions{
+		GVK:       gvk,
+		Namespace: uns.GetNamespace(),
+		Name:      uns.GetName(),
+		DryRun:    true,
+	})
+	if err != nil {
+		// i.e. the namespace or the CRD are created by a previous step
+		change.Error = err.Error()
+		return change, nil
+	}
+
+	if cur == nil {
+		return change, nil
+	}
+
+	change.Changes = steps.DiffPaths(steps.WithoutServerFields(cur.Object), steps.WithoutServerFields(got.Object))
+	if len(change.Changes) == 0 {
+		change.Action = "none"
+	}
+
+	return change, nil
+}
+
+// Prepare captures the current values of the fields the step is about
+// to apply. The returned rollback applies them again, or deletes the
+// object if it didn't exist.
+func (r *objStepHandler) Prepare(ctx context.Context, id string, ext *runtime.RawExtension) (steps.Rollback, error) {
+	if r.op == steps.Delete {
+		return nil, nil
+	}
+
+	uns, err := r.toUnstructured(id, ext)
+	if err != nil {
+		return nil, err
+	}
+
+	gv, err := schema.ParseGroupVersion(uns.GetAPIVersion())
+	if err != nil {
+		return nil, err
+	}
+	gvk := gv.WithKind(uns.GetKind())
+
+	prev, err := r.dyn.Get(ctx, getter.GetOptions{
+		GVK:       gvk,
+		Namespace: uns.GetNamespace(),
+		Name:      uns.GetName(),
+	})
+	if apierrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+		return func(ctx context.Context) error {
+			err := r.del.Delete(ctx, deletor.DeleteOptions{
+				GVK:       gvk,
+				Namespace: uns.GetNamespace(),
+				Name:      uns.GetName(),
+			})
+			if apierrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+				return nil
+			}
+			return err
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	content := previousContent(uns.Object, prev.Object)
+
+	return func(ctx context.Context) error {
+		r.logr.Debug(fmt.Sprintf("DBG [object:%s]: rollback %s, name: %s", id, gvk, uns.GetName()))
+
+		return r.app.Apply(ctx, content, applier.ApplyOptions{
+			GVK:       gvk,
+			Namespace: uns.GetNamespace(),
+			Name:      uns.GetName(),
+		})
+	}, nil
+}
+
+// previousContent returns the values that the fields set by applied
+// have in prev, omitting the fields prev doesn't have.
+func previousContent(applied, prev map[string]any) map[string]any {
+	res := map[string]any{}
+	for k, v := range applied {
+		pv, ok := prev[k]
+		if !ok {
+			continue
+		}
+
+		am, aok := v.(map[string]any)
+		pm, pok := pv.(map[string]any)
+		if aok && pok {
+			res[k] = previousContent(am, pm)
+			continue
+		}
+
+		res[k] = pv
+	}
+
+	return res
+}
+
 func (r *objStepHandler) toUnstructured(id string, ext *runtime.RawExtension) (*unstructured.Unstructured, error) {
 	res := v1alpha1.Object{}
 	err := json.Unmarshal(ext.Raw, &res)
@@ -129,17 +294,17 @@ func (r *objStepHandler) toUnstructured(id string, ext *runtime.RawExtension) (*
 func (r *objStepHandler) resolveVars(id string, res []*v1alpha1.Data, src map[string]any) error {
 	for _, el := range res {
 		if len(el.Value) > 0 {
-			val := expand.Expand(el.Value, "", r.subst)
+			val := expand.Known(el.Value, r.subst)
 			line := fmt.Sprintf("%s=%s", el.Name, val)
 			if ptr.Deref(el.AsString, false) {
 				err := strvals.ParseIntoString(line, src)
 				if err != nil {
-					return err
+					return steps.Permanent(err)
 				}
 			} else {
 				err := strvals.ParseInto(line, src)
 				if err != nil {
-					return err
+					return steps.Permanent(err)
 				}
 			}
 
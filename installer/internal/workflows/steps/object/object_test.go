@@ -21,10 +21,11 @@ import (
 	"sigs.k8s.io/e2e-framework/pkg/features"
 	"sigs.k8s.io/e2e-framework/support/kind"
 
-	"github.com/krateoplatformops/installer/internal/cache"
-	"github.com/krateoplatformops/installer/internal/dynamic/applier"
-	"github.com/krateoplatformops/installer/internal/dynamic/deletor"
-	"github.com/krateoplatformops/installer/internal/workflows/steps"
+	"github.com/krateoplatformops/installer/pkg/cache"
+	"github.com/krateoplatformops/installer/pkg/dynamic/applier"
+	"github.com/krateoplatformops/installer/pkg/dynamic/deletor"
+	"github.com/krateoplatformops/installer/pkg/dynamic/getter"
+	"github.com/krateoplatformops/installer/pkg/workflows/steps"
 	"github.com/krateoplatformops/provider-runtime/pkg/logging"
 )
 
@@ -439,20 +440,26 @@ func createObjectHandler(cfg *envconf.Config) (*objStepHandler, error) {
 		return nil, fmt.Errorf("failed to create dynamic deletor: %w", err)
 	}
 
+	getter, err := getter.NewGetter(cfg.Client().RESTConfig())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamic getter: %w", err)
+	}
+
 	env := cache.New[string, string]()
 	zl := zap.New(zap.UseDevMode(true))
 	log := logging.NewLogrLogger(zl.WithName("object-test"))
 
-	handler := ObjectHandler(applier, deletor, env, log)
+	handler := ObjectHandler(applier, deletor, getter, nil, env, log)
 	return handler.(*objStepHandler), nil
 }
 
 func createObjectHandlerWithEnv(cfg *envconf.Config, env *cache.Cache[string, string]) (*objStepHandler, error) {
 	applier, _ := applier.NewApplier(cfg.Client().RESTConfig())
 	deletor, _ := deletor.NewDeletor(cfg.Client().RESTConfig())
+	getter, _ := getter.NewGetter(cfg.Client().RESTConfig())
 	zl := zap.New(zap.UseDevMode(true))
 	log := logging.NewLogrLogger(zl.WithName("object-test"))
 
-	handler := ObjectHandler(applier, deletor, env, log)
+	handler := ObjectHandler(applier, deletor, getter, nil, env, log)
 	return handler.(*objStepHandler), nil
 }
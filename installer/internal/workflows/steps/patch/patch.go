@@ -0,0 +1,259 @@
+package steps
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+	"github.com/krateoplatformops/installer/internal/expand"
+	"github.com/krateoplatformops/installer/pkg/cache"
+	"github.com/krateoplatformops/installer/pkg/dynamic/applier"
+	"github.com/krateoplatformops/installer/pkg/dynamic/getter"
+	"github.com/krateoplatformops/installer/pkg/workflows/steps"
+	"github.com/krateoplatformops/provider-runtime/pkg/logging"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var (
+	_ steps.Handler[*steps.PatchResult] = (*patchStepHandler)(nil)
+	_ steps.Planner                     = (*patchStepHandler)(nil)
+)
+
+var patchTypes = map[v1alpha1.PatchType]types.PatchType{
+	v1alpha1.PatchJSON:      types.JSONPatchType,
+	v1alpha1.PatchMerge:     types.MergePatchType,
+	v1alpha1.PatchStrategic: types.StrategicMergePatchType,
+}
+
+func init() {
+	steps.Register(v1alpha1.TypePatch, func(opts steps.HandlerOptions) steps.Handler[steps.Result] {
+		return steps.Adapt(PatchHandler(opts.Applier, opts.Getter, opts.Env, opts.Log))
+	})
+}
+
+func PatchHandler(app *applier.Applier, dyn *getter.Getter, env *cache.Cache[string, string], logr logging.Logger) steps.Handler[*steps.PatchResult] {
+	return &patchStepHandler{
+		get: dyn.Get, list: dyn.List, patch: app.Patch,
+		subst: steps.SubstJSON(env),
+		logr:  logr,
+	}
+}
+
+type patchStepHandler struct {
+	get   func(ctx context.Context, opts getter.GetOptions) (*unstructured.Unstructured, error)
+	list  func(ctx context.Context, opts getter.ListOptions) (*unstructured.UnstructuredList, error)
+	patch func(ctx context.Context, opts applier.PatchOptions) (*unstructured.Unstructured, error)
+	ns    string
+	op    steps.Op
+	plan  bool
+	subst func(k string) (string, bool)
+	logr  logging.Logger
+}
+
+func (r *patchStepHandler) Namespace(ns string) {
+	r.ns = ns
+}
+
+func (r *patchStepHandler) Op(op steps.Op) {
+	r.op = op
+}
+
+func (r *patchStepHandler) DryRun(on bool) {
+	r.plan = on
+}
+
+// Handle patches the target objects. Nothing is done on delete:
+// the objects are not managed by the workflow.
+func (r *patchStepHandler) Handle(ctx context.Context, id string, ext *runtime.RawExtension) (*steps.PatchResult, error) {
+	if r.op == steps.Delete {
+		return nil, nil
+	}
+
+	spec, err := r.toPatchSpec(ext)
+	if err != nil {
+		return nil, err
+	}
+
+	gv, err := schema.ParseGroupVersion(spec.APIVersion)
+	if err != nil {
+		return nil, steps.Permanent(err)
+	}
+	gvk := gv.WithKind(spec.Kind)
+
+	result := &steps.PatchResult{
+		APIVersion: spec.APIVersion,
+		Kind:       spec.Kind,
+	}
+
+	targets, err := r.targets(ctx, gvk, spec)
+	if err != nil {
+		return result, err
+	}
+
+	if r.plan {
+		result.Plan, err = r.planPatch(ctx, gvk, spec, targets)
+		return result, err
+	}
+
+	for _, x := range targets {
+		got, err := r.patch(ctx, applier.PatchOptions{
+			GVK:       gvk,
+			Namespace: x.GetNamespace(),
+			Name:      x.GetName(),
+			Type:      patchTypes[spec.Type],
+			Data:      spec.Patch.Raw,
+		})
+		if (apierrors.IsNotFound(err) || meta.IsNoMatchError(err)) && spec.IgnoreMissing {
+			r.logr.Debug(fmt.Sprintf("[patch:%s]: %s %s not found, ignored", id, spec.Kind, x.GetName()))
+			continue
+		}
+		if err != nil {
+			return result, fmt.Errorf("patching %s: %w",
+				steps.ResourceName(spec.Kind, x.GetNamespace(), x.GetName()), err)
+		}
+
+		// the namespace of cluster-scoped objects is the one set by the server
+		result.Patched = append(result.Patched, objectName(*got))
+		r.logr.Debug(fmt.Sprintf("[patch:%s]: patched %s %s", id, spec.Kind, objectName(*got)))
+	}
+
+	return result, nil
+}
+
+// targets returns the objects selected by the step, only name and
+// namespace are set when the object is selected by name.
+func (r *patchStepHandler) targets(ctx context.Context, gvk schema.GroupVersionKind, spec *v1alpha1.PatchSpec) ([]unstructured.Unstructured, error) {
+	if spec.Selector == nil {
+		obj := unstructured.Unstructured{}
+		obj.SetName(spec.Metadata.Name)
+		obj.SetNamespace(spec.Metadata.Namespace)
+		return []unstructured.Unstructured{obj}, nil
+	}
+
+	sel, err := metav1.LabelSelectorAsSelector(spec.Selector)
+	if err != nil {
+		return nil, steps.Permanent(fmt.Errorf("invalid selector: %w", err))
+	}
+
+	all, err := r.list(ctx, getter.ListOptions{
+		GVK:           gvk,
+		Namespace:     spec.Metadata.Namespace,
+		LabelSelector: sel.String(),
+	})
+	if meta.IsNoMatchError(err) && spec.IgnoreMissing {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(all.Items) == 0 && !spec.IgnoreMissing {
+		return nil, fmt.Errorf("no %s matches the selector %q", spec.Kind, sel.String())
+	}
+
+	return all.Items, nil
+}
+
+// planPatch compares the objects with the result of a dry-run patch.
+func (r *patchStepHandler) planPatch(ctx context.Context, gvk schema.GroupVersionKind, spec *v1alpha1.PatchSpec, targets []unstructured.Unstructured) (*v1alpha1.PlannedChange, error) {
+	change := &v1alpha1.PlannedChange{
+		Action:   "none",
+		Resource: steps.ResourceName(spec.Kind, spec.Metadata.Namespace, spec.Metadata.Name),
+	}
+	if spec.Selector != nil {
+		sel, _ := metav1.LabelSelectorAsSelector(spec.Selector)
+		change.Resource = steps.ResourceName(spec.Kind, spec.Metadata.Namespace, sel.String())
+	}
+
+	for _, x := range targets {
+		cur, err := r.get(ctx, getter.GetOptions{GVK: gvk, Namespace: x.GetNamespace(), Name: x.GetName()})
+		if (apierrors.IsNotFound(err) || meta.IsNoMatchError(err)) && spec.IgnoreMissing {
+			continue
+		}
+		if err != nil {
+			// i.e. the object is created by a previous step
+			change.Error = err.Error()
+			return change, nil
+		}
+
+		got, err := r.patch(ctx, applier.PatchOptions{
+			GVK:       gvk,
+			Namespace: x.GetNamespace(),
+			Name:      x.GetName(),
+			Type:      patchTypes[spec.Type],
+			Data:      spec.Patch.Raw,
+			DryRun:    true,
+		})
+		if err != nil {
+			change.Error = err.Error()
+			return change, nil
+		}
+
+		diff := steps.DiffPaths(steps.WithoutServerFields(cur.Object), steps.WithoutServerFields(got.Object))
+		for _, el := range diff {
+			if spec.Selector != nil {
+				el = fmt.Sprintf("%s %s", el[:1], objectName(x)+":"+el[2:])
+			}
+			change.Changes = append(change.Changes, el)
+		}
+	}
+
+	if len(change.Changes) > 0 {
+		change.Action = "patch"
+	}
+
+	return change, nil
+}
+
+func (r *patchStepHandler) toPatchSpec(ext *runtime.RawExtension) (*v1alpha1.PatchSpec, error) {
+	raw := expand.Known(string(ext.Raw), r.subst)
+
+	spec := &v1alpha1.PatchSpec{}
+	if err := json.Unmarshal([]byte(raw), spec); err != nil {
+		return nil, err
+	}
+
+	if len(spec.APIVersion) == 0 || len(spec.Kind) == 0 {
+		return nil, steps.Permanent(fmt.Errorf("apiVersion and kind are required"))
+	}
+	if (len(spec.Metadata.Name) == 0) == (spec.Selector == nil) {
+		return nil, steps.Permanent(fmt.Errorf("either metadata.name or selector must be set"))
+	}
+	if len(spec.Metadata.Namespace) == 0 {
+		spec.Metadata.Namespace = r.ns
+	}
+
+	if len(spec.Type) == 0 {
+		spec.Type = v1alpha1.PatchMerge
+	}
+	if _, ok := patchTypes[spec.Type]; !ok {
+		return nil, steps.Permanent(fmt.Errorf("unknown patch type %q", spec.Type))
+	}
+
+	doc := bytes.TrimSpace(spec.Patch.Raw)
+	switch {
+	case len(doc) == 0 || bytes.Equal(doc, []byte("null")):
+		return nil, steps.Permanent(fmt.Errorf("patch is required"))
+	case spec.Type == v1alpha1.PatchJSON && doc[0] != '[':
+		return nil, steps.Permanent(fmt.Errorf("a json patch must be a list of operations"))
+	case spec.Type != v1alpha1.PatchJSON && doc[0] != '{':
+		return nil, steps.Permanent(fmt.Errorf("a %s patch must be an object", spec.Type))
+	}
+
+	return spec, nil
+}
+
+func objectName(obj unstructured.Unstructured) string {
+	if len(obj.GetNamespace()) == 0 {
+		return obj.GetName()
+	}
+	return obj.GetNamespace() + "/" + obj.GetName()
+}
@@ -0,0 +1,288 @@
+package steps
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/krateoplatformops/installer/pkg/dynamic/applier"
+	"github.com/krateoplatformops/installer/pkg/dynamic/getter"
+	"github.com/krateoplatformops/installer/pkg/workflows/steps"
+	"github.com/krateoplatformops/provider-runtime/pkg/logging"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// fakeHandler returns a handler patching the given deployments, the
+// patch sets the "patched" label whatever the patch document is.
+// Kinds other than Deployment are not registered.
+func fakeHandler(t *testing.T, objs ...*unstructured.Unstructured) (*patchStepHandler, *[]applier.PatchOptions) {
+	t.Helper()
+
+	find := func(ns, name string) *unstructured.Unstructured {
+		for _, x := range objs {
+			if x.GetNamespace() == ns && x.GetName() == name {
+				return x.DeepCopy()
+			}
+		}
+		return nil
+	}
+
+	calls := []applier.PatchOptions{}
+	return &patchStepHandler{
+		ns:   "demo",
+		logr: logging.NewNopLogger(),
+		subst: func(k string) (string, bool) {
+			return "3", k == "REPLICAS"
+		},
+		get: func(_ context.Context, opts getter.GetOptions) (*unstructured.Unstructured, error) {
+			if err := noMatch(opts.GVK); err != nil {
+				return nil, err
+			}
+			if obj := find(opts.Namespace, opts.Name); obj != nil {
+				return obj, nil
+			}
+			return nil, apierrors.NewNotFound(schema.GroupResource{Resource: "deployments"}, opts.Name)
+		},
+		list: func(_ context.Context, opts getter.ListOptions) (*unstructured.UnstructuredList, error) {
+			if err := noMatch(opts.GVK); err != nil {
+				return nil, err
+			}
+			all := &unstructured.UnstructuredList{}
+			for _, x := range objs {
+				if x.GetNamespace() == opts.Namespace && x.GetLabels()["app"] == strings.TrimPrefix(opts.LabelSelector, "app=") {
+					all.Items = append(all.Items, *x.DeepCopy())
+				}
+			}
+			return all, nil
+		},
+		patch: func(_ context.Context, opts applier.PatchOptions) (*unstructured.Unstructured, error) {
+			calls = append(calls, opts)
+			if err := noMatch(opts.GVK); err != nil {
+				return nil, err
+			}
+			obj := find(opts.Namespace, opts.Name)
+			if obj == nil {
+				return nil, apierrors.NewNotFound(schema.GroupResource{Resource: "deployments"}, opts.Name)
+			}
+			obj.SetLabels(map[string]string{"app": obj.GetLabels()["app"], "patched": "true"})
+			return obj, nil
+		},
+	}, &calls
+}
+
+func noMatch(gvk schema.GroupVersionKind) error {
+	if gvk.Kind == "Deployment" {
+		return nil
+	}
+	return &meta.NoKindMatchError{GroupKind: gvk.GroupKind(), SearchedVersions: []string{gvk.Version}}
+}
+
+func deployment(ns, name, app string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("apps/v1")
+	obj.SetKind("Deployment")
+	obj.SetNamespace(ns)
+	obj.SetName(name)
+	obj.SetLabels(map[string]string{"app": app})
+	return obj
+}
+
+func TestPatchByName(t *testing.T) {
+	hdl, calls := fakeHandler(t, deployment("demo", "api", "api"))
+	hdl.Op(steps.Create)
+
+	ext := &runtime.RawExtension{Raw: []byte(`{
+		"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "api"},
+		"type": "json",
+		"patch": [{"op": "replace", "path": "/spec/replicas", "value": $REPLICAS}]
+	}`)}
+
+	res, err := hdl.Handle(context.Background(), "scale", ext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Patched) != 1 || res.Patched[0] != "demo/api" {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+
+	if len(*calls) != 1 {
+		t.Fatalf("got %d patches, expected 1", len(*calls))
+	}
+	got := (*calls)[0]
+	if got.Type != types.JSONPatchType || got.DryRun || got.GVK.Group != "apps" {
+		t.Fatalf("unexpected patch options: %+v", got)
+	}
+	if !strings.Contains(string(got.Data), `"value": 3`) {
+		t.Fatalf("unexpected patch: %s", got.Data)
+	}
+}
+
+func TestPatchBySelector(t *testing.T) {
+	hdl, calls := fakeHandler(t,
+		deployment("demo", "api", "backend"),
+		deployment("demo", "worker", "backend"),
+		deployment("demo", "web", "frontend"),
+		deployment("other", "api", "backend"),
+	)
+
+	ext := &runtime.RawExtension{Raw: []byte(`{
+		"apiVersion": "apps/v1", "kind": "Deployment",
+		"selector": {"matchLabels": {"app": "backend"}},
+		"patch": {"spec": {"template": {"metadata": {"annotations": {"restartedAt": "now"}}}}}
+	}`)}
+
+	res, err := hdl.Handle(context.Background(), "restart", ext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(res.Patched, ",") != "demo/api,demo/worker" {
+		t.Fatalf("unexpected patched objects: %v", res.Patched)
+	}
+	for _, x := range *calls {
+		if x.Type != types.MergePatchType {
+			t.Fatalf("expected a merge patch by default, got %s", x.Type)
+		}
+	}
+}
+
+func TestPatchMissing(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		err  string
+	}{
+		{
+			name: "by name",
+			raw:  `{"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "api"}, "patch": {}}`,
+			err:  `deployments "api" not found`,
+		},
+		{
+			name: "by selector",
+			raw:  `{"apiVersion": "apps/v1", "kind": "Deployment", "selector": {"matchLabels": {"app": "api"}}, "patch": {}}`,
+			err:  `no Deployment matches the selector "app=api"`,
+		},
+		{
+			name: "kind by name",
+			raw:  `{"apiVersion": "example.org/v1", "kind": "Widget", "metadata": {"name": "api"}, "patch": {}}`,
+			err:  `no matches for kind "Widget" in version "example.org/v1"`,
+		},
+		{
+			name: "kind by selector",
+			raw:  `{"apiVersion": "example.org/v1", "kind": "Widget", "selector": {"matchLabels": {"app": "api"}}, "patch": {}}`,
+			err:  `no matches for kind "Widget" in version "example.org/v1"`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			hdl, _ := fakeHandler(t)
+
+			_, err := hdl.Handle(context.Background(), "x", &runtime.RawExtension{Raw: []byte(tc.raw)})
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("expected an error containing %q, got: %v", tc.err, err)
+			}
+
+			raw := strings.Replace(tc.raw, `"patch"`, `"ignoreMissing": true, "patch"`, 1)
+			res, err := hdl.Handle(context.Background(), "x", &runtime.RawExtension{Raw: []byte(raw)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(res.Patched) > 0 {
+				t.Fatalf("unexpected patched objects: %v", res.Patched)
+			}
+		})
+	}
+}
+
+func TestPatchPlan(t *testing.T) {
+	hdl, calls := fakeHandler(t, deployment("demo", "api", "api"))
+	hdl.DryRun(true)
+
+	ext := &runtime.RawExtension{Raw: []byte(`{
+		"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "api"},
+		"type": "strategic", "patch": {"metadata": {"labels": {"patched": "true"}}}
+	}`)}
+
+	res, err := hdl.Handle(context.Background(), "x", ext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Plan == nil || res.Plan.Action != "patch" || res.Plan.Resource != "Deployment demo/api" {
+		t.Fatalf("unexpected plan: %+v", res.Plan)
+	}
+	if len(res.Plan.Changes) != 1 || res.Plan.Changes[0] != "+ metadata.labels.patched" {
+		t.Fatalf("unexpected changes: %v", res.Plan.Changes)
+	}
+	if len(*calls) != 1 || !(*calls)[0].DryRun || (*calls)[0].Type != types.StrategicMergePatchType {
+		t.Fatalf("expected a dry-run strategic patch, got: %+v", *calls)
+	}
+	if len(res.Patched) > 0 {
+		t.Fatal("nothing must be patched in plan mode")
+	}
+}
+
+func TestPatchInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		err  string
+	}{
+		{
+			name: "missing kind",
+			raw:  `{"apiVersion": "v1", "metadata": {"name": "x"}, "patch": {}}`,
+			err:  "apiVersion and kind are required",
+		},
+		{
+			name: "name and selector",
+			raw:  `{"apiVersion": "v1", "kind": "Service", "metadata": {"name": "x"}, "selector": {}, "patch": {}}`,
+			err:  "either metadata.name or selector must be set",
+		},
+		{
+			name: "unknown type",
+			raw:  `{"apiVersion": "v1", "kind": "Service", "metadata": {"name": "x"}, "type": "xml", "patch": {}}`,
+			err:  `unknown patch type "xml"`,
+		},
+		{
+			name: "missing patch",
+			raw:  `{"apiVersion": "v1", "kind": "Service", "metadata": {"name": "x"}}`,
+			err:  "patch is required",
+		},
+		{
+			name: "json patch object",
+			raw:  `{"apiVersion": "v1", "kind": "Service", "metadata": {"name": "x"}, "type": "json", "patch": {}}`,
+			err:  "a json patch must be a list of operations",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			hdl, calls := fakeHandler(t)
+
+			_, err := hdl.Handle(context.Background(), "x", &runtime.RawExtension{Raw: []byte(tc.raw)})
+			if err == nil || !strings.Contains(err.Error(), tc.err) || !steps.IsPermanent(err) {
+				t.Fatalf("expected a permanent error containing %q, got: %v", tc.err, err)
+			}
+			if len(*calls) > 0 {
+				t.Fatal("nothing must be patched")
+			}
+		})
+	}
+}
+
+func TestPatchDelete(t *testing.T) {
+	hdl, calls := fakeHandler(t, deployment("demo", "api", "api"))
+	hdl.Op(steps.Delete)
+
+	res, err := hdl.Handle(context.Background(), "x", &runtime.RawExtension{Raw: []byte(`{}`)})
+	if err != nil || res != nil {
+		t.Fatalf("expected no result, got: %v, %v", res, err)
+	}
+	if len(*calls) > 0 {
+		t.Fatal("nothing must be patched on delete")
+	}
+}
@@ -6,29 +6,32 @@ import (
 	"fmt"
 
 	"github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
-	"github.com/krateoplatformops/installer/internal/cache"
-	"github.com/krateoplatformops/installer/internal/dynamic"
-	"github.com/krateoplatformops/installer/internal/dynamic/getter"
 	"github.com/krateoplatformops/installer/internal/expand"
-	"github.com/krateoplatformops/installer/internal/workflows/steps"
+	"github.com/krateoplatformops/installer/pkg/cache"
+	"github.com/krateoplatformops/installer/pkg/dynamic"
+	"github.com/krateoplatformops/installer/pkg/dynamic/getter"
+	"github.com/krateoplatformops/installer/pkg/workflows/steps"
 	"github.com/krateoplatformops/provider-runtime/pkg/logging"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
-var _ steps.Handler[*steps.VarResult] = (*varStepHandler)(nil)
+var (
+	_ steps.Handler[*steps.VarResult] = (*varStepHandler)(nil)
+	_ steps.Planner                   = (*varStepHandler)(nil)
+)
+
+func init() {
+	steps.Register(v1alpha1.TypeVar, func(opts steps.HandlerOptions) steps.Handler[steps.Result] {
+		return steps.Adapt(VarHandler(opts.Getter, opts.Env, opts.Log))
+	})
+}
 
 func VarHandler(dyn *getter.Getter, env *cache.Cache[string, string], logr logging.Logger) steps.Handler[*steps.VarResult] {
 	return &varStepHandler{
 		dyn: dyn, env: env,
-		subst: func(k string) string {
-			if v, ok := env.Get(k); ok {
-				return v
-			}
-
-			return "$" + k
-		},
-		logr: logr,
+		subst: steps.Subst(env),
+		logr:  logr,
 	}
 }
 
@@ -36,7 +39,7 @@ type varStepHandler struct {
 	dyn   *getter.Getter
 	env   *cache.Cache[string, string]
 	ns    string
-	subst func(k string) string
+	subst func(k string) (string, bool)
 	op    steps.Op
 	logr  logging.Logger
 }
@@ -45,6 +48,9 @@ func (r *varStepHandler) Op(op steps.Op) {
 	r.op = op
 }
 
+// DryRun is a no-op since var steps don't change anything.
+func (r *varStepHandler) DryRun(bool) {}
+
 func (r *varStepHandler) Namespace(ns string) {
 	r.ns = ns
 }
@@ -61,7 +67,7 @@ func (r *varStepHandler) Handle(ctx context.Context, id string, ext *runtime.Raw
 	}
 
 	if len(res.Value) > 0 {
-		val := expand.Expand(res.Value, "", r.subst)
+		val := expand.Known(res.Value, r.subst)
 		r.env.Set(res.Name, val)
 		result.Value = val
 
@@ -0,0 +1,165 @@
+package steps
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/itchyny/gojq"
+	"github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+	"github.com/krateoplatformops/installer/internal/expand"
+	"github.com/krateoplatformops/installer/pkg/cache"
+	"github.com/krateoplatformops/installer/pkg/dynamic"
+	"github.com/krateoplatformops/installer/pkg/dynamic/getter"
+	"github.com/krateoplatformops/installer/pkg/workflows/steps"
+	"github.com/krateoplatformops/provider-runtime/pkg/logging"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	defaultInterval = 5 * time.Second
+	defaultTimeout  = 5 * time.Minute
+)
+
+var (
+	_ steps.Handler[*steps.WaitResult] = (*waitStepHandler)(nil)
+	_ steps.Planner                    = (*waitStepHandler)(nil)
+)
+
+func init() {
+	steps.Register(v1alpha1.TypeWait, func(opts steps.HandlerOptions) steps.Handler[steps.Result] {
+		return steps.Adapt(WaitHandler(opts.Getter, opts.Env, opts.Log))
+	})
+}
+
+func WaitHandler(dyn *getter.Getter, env *cache.Cache[string, string], logr logging.Logger) steps.Handler[*steps.WaitResult] {
+	return &waitStepHandler{get: dyn.Get, subst: steps.SubstJSON(env), logr: logr}
+}
+
+type waitStepHandler struct {
+	get   func(ctx context.Context, opts getter.GetOptions) (*unstructured.Unstructured, error)
+	ns    string
+	op    steps.Op
+	plan  bool
+	subst func(k string) (string, bool)
+	logr  logging.Logger
+}
+
+func (r *waitStepHandler) Namespace(ns string) {
+	r.ns = ns
+}
+
+func (r *waitStepHandler) Op(op steps.Op) {
+	r.op = op
+}
+
+// DryRun makes the step return without waiting, since in plan mode
+// the steps before it didn't change anything.
+func (r *waitStepHandler) DryRun(on bool) {
+	r.plan = on
+}
+
+func (r *waitStepHandler) Handle(ctx context.Context, id string, ext *runtime.RawExtension) (*steps.WaitResult, error) {
+	if r.op == steps.Delete || r.plan {
+		return nil, nil
+	}
+
+	raw := expand.Known(string(ext.Raw), r.subst)
+
+	spec := v1alpha1.WaitSpec{}
+	if err := json.Unmarshal([]byte(raw), &spec); err != nil {
+		return nil, err
+	}
+
+	if len(spec.Condition) == 0 {
+		return nil, steps.Permanent(fmt.Errorf("condition is required"))
+	}
+	if _, err := gojq.Parse(spec.Condition); err != nil {
+		return nil, steps.Permanent(fmt.Errorf("invalid condition %q: %w", spec.Condition, err))
+	}
+
+	gv, err := schema.ParseGroupVersion(spec.APIVersion)
+	if err != nil {
+		return nil, steps.Permanent(err)
+	}
+
+	namespace := spec.Metadata.Namespace
+	if len(namespace) == 0 {
+		namespace = r.ns
+	}
+
+	result := &steps.WaitResult{
+		APIVersion: spec.APIVersion,
+		Kind:       spec.Kind,
+		Name:       spec.Metadata.Name,
+		Namespace:  namespace,
+	}
+
+	interval, timeout := defaultInterval, defaultTimeout
+	if spec.Interval != nil && spec.Interval.Duration > 0 {
+		interval = spec.Interval.Duration
+	}
+	if spec.Timeout != nil && spec.Timeout.Duration > 0 {
+		timeout = spec.Timeout.Duration
+	}
+
+	opts := getter.GetOptions{
+		GVK:       gv.WithKind(spec.Kind),
+		Namespace: namespace,
+		Name:      spec.Metadata.Name,
+	}
+
+	// last describes why the condition is not true yet
+	var last string
+	err = wait.PollUntilContextTimeout(ctx, interval, timeout, true, func(ctx context.Context) (bool, error) {
+		obj, err := r.get(ctx, opts)
+		if apierrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+			// i.e. created by an operator or a CRD not established yet
+			last = fmt.Sprintf("%s %s not found", spec.Kind, spec.Metadata.Name)
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		val, err := dynamic.Extract(ctx, obj, spec.Condition)
+		if errors.Is(err, io.EOF) {
+			// the expression produced no value, i.e. the condition is not listed yet
+			last = fmt.Sprintf("condition %q produced no value", spec.Condition)
+			return false, nil
+		}
+		if err != nil {
+			// i.e. iterating over a status not set yet
+			last = fmt.Sprintf("evaluating condition %q: %s", spec.Condition, err.Error())
+			return false, nil
+		}
+
+		result.Value = steps.Strval(val)
+		if val == nil || val == false {
+			last = fmt.Sprintf("condition %q is %v", spec.Condition, val)
+			return false, nil
+		}
+
+		return true, nil
+	})
+	if err != nil {
+		if len(last) > 0 && errors.Is(err, context.DeadlineExceeded) {
+			return result, fmt.Errorf("waiting for %s %s: %s: %w",
+				spec.Kind, spec.Metadata.Name, last, err)
+		}
+		return result, err
+	}
+
+	r.logr.Debug(fmt.Sprintf("[wait:%s]: condition %q is true for %s %s (value: %s)",
+		id, spec.Condition, spec.Kind, spec.Metadata.Name, result.Value))
+
+	return result, nil
+}
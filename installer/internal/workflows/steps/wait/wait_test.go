@@ -0,0 +1,214 @@
+package steps
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/krateoplatformops/installer/pkg/cache"
+	"github.com/krateoplatformops/installer/pkg/dynamic/getter"
+	"github.com/krateoplatformops/installer/pkg/workflows/steps"
+	"github.com/krateoplatformops/provider-runtime/pkg/logging"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// fakeHandler returns the objects in sequence, one for each poll,
+// nil meaning not found. The last one is returned forever.
+func fakeHandler(t *testing.T, objs ...map[string]any) (*waitStepHandler, *[]getter.GetOptions) {
+	t.Helper()
+
+	env := cache.New[string, string]()
+	env.Set("GATEWAY", "gateway")
+
+	calls := []getter.GetOptions{}
+	return &waitStepHandler{
+		ns:    "demo",
+		subst: steps.SubstJSON(env),
+		logr:  logging.NewNopLogger(),
+		get: func(_ context.Context, opts getter.GetOptions) (*unstructured.Unstructured, error) {
+			calls = append(calls, opts)
+			obj := objs[min(len(calls), len(objs))-1]
+			if obj == nil {
+				return nil, apierrors.NewNotFound(schema.GroupResource{Resource: "services"}, opts.Name)
+			}
+			return &unstructured.Unstructured{Object: obj}, nil
+		},
+	}, &calls
+}
+
+func service(ip string) map[string]any {
+	obj := map[string]any{"apiVersion": "v1", "kind": "Service", "status": map[string]any{}}
+	if len(ip) > 0 {
+		obj["status"] = map[string]any{
+			"loadBalancer": map[string]any{"ingress": []any{map[string]any{"ip": ip}}},
+		}
+	}
+	return obj
+}
+
+func TestWait(t *testing.T) {
+	hdl, calls := fakeHandler(t, nil, service(""), service("10.0.0.1"))
+
+	ext := &runtime.RawExtension{Raw: []byte(`{
+		"apiVersion": "v1", "kind": "Service", "metadata": {"name": "gateway"},
+		"condition": ".status.loadBalancer.ingress[0].ip",
+		"interval": "10ms"
+	}`)}
+
+	res, err := hdl.Handle(context.Background(), "lb", ext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Value != "10.0.0.1" {
+		t.Fatalf("got value %q, expected 10.0.0.1", res.Value)
+	}
+	if len(*calls) != 3 {
+		t.Fatalf("got %d polls, expected 3", len(*calls))
+	}
+	if got := (*calls)[0]; got.Namespace != "demo" || got.Name != "gateway" || got.GVK.Kind != "Service" {
+		t.Fatalf("unexpected get options: %+v", got)
+	}
+}
+
+func TestWaitClusterScoped(t *testing.T) {
+	crd := map[string]any{
+		"status": map[string]any{"conditions": []any{
+			map[string]any{"type": "NamesAccepted", "status": "True"},
+			map[string]any{"type": "Established", "status": "True"},
+		}},
+	}
+	hdl, calls := fakeHandler(t, crd)
+
+	ext := &runtime.RawExtension{Raw: []byte(`{
+		"apiVersion": "apiextensions.k8s.io/v1", "kind": "CustomResourceDefinition",
+		"metadata": {"name": "widgets.example.io"},
+		"condition": ".status.conditions[] | select(.type == \"Established\") | .status == \"True\""
+	}`)}
+
+	if _, err := hdl.Handle(context.Background(), "crd", ext); err != nil {
+		t.Fatal(err)
+	}
+	if got := (*calls)[0].GVK; got.Group != "apiextensions.k8s.io" || got.Version != "v1" {
+		t.Fatalf("unexpected gvk: %v", got)
+	}
+}
+
+func TestWaitPartialStatus(t *testing.T) {
+	ready := func(conditions ...any) map[string]any {
+		obj := map[string]any{"apiVersion": "example.io/v1", "kind": "Database"}
+		if conditions != nil {
+			obj["status"] = map[string]any{"conditions": conditions}
+		}
+		return obj
+	}
+
+	hdl, calls := fakeHandler(t,
+		ready(),
+		ready(map[string]any{"type": "Synced", "status": "True"}),
+		ready(map[string]any{"type": "Synced", "status": "True"}, map[string]any{"type": "Ready", "status": "True"}),
+	)
+
+	ext := &runtime.RawExtension{Raw: []byte(`{
+		"apiVersion": "example.io/v1", "kind": "Database", "metadata": {"name": "$GATEWAY"},
+		"condition": ".status.conditions[] | select(.type == \"Ready\") | .status == \"True\"",
+		"interval": "10ms"
+	}`)}
+
+	res, err := hdl.Handle(context.Background(), "db", ext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Value != "true" {
+		t.Fatalf("got value %q, expected true", res.Value)
+	}
+	if len(*calls) != 3 {
+		t.Fatalf("got %d polls, expected 3", len(*calls))
+	}
+	if got := (*calls)[0].Name; got != "gateway" {
+		t.Fatalf("got name %q, expected the variable to be expanded", got)
+	}
+}
+
+func TestWaitTimeout(t *testing.T) {
+	hdl, _ := fakeHandler(t, service(""))
+
+	ext := &runtime.RawExtension{Raw: []byte(`{
+		"apiVersion": "v1", "kind": "Service", "metadata": {"name": "gateway"},
+		"condition": ".status.loadBalancer.ingress[0].ip",
+		"interval": "10ms", "timeout": "50ms"
+	}`)}
+
+	start := time.Now()
+	_, err := hdl.Handle(context.Background(), "lb", ext)
+	if err == nil || !strings.Contains(err.Error(), `condition ".status.loadBalancer.ingress[0].ip" is <nil>`) {
+		t.Fatalf("expected a timeout reporting the condition, got: %v", err)
+	}
+	if steps.IsPermanent(err) {
+		t.Fatalf("a timeout must not be permanent: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("the wait took %s", elapsed)
+	}
+}
+
+func TestWaitInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		err  string
+	}{
+		{
+			name: "missing condition",
+			raw:  `{"apiVersion": "v1", "kind": "Service", "metadata": {"name": "x"}}`,
+			err:  "condition is required",
+		},
+		{
+			name: "invalid condition",
+			raw:  `{"apiVersion": "v1", "kind": "Service", "metadata": {"name": "x"}, "condition": ".status["}`,
+			err:  "invalid condition",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			hdl, calls := fakeHandler(t, service(""))
+
+			_, err := hdl.Handle(context.Background(), "x", &runtime.RawExtension{Raw: []byte(tc.raw)})
+			if err == nil || !strings.Contains(err.Error(), tc.err) || !steps.IsPermanent(err) {
+				t.Fatalf("expected a permanent error containing %q, got: %v", tc.err, err)
+			}
+			if len(*calls) > 0 {
+				t.Fatal("the object must not be read")
+			}
+		})
+	}
+}
+
+func TestWaitSkipped(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		op   steps.Op
+		plan bool
+	}{
+		{"delete", steps.Delete, false},
+		{"plan", steps.Create, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			hdl, calls := fakeHandler(t, service(""))
+			hdl.Op(tc.op)
+			hdl.DryRun(tc.plan)
+
+			res, err := hdl.Handle(context.Background(), "x", &runtime.RawExtension{Raw: []byte(`{}`)})
+			if err != nil || res != nil {
+				t.Fatalf("expected no result, got: %v, %v", res, err)
+			}
+			if len(*calls) > 0 {
+				t.Fatal("the object must not be read")
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package workflows
+
+import (
+	"github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+	"github.com/krateoplatformops/installer/internal/expand"
+)
+
+// Applied marks the steps with the given ids as having changed the cluster
+// in a previous run. On Delete, a step referencing variables not resolved
+// by the last run fails only if it has been applied, it is skipped otherwise.
+func (wf *Workflow) Applied(ids ...string) {
+	if wf.applied == nil {
+		wf.applied = make(map[string]bool, len(ids))
+	}
+	for _, id := range ids {
+		wf.applied[id] = true
+	}
+}
+
+// unresolvedVars returns the variables referenced by the step that
+// are not defined in the workflow env.
+func (wf *Workflow) unresolvedVars(x *v1alpha1.Step) []string {
+	if x.With == nil {
+		return nil
+	}
+
+	var res []string
+	for _, k := range expand.Vars(string(x.With.Raw)) {
+		if _, ok := wf.env.Get(k); !ok {
+			res = append(res, k)
+		}
+	}
+	return res
+}
@@ -0,0 +1,68 @@
+package workflows
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+	"github.com/krateoplatformops/installer/pkg/workflows/steps"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func deleteSteps() *v1alpha1.WorkflowSpec {
+	spec := objectSteps(nil, "a")
+	spec.Steps[0].With = &runtime.RawExtension{
+		Raw: []byte(`{"set":[{"name":"metadata.namespace","value":"$NS"},{"name":"data.name","value":"${NAME}"}]}`),
+	}
+	return spec
+}
+
+func TestRunDeleteUnresolvedVars(t *testing.T) {
+	hdl := &fakeHandler{}
+	wf := newFakeWorkflow(hdl, 1, steps.Delete)
+	wf.Restore([]v1alpha1.Data{{Name: "NAME", Value: "krateo"}})
+	wf.Applied("a")
+
+	err := Err(wf.Run(context.Background(), deleteSteps(), noSkip))
+	if err == nil || !strings.Contains(err.Error(), "not resolved by the last run: NS") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !steps.IsPermanent(err) {
+		t.Fatalf("expected permanent error, got: %v", err)
+	}
+	if len(hdl.calls) != 0 {
+		t.Fatalf("expected no step to be executed, got: %v", hdl.calls)
+	}
+}
+
+func TestRunDeleteUnresolvedVarsNotApplied(t *testing.T) {
+	hdl := &fakeHandler{}
+	wf := newFakeWorkflow(hdl, 1, steps.Delete)
+	wf.Restore([]v1alpha1.Data{{Name: "NAME", Value: "krateo"}})
+
+	results := wf.Run(context.Background(), deleteSteps(), noSkip)
+	if err := Err(results); err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Phase() != v1alpha1.StepSkipped {
+		t.Fatalf("expected step a to be skipped, got: %s", results[0].Phase())
+	}
+	if len(hdl.calls) != 0 {
+		t.Fatalf("expected no step to be executed, got: %v", hdl.calls)
+	}
+}
+
+func TestRunDeleteRestoredVars(t *testing.T) {
+	hdl := &fakeHandler{}
+	wf := newFakeWorkflow(hdl, 1, steps.Delete)
+	wf.Restore([]v1alpha1.Data{{Name: "NAME", Value: "krateo"}, {Name: "NS", Value: "krateo-system"}})
+
+	if err := Err(wf.Run(context.Background(), deleteSteps(), noSkip)); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(hdl.calls); got != "[a]" {
+		t.Fatalf("got: %s, expected: [a]", got)
+	}
+}
@@ -0,0 +1,91 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+	"github.com/krateoplatformops/installer/pkg/dynamic"
+	"github.com/krateoplatformops/installer/pkg/workflows/steps"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// evalWhen evaluates the step when condition against the workflow
+// variables, the cluster facts and the given locals (i.e. the item
+// of a forEach instance). Conditions are ignored on Delete.
+func (wf *Workflow) evalWhen(ctx context.Context, x *v1alpha1.Step, locals map[string]any) (bool, error) {
+	if len(x.When) == 0 || wf.op == steps.Delete {
+		return true, nil
+	}
+
+	facts, err := wf.clusterFacts()
+	if err != nil {
+		return false, fmt.Errorf("failed to collect cluster facts: %w", err)
+	}
+
+	vars := map[string]any{}
+	wf.env.ForEach(func(k, v string) bool {
+		vars[k] = v
+		return true
+	})
+
+	obj := &unstructured.Unstructured{Object: map[string]any{
+		"vars":    vars,
+		"cluster": facts,
+	}}
+	for k, v := range locals {
+		obj.Object[k] = v
+	}
+
+	val, err := dynamic.Extract(ctx, obj, x.When)
+	if errors.Is(err, io.EOF) {
+		// the expression produced no value
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to evaluate when condition %q: %w", x.When, err)
+	}
+
+	return val != nil && val != false, nil
+}
+
+// clusterFacts collects, once per workflow, the cluster informations
+// available to the when conditions.
+func (wf *Workflow) clusterFacts() (map[string]any, error) {
+	wf.factsOnce.Do(func() {
+		wf.facts = map[string]any{
+			"namespace": wf.ns,
+		}
+
+		if wf.dyn == nil {
+			return
+		}
+
+		ver, err := wf.dyn.ServerVersion()
+		if err != nil {
+			wf.factsErr = err
+			return
+		}
+		wf.facts["version"] = map[string]any{
+			"major":      ver.Major,
+			"minor":      ver.Minor,
+			"gitVersion": ver.GitVersion,
+			"platform":   ver.Platform,
+		}
+
+		gvs, err := wf.dyn.ServerGroupVersions()
+		if err != nil {
+			wf.factsErr = err
+			return
+		}
+		apis := make([]any, len(gvs))
+		for i, gv := range gvs {
+			apis[i] = gv
+		}
+		wf.facts["apis"] = apis
+	})
+
+	return wf.facts, wf.factsErr
+}
@@ -2,22 +2,39 @@ package workflows
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
-	"github.com/krateoplatformops/installer/internal/cache"
-	"github.com/krateoplatformops/installer/internal/dynamic/applier"
-	"github.com/krateoplatformops/installer/internal/dynamic/deletor"
-	"github.com/krateoplatformops/installer/internal/dynamic/getter"
-	"github.com/krateoplatformops/installer/internal/helmclient"
-	"github.com/krateoplatformops/installer/internal/workflows/steps"
-	charthandler "github.com/krateoplatformops/installer/internal/workflows/steps/chart"
-	objecthandler "github.com/krateoplatformops/installer/internal/workflows/steps/object"
-	varhandler "github.com/krateoplatformops/installer/internal/workflows/steps/var"
+	"github.com/krateoplatformops/installer/internal/expand"
+	"github.com/krateoplatformops/installer/pkg/cache"
+	"github.com/krateoplatformops/installer/pkg/dynamic/applier"
+	"github.com/krateoplatformops/installer/pkg/dynamic/deletor"
+	"github.com/krateoplatformops/installer/pkg/dynamic/getter"
+	"github.com/krateoplatformops/installer/pkg/helmclient"
+	"github.com/krateoplatformops/installer/pkg/locker"
+	"github.com/krateoplatformops/installer/pkg/workflows/steps"
+	// built-in step handlers
+	_ "github.com/krateoplatformops/installer/internal/workflows/steps/chart"
+	_ "github.com/krateoplatformops/installer/internal/workflows/steps/generate"
+	_ "github.com/krateoplatformops/installer/internal/workflows/steps/http"
+	_ "github.com/krateoplatformops/installer/internal/workflows/steps/job"
+	_ "github.com/krateoplatformops/installer/internal/workflows/steps/kustomize"
+	_ "github.com/krateoplatformops/installer/internal/workflows/steps/manifest"
+	_ "github.com/krateoplatformops/installer/internal/workflows/steps/object"
+	_ "github.com/krateoplatformops/installer/internal/workflows/steps/patch"
+	_ "github.com/krateoplatformops/installer/internal/workflows/steps/var"
+	_ "github.com/krateoplatformops/installer/internal/workflows/steps/wait"
 
 	"github.com/krateoplatformops/plumbing/ptr"
 	"github.com/krateoplatformops/provider-runtime/pkg/logging"
+	"github.com/twmb/murmur3"
+	"k8s.io/client-go/kubernetes"
 )
 
 type Opts struct {
@@ -26,8 +43,18 @@ type Opts struct {
 	Deletor        *deletor.Deletor
 	Log            logging.Logger
 	HelmClient     helmclient.Client
+	Clientset      kubernetes.Interface
 	MaxHelmHistory int
 	Namespace      string
+	// Parallelism is the maximum number of steps executed at the same time.
+	// Defaults to 1 (sequential execution).
+	Parallelism int
+	// Locker, if set, makes sure that the releases and objects of the
+	// workflow are not changed by other workflows.
+	Locker *locker.Locker
+	// Budget is the time a reconcile is given, it caps the time expected
+	// for the steps without a timeout when checking the time left.
+	Budget time.Duration
 }
 
 func New(opts Opts) (*Workflow, error) {
@@ -43,36 +70,60 @@ func New(opts Opts) (*Workflow, error) {
 		opts.Log = logging.NewNopLogger()
 	}
 
+	if opts.Parallelism <= 0 {
+		opts.Parallelism = 1
+	}
+
 	wf := &Workflow{
 		logr:       opts.Log.WithValues("namespace", opts.Namespace),
 		ns:         opts.Namespace,
 		env:        cache.New[string, string](),
+		inventory:  cache.New[string, []v1alpha1.ObjectMeta](),
 		maxHistory: ptr.To(opts.MaxHelmHistory),
+		parallel:   opts.Parallelism,
+		helm:       opts.HelmClient,
+		del:        opts.Deletor,
+		dyn:        opts.Getter,
+		lock:       opts.Locker,
+		budget:     opts.Budget,
 	}
 
-	wf.varHandler = varhandler.VarHandler(opts.Getter, wf.env, opts.Log)
-	wf.objectHandler = objecthandler.ObjectHandler(opts.Applier, opts.Deletor, wf.env, opts.Log)
-	wf.chartHandler = charthandler.ChartHandler(charthandler.ChartHandlerOptions{
-		HelmClient: opts.HelmClient,
+	wf.handlers = steps.NewHandlers(steps.HandlerOptions{
 		Env:        wf.env,
+		Inventory:  wf.inventory,
 		Log:        opts.Log,
-		Dyn:        opts.Getter,
+		Getter:     opts.Getter,
+		Applier:    opts.Applier,
+		Deletor:    opts.Deletor,
+		HelmClient: opts.HelmClient,
+		Clientset:  opts.Clientset,
+		Locker:     opts.Locker,
 	})
 
 	return wf, nil
 }
 
 type StepResult[T any] struct {
-	id     string
-	digest string
-	err    error
-	res    T
+	id          string
+	parent      string
+	digest      string
+	phase       v1alpha1.StepPhase
+	err         error
+	res         T
+	undo        steps.Rollback
+	rollbackErr error
 }
 
 func (r *StepResult[T]) ID() string {
 	return r.id
 }
 
+// Parent returns the id of the workflow step the result belongs to,
+// it differs from ID only for the instances of forEach steps.
+func (r *StepResult[T]) Parent() string {
+	return r.parent
+}
+
 func (r *StepResult[T]) Digest() string {
 	return r.digest
 }
@@ -81,6 +132,19 @@ func (r *StepResult[T]) Err() error {
 	return r.err
 }
 
+// Phase reports whether the step was executed, skipped because
+// the skip callback passed to Run returned true (StepUnchanged) or
+// skipped because its when condition is false (StepSkipped).
+func (r *StepResult[T]) Phase() v1alpha1.StepPhase {
+	return r.phase
+}
+
+// RollbackErr reports why the rollback of a step in phase
+// StepRolledBack failed, nil if it succeeded.
+func (r *StepResult[T]) RollbackErr() error {
+	return r.rollbackErr
+}
+
 // Aggiungi questi metodi al StepResult
 
 func (r *StepResult[T]) Result() T {
@@ -98,67 +162,317 @@ func Err[T any](results []StepResult[T]) error {
 }
 
 type Workflow struct {
-	logr          logging.Logger
-	ns            string
-	env           *cache.Cache[string, string]
-	varHandler    steps.Handler[*steps.VarResult]
-	objectHandler steps.Handler[*steps.ObjectResult]
-	chartHandler  steps.Handler[*steps.ChartResult]
-	maxHistory    *int
-	op            steps.Op
+	logr       logging.Logger
+	ns         string
+	env        *cache.Cache[string, string]
+	inventory  *cache.Cache[string, []v1alpha1.ObjectMeta]
+	handlers   map[v1alpha1.StepType]steps.Handler[steps.Result]
+	maxHistory *int
+	parallel   int
+	op         steps.Op
+	rollback   bool
+	dryRun     bool
+	approved   map[string]bool
+	applied    map[string]bool
+	helm       helmclient.Client
+	del        *deletor.Deletor
+	dyn        *getter.Getter
+	lock       *locker.Locker
+	budget     time.Duration
+
+	factsOnce sync.Once
+	facts     map[string]any
+	factsErr  error
 }
 
 func (wf *Workflow) Op(op steps.Op) {
 	wf.op = op
 }
 
+// DryRun switches the workflow to plan mode: the steps report in their
+// results what they would change, without changing anything.
+func (wf *Workflow) DryRun(on bool) {
+	wf.dryRun = on
+}
+
+// handlerFor returns the handler executing the steps of the given type,
+// unwrapped so that its optional interfaces can be checked.
+func (wf *Workflow) handlerFor(t v1alpha1.StepType) any {
+	hdl, ok := wf.handlers[t]
+	if !ok {
+		return nil
+	}
+	return steps.Unwrap(hdl)
+}
+
+// Validate checks that a handler is registered for the type of each step,
+// so that an unknown type is reported before any step runs.
+func (wf *Workflow) Validate(spec *v1alpha1.WorkflowSpec) error {
+	var errs []error
+	for _, x := range spec.Steps {
+		if x.Type == v1alpha1.TypeApproval || x.Type == v1alpha1.TypeInclude {
+			continue
+		}
+		if _, ok := wf.handlers[x.Type]; !ok {
+			errs = append(errs, fmt.Errorf("step %s: handler for step of type %q not found", x.ID, x.Type))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Run executes the workflow steps as a dependency graph: a step starts
+// as soon as all the steps it depends on completed, running up to
+// Opts.Parallelism steps at the same time. On Delete the graph is walked
+// in reverse order. After the first failure no other step is started;
+// with spec.RollbackOnFailure set, the steps completed by the run are
+// then rolled back in reverse order.
+//
+// Results are returned in the order the steps are declared; a forEach
+// step contributes one result for each of its instances.
 func (wf *Workflow) Run(ctx context.Context, spec *v1alpha1.WorkflowSpec, skip func(*v1alpha1.Step) bool) (results []StepResult[any]) {
-	results = make([]StepResult[any], len(spec.Steps))
+	g, err := newGraph(spec.Steps)
+	if err != nil {
+		var ge *GraphError
+		if errors.As(err, &ge) {
+			return []StepResult[any]{{id: ge.StepID, parent: ge.StepID, err: err}}
+		}
+		return []StepResult[any]{{err: err}}
+	}
 
 	if wf.op == steps.Delete {
-		slices.Reverse(spec.Steps)
+		g = g.reverse()
 	}
+	wf.rollback = spec.RollbackOnFailure && !wf.dryRun
 
-	for i, x := range spec.Steps {
-		if skip(x) {
-			wf.logr.Debug(fmt.Sprintf("skipping step with id: %s (%v)", x.ID, x.Type))
+	for t, hdl := range wf.handlers {
+		if p, ok := wf.handlerFor(t).(steps.Planner); ok {
+			p.DryRun(wf.dryRun)
+		}
+		hdl.Namespace(wf.ns)
+		hdl.Op(wf.op)
+	}
+
+	ids := newStepIDs(spec.Steps)
+
+	nodes := make([][]StepResult[any], len(spec.Steps))
+
+	pending := make([]int, len(spec.Steps))
+	ready := []int{}
+	for i := range spec.Steps {
+		pending[i] = len(g.deps[i])
+		if pending[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+
+	done := make(chan int)
+	running := 0
+	failed, suspended := false, true
+	order := make([]int, 0, len(spec.Steps))
+
+	for {
+		for !failed && len(ready) > 0 && running < wf.parallel {
+			i := ready[0]
+			ready = ready[1:]
+
+			running++
+			go func(i int) {
+				nodes[i] = wf.runNode(ctx, spec.Steps[i], ids, skip)
+				done <- i
+			}(i)
+		}
+
+		if running == 0 {
+			break
+		}
+
+		i := <-done
+		running--
+		order = append(order, i)
+
+		if err := Err(nodes[i]); err != nil {
+			failed = true
+			suspended = suspended && isSuspended(err)
 			continue
 		}
 
-		wf.logr.Debug(fmt.Sprintf("executing step with id: %s (%v)", x.ID, x.Type))
+		for _, j := range g.next[i] {
+			pending[j]--
+			if pending[j] == 0 {
+				ready = append(ready, j)
+			}
+		}
+		slices.Sort(ready)
+	}
+
+	if failed && !suspended && wf.rollback {
+		wf.undoCompleted(ctx, nodes, order)
+	}
+
+	for _, x := range nodes {
+		results = append(results, x...)
+	}
+
+	return
+}
+
+// runNode executes a step of the workflow graph, expanding it
+// in one instance for each item when forEach is set. ids are
+// the ids of the workflow steps and of the instances expanded.
+func (wf *Workflow) runNode(ctx context.Context, x *v1alpha1.Step, ids *stepIDs, skip func(*v1alpha1.Step) bool) []StepResult[any] {
+	if x.ForEach == nil {
+		res := StepResult[any]{parent: x.ID}
+		wf.runStep(ctx, x, nil, skip, &res)
+		return []StepResult[any]{res}
+	}
+
+	all, err := wf.forEachInstances(ctx, x, ids)
+	if err != nil {
+		return []StepResult[any]{{id: x.ID, parent: x.ID, err: err}}
+	}
+
+	if wf.op == steps.Delete {
+		slices.Reverse(all)
+	}
+
+	results := make([]StepResult[any], 0, len(all))
+	for _, el := range all {
+		res := StepResult[any]{parent: x.ID}
+		wf.runStep(ctx, el.step, el.locals(), skip, &res)
+		results = append(results, res)
+		if res.err != nil {
+			break
+		}
+	}
+
+	if wf.op == steps.Delete {
+		slices.Reverse(results)
+	}
+
+	return results
+}
+
+// Digest hashes the step configuration together with the current values
+// of the variables it references, so that a step is considered changed
+// also when one of its input variables changed.
+func (wf *Workflow) Digest(x *v1alpha1.Step) string {
+	hasher := murmur3.New64()
+	hasher.Write([]byte(x.Digest()))
+
+	if x.With != nil {
+		for _, k := range expand.Vars(string(x.With.Raw)) {
+			if v, ok := wf.env.Get(k); ok {
+				hasher.Write([]byte(k + "=" + v + "\n"))
+			}
+		}
+	}
+
+	return strconv.FormatUint(hasher.Sum64(), 16)
+}
+
+func (wf *Workflow) runStep(ctx context.Context, x *v1alpha1.Step, locals map[string]any, skip func(*v1alpha1.Step) bool, res *StepResult[any]) {
+	res.id = x.ID
 
-		results[i] = StepResult[any]{id: x.ID}
+	ok, err := wf.evalWhen(ctx, x, locals)
+	if err != nil {
+		res.err = err
+		return
+	}
+	if !ok {
+		wf.logr.Debug(fmt.Sprintf("skipping step with id: %s (%v), condition is false", x.ID, x.Type))
+		res.phase = v1alpha1.StepSkipped
+		return
+	}
 
-		switch x.Type {
-		case v1alpha1.TypeVar:
-			wf.varHandler.Namespace(wf.ns)
-			wf.varHandler.Op(wf.op)
-			result, err := wf.varHandler.Handle(ctx, x.ID, x.With)
-			results[i].res = result
-			results[i].err = err
+	res.digest = wf.Digest(x)
 
-		case v1alpha1.TypeObject:
-			wf.objectHandler.Namespace(wf.ns)
-			wf.objectHandler.Op(wf.op)
-			result, err := wf.objectHandler.Handle(ctx, x.ID, x.With)
-			results[i].res = result
-			results[i].err = err
+	if skip(x) {
+		wf.logr.Debug(fmt.Sprintf("skipping step with id: %s (%v)", x.ID, x.Type))
+		res.phase = v1alpha1.StepUnchanged
+		return
+	}
 
-		case v1alpha1.TypeChart:
-			wf.chartHandler.Namespace(wf.ns)
-			wf.chartHandler.Op(wf.op)
-			result, err := wf.chartHandler.Handle(ctx, x.ID, x.With)
-			results[i].res = result
-			results[i].err = err
+	if x.Type == v1alpha1.TypeApproval {
+		res.err = wf.checkApproval(x)
+		if res.err == nil {
+			res.phase = v1alpha1.StepSucceeded
+		}
+		return
+	}
 
-		default:
-			results[i].err = fmt.Errorf("handler for step of type %q not found", x.Type)
+	// var steps are not run on delete, the env is restored from the last run
+	if wf.op == steps.Delete {
+		if missing := wf.unresolvedVars(x); len(missing) > 0 {
+			if !wf.applied[x.ID] {
+				// i.e. its when condition was false, or a failed run didn't reach it
+				wf.logr.Info(fmt.Sprintf("WARN: skipping step with id: %s (%v), nothing recorded and variables not resolved: %s",
+					x.ID, x.Type, strings.Join(missing, ", ")))
+				res.phase = v1alpha1.StepSkipped
+				return
+			}
+			res.err = steps.Permanent(fmt.Errorf("step %s references variables not resolved by the last run: %s",
+				x.ID, strings.Join(missing, ", ")))
+			return
 		}
+	}
+
+	if x.Type == v1alpha1.TypeInclude {
+		res.err = steps.Permanent(fmt.Errorf("include step %s has not been resolved", x.ID))
+		return
+	}
+
+	hdl, ok := wf.handlers[x.Type]
+	if !ok && wf.op == steps.Delete {
+		// i.e. the handler of a custom step type is no longer compiled in
+		wf.logr.Info(fmt.Sprintf("WARN: skipping step with id: %s (%v), handler not found", x.ID, x.Type))
+		res.phase = v1alpha1.StepSkipped
+		return
+	}
+	if !ok {
+		res.err = steps.Permanent(fmt.Errorf("handler for step of type %q not found", x.Type))
+		return
+	}
+
+	sctx, cancel, err := stepContext(ctx, x, wf.budget)
+	if err != nil {
+		wf.logr.Debug(fmt.Sprintf("not starting step with id: %s (%v): %s", x.ID, x.Type, err.Error()))
+		res.err = err
+		return
+	}
+	defer cancel()
+
+	if _, ok := steps.Unwrap(hdl).(steps.Planner); wf.dryRun && !ok {
+		res.err = steps.Permanent(fmt.Errorf("step of type %q can't run in plan mode", x.Type))
+		return
+	}
 
-		if results[i].err != nil {
+	if wf.op != steps.Delete && !wf.dryRun && (wf.rollback || x.OnFailure == v1alpha1.FailureRollback) {
+		res.undo, err = wf.prepareRollback(sctx, x)
+		if err != nil {
+			res.err = fmt.Errorf("preparing rollback: %w", err)
 			return
 		}
 	}
 
-	return
+	wf.logr.Debug(fmt.Sprintf("executing step with id: %s (%v)", x.ID, x.Type))
+
+	res.err = wf.retry(sctx, x, func() (err error) {
+		var out steps.Result
+		out, err = hdl.Handle(sctx, x.ID, x.With)
+		res.res = out
+		return err
+	})
+
+	if res.err != nil && x.Timeout != nil && errors.Is(sctx.Err(), context.DeadlineExceeded) {
+		res.err = fmt.Errorf("timed out after %s: %w", x.Timeout.Duration, res.err)
+	}
+
+	if res.err != nil {
+		if res.undo != nil {
+			wf.undo(ctx, res)
+		}
+		return
+	}
+
+	res.phase = v1alpha1.StepSucceeded
 }
@@ -24,11 +24,11 @@ import (
 	"sigs.k8s.io/e2e-framework/support/kind"
 
 	"github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
-	"github.com/krateoplatformops/installer/internal/dynamic/applier"
-	"github.com/krateoplatformops/installer/internal/dynamic/deletor"
-	"github.com/krateoplatformops/installer/internal/dynamic/getter"
-	"github.com/krateoplatformops/installer/internal/helmclient"
-	"github.com/krateoplatformops/installer/internal/workflows/steps"
+	"github.com/krateoplatformops/installer/pkg/dynamic/applier"
+	"github.com/krateoplatformops/installer/pkg/dynamic/deletor"
+	"github.com/krateoplatformops/installer/pkg/dynamic/getter"
+	"github.com/krateoplatformops/installer/pkg/helmclient"
+	"github.com/krateoplatformops/installer/pkg/workflows/steps"
 	"github.com/krateoplatformops/provider-runtime/pkg/logging"
 )
 
@@ -52,11 +52,39 @@ type ApplyOptions struct {
 	GVK       schema.GroupVersionKind
 	Namespace string
 	Name      string
+	// DryRun asks the API server to validate and return
+	// the applied object without persisting it.
+	DryRun bool
+}
+
+type PatchOptions struct {
+	GVK       schema.GroupVersionKind
+	Namespace string
+	Name      string
+	// Type is one of types.JSONPatchType, types.MergePatchType
+	// or types.StrategicMergePatchType.
+	Type types.PatchType
+	Data []byte
+	// DryRun asks the API server to validate and return
+	// the patched object without persisting it.
+	DryRun bool
+}
+
+// Reset invalidates the discovered kinds, so that the kinds of the
+// CRDs applied since are found.
+func (a *Applier) Reset() {
+	a.mapper.Reset()
 }
 
 func (a *Applier) Apply(ctx context.Context, content map[string]any, opts ApplyOptions) error {
+	_, err := a.ApplyObject(ctx, content, opts)
+	return err
+}
+
+// ApplyObject is like Apply but returns the object as applied by the API server.
+func (a *Applier) ApplyObject(ctx context.Context, content map[string]any, opts ApplyOptions) (*unstructured.Unstructured, error) {
 	if len(content) == 0 {
-		return nil
+		return nil, nil
 	}
 
 	obj := unstructured.Unstructured{}
@@ -67,7 +95,7 @@ func (a *Applier) Apply(ctx context.Context, content map[string]any, opts ApplyO
 
 	restMapping, err := a.mapper.RESTMapping(opts.GVK.GroupKind(), opts.GVK.Version)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	var ri dynamic.ResourceInterface
@@ -80,14 +108,40 @@ func (a *Applier) Apply(ctx context.Context, content map[string]any, opts ApplyO
 
 	data, err := json.Marshal(&obj)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// create or Update the object with SSA (types.ApplyPatchType indicates SSA).
-	_, err = ri.Patch(ctx, obj.GetName(), types.ApplyPatchType, data, metav1.PatchOptions{
+	po := metav1.PatchOptions{
 		FieldManager: InstalledByValue,
 		Force:        ptr.To(true),
-	})
+	}
+	if opts.DryRun {
+		po.DryRun = []string{metav1.DryRunAll}
+	}
 
-	return err
+	// create or Update the object with SSA (types.ApplyPatchType indicates SSA).
+	return ri.Patch(ctx, obj.GetName(), types.ApplyPatchType, data, po)
+}
+
+// Patch patches an existing object, it returns the object as patched by the API server.
+func (a *Applier) Patch(ctx context.Context, opts PatchOptions) (*unstructured.Unstructured, error) {
+	restMapping, err := a.mapper.RESTMapping(opts.GVK.GroupKind(), opts.GVK.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	var ri dynamic.ResourceInterface
+	if restMapping.Scope.Name() == meta.RESTScopeNameRoot {
+		ri = a.dynamicClient.Resource(restMapping.Resource)
+	} else {
+		ri = a.dynamicClient.Resource(restMapping.Resource).
+			Namespace(opts.Namespace)
+	}
+
+	po := metav1.PatchOptions{FieldManager: InstalledByValue}
+	if opts.DryRun {
+		po.DryRun = []string{metav1.DryRunAll}
+	}
+
+	return ri.Patch(ctx, opts.Name, opts.Type, opts.Data, po)
 }
@@ -0,0 +1,120 @@
+package getter
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	corev1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/discovery"
+	cacheddiscovery "k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+)
+
+type GetOptions struct {
+	GVK       schema.GroupVersionKind
+	Namespace string
+	Name      string
+}
+
+type ListOptions struct {
+	GVK schema.GroupVersionKind
+	// Namespace is ignored for cluster-scoped kinds.
+	Namespace     string
+	LabelSelector string
+}
+
+func NewGetter(rc *rest.Config) (*Getter, error) {
+	dynamicClient, err := dynamic.NewForConfig(rc)
+	if err != nil {
+		return nil, err
+	}
+
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(rc)
+	if err != nil {
+		return nil, err
+	}
+
+	cachedClient := cacheddiscovery.NewMemCacheClient(discoveryClient)
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(cachedClient)
+
+	return &Getter{
+		dynamicClient:   dynamicClient,
+		discoveryClient: cachedClient,
+		mapper:          mapper,
+	}, nil
+}
+
+type Getter struct {
+	dynamicClient   *dynamic.DynamicClient
+	discoveryClient discovery.CachedDiscoveryInterface
+	mapper          *restmapper.DeferredDiscoveryRESTMapper
+}
+
+// ServerVersion returns the version of the API server.
+func (g *Getter) ServerVersion() (*version.Info, error) {
+	return g.discoveryClient.ServerVersion()
+}
+
+// ServerGroupVersions returns the group versions served by the API server
+// (i.e. "v1", "apps/v1").
+func (g *Getter) ServerGroupVersions() ([]string, error) {
+	groups, err := g.discoveryClient.ServerGroups()
+	if err != nil {
+		return nil, err
+	}
+
+	res := []string{}
+	for _, grp := range groups.Groups {
+		for _, v := range grp.Versions {
+			res = append(res, v.GroupVersion)
+		}
+	}
+
+	return res, nil
+}
+
+// Reset invalidates the discovered kinds, so that the kinds of the
+// CRDs applied since are found.
+func (g *Getter) Reset() {
+	g.mapper.Reset()
+}
+
+func (g *Getter) Get(ctx context.Context, opts GetOptions) (*unstructured.Unstructured, error) {
+	restMapping, err := g.mapper.RESTMapping(opts.GVK.GroupKind(), opts.GVK.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	var ri dynamic.ResourceInterface
+	if restMapping.Scope.Name() == meta.RESTScopeNameRoot {
+		ri = g.dynamicClient.Resource(restMapping.Resource)
+	} else {
+		ri = g.dynamicClient.Resource(restMapping.Resource).
+			Namespace(opts.Namespace)
+	}
+
+	return ri.Get(ctx, opts.Name, corev1.GetOptions{})
+}
+
+// List returns the objects of a kind matching the label selector.
+func (g *Getter) List(ctx context.Context, opts ListOptions) (*unstructured.UnstructuredList, error) {
+	restMapping, err := g.mapper.RESTMapping(opts.GVK.GroupKind(), opts.GVK.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	var ri dynamic.ResourceInterface
+	if restMapping.Scope.Name() == meta.RESTScopeNameRoot {
+		ri = g.dynamicClient.Resource(restMapping.Resource)
+	} else {
+		ri = g.dynamicClient.Resource(restMapping.Resource).
+			Namespace(opts.Namespace)
+	}
+
+	return ri.List(ctx, corev1.ListOptions{LabelSelector: opts.LabelSelector})
+}
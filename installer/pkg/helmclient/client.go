@@ -266,7 +266,12 @@ func (c *HelmClient) GetRelease(name string) (*release.Release, error) {
 
 // RollbackRelease implicitly rolls back a release to the last revision.
 func (c *HelmClient) RollbackRelease(spec *ChartSpec) error {
-	return c.rollbackRelease(spec)
+	return c.rollbackRelease(spec, 0)
+}
+
+// RollbackReleaseTo rolls back a release to the specified revision.
+func (c *HelmClient) RollbackReleaseTo(spec *ChartSpec, revision int) error {
+	return c.rollbackRelease(spec, revision)
 }
 
 // UninstallRelease uninstalls the provided release
@@ -931,11 +936,13 @@ func (c *HelmClient) getRelease(name string) (*release.Release, error) {
 	return getReleaseClient.Run(name)
 }
 
-// rollbackRelease implicitly rolls back a release to the last revision.
-func (c *HelmClient) rollbackRelease(spec *ChartSpec) error {
+// rollbackRelease rolls back a release to the given revision,
+// a zero revision means the last one.
+func (c *HelmClient) rollbackRelease(spec *ChartSpec, revision int) error {
 	client := action.NewRollback(c.ActionConfig)
 
 	mergeRollbackOptions(spec, client)
+	client.Version = revision
 
 	return client.Run(spec.ReleaseName)
 }
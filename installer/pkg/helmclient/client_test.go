@@ -4,7 +4,7 @@ import (
 	"bytes"
 	"context"
 
-	"github.com/krateoplatformops/installer/internal/helmclient/values"
+	"github.com/krateoplatformops/installer/pkg/helmclient/values"
 	"helm.sh/helm/v3/pkg/chartutil"
 
 	"helm.sh/helm/v3/pkg/action"
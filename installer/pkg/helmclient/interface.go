@@ -22,6 +22,7 @@ type Client interface {
 	GetRelease(name string) (*release.Release, error)
 	// RollBack is an interface to abstract a rollback action.
 	RollBack
+	RollbackReleaseTo(spec *ChartSpec, revision int) error
 	GetReleaseValues(name string, allValues bool) (map[string]interface{}, error)
 	UninstallRelease(spec *ChartSpec) error
 	UninstallReleaseByName(name string) error
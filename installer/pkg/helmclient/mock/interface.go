@@ -9,7 +9,7 @@ import (
 	reflect "reflect"
 
 	gomock "github.com/golang/mock/gomock"
-	helmclient "github.com/krateoplatformops/installer/internal/helmclient"
+	helmclient "github.com/krateoplatformops/installer/pkg/helmclient"
 	action "helm.sh/helm/v3/pkg/action"
 	chart "helm.sh/helm/v3/pkg/chart"
 	release "helm.sh/helm/v3/pkg/release"
@@ -202,6 +202,20 @@ func (mr *MockClientMockRecorder) RollbackRelease(spec interface{}) *gomock.Call
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RollbackRelease", reflect.TypeOf((*MockClient)(nil).RollbackRelease), spec)
 }
 
+// RollbackReleaseTo mocks base method.
+func (m *MockClient) RollbackReleaseTo(spec *helmclient.ChartSpec, revision int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RollbackReleaseTo", spec, revision)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RollbackReleaseTo indicates an expected call of RollbackReleaseTo.
+func (mr *MockClientMockRecorder) RollbackReleaseTo(spec, revision interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RollbackReleaseTo", reflect.TypeOf((*MockClient)(nil).RollbackReleaseTo), spec, revision)
+}
+
 // SetDebugLog mocks base method.
 func (m *MockClient) SetDebugLog(debugLog action.DebugLog) {
 	m.ctrl.T.Helper()
@@ -5,7 +5,7 @@ import (
 	"helm.sh/helm/v3/pkg/getter"
 	"sigs.k8s.io/yaml"
 
-	"github.com/krateoplatformops/installer/internal/helmclient/values"
+	"github.com/krateoplatformops/installer/pkg/helmclient/values"
 )
 
 // GetValuesMap returns the merged mapped out values of a chart,
@@ -0,0 +1,239 @@
+// Package locker makes sure that a helm release or an object is changed by
+// one KrateoPlatformOps only, recording its owner in a coordination.k8s.io
+// Lease.
+package locker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/twmb/murmur3"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	coordinationclient "k8s.io/client-go/kubernetes/typed/coordination/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	// AnnotationKey records on the lease the release or object it locks.
+	AnnotationKey = "krateo.io/lock-key"
+	// LabelManagedBy marks the leases created by the installer.
+	LabelManagedBy = "app.kubernetes.io/managed-by"
+
+	managedBy  = "krateo-installer"
+	namePrefix = "krateo-lock-"
+	// maxAttempts bounds the retries when another holder changes the lease
+	// between the get and the create or update.
+	maxAttempts = 3
+)
+
+// ConflictError reports that the release or object is owned by
+// another KrateoPlatformOps.
+type ConflictError struct {
+	Key   string
+	Owner string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s is owned by KrateoPlatformOps %s", e.Key, e.Owner)
+}
+
+type Options struct {
+	// Namespace is where the leases are created.
+	Namespace string
+	// Holder identifies the workflow acquiring the locks,
+	// as namespace/name of its KrateoPlatformOps.
+	Holder string
+	// Exists reports whether the holder of a lease still exists, the leases
+	// of the holders gone are taken over. If nil they are never taken over.
+	Exists func(ctx context.Context, holder string) (bool, error)
+}
+
+func NewLocker(rc *rest.Config, opts Options) (*Locker, error) {
+	cs, err := kubernetes.NewForConfig(rc)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Locker{
+		leases: cs.CoordinationV1(),
+		ns:     opts.Namespace,
+		holder: opts.Holder,
+		exists: opts.Exists,
+	}, nil
+}
+
+// Locker acquires the leases of a workflow. A nil Locker acquires nothing.
+type Locker struct {
+	leases coordinationclient.LeasesGetter
+	ns     string
+	holder string
+	exists func(ctx context.Context, holder string) (bool, error)
+}
+
+// ReleaseKey returns the key locking the helm release name in namespace.
+func ReleaseKey(namespace, name string) string {
+	return fmt.Sprintf("release %s/%s", namespace, name)
+}
+
+// ObjectKey returns the key locking an object, the same for all the
+// versions of its kind.
+func ObjectKey(gvk schema.GroupVersionKind, namespace, name string) string {
+	if len(namespace) == 0 {
+		return fmt.Sprintf("%s %s", gvk.GroupKind(), name)
+	}
+	return fmt.Sprintf("%s %s/%s", gvk.GroupKind(), namespace, name)
+}
+
+// LeaseName returns the name of the lease locking key.
+func LeaseName(key string) string {
+	hasher := murmur3.New64()
+	hasher.Write([]byte(key))
+	return fmt.Sprintf("%s%016x", namePrefix, hasher.Sum64())
+}
+
+// Acquire makes the holder the owner of key. It fails with a ConflictError
+// if key is owned by another KrateoPlatformOps that still exists.
+func (l *Locker) Acquire(ctx context.Context, key string) error {
+	if l == nil {
+		return nil
+	}
+
+	var err error
+	for range maxAttempts {
+		err = l.acquire(ctx, key)
+		if !apierrors.IsConflict(err) && !apierrors.IsAlreadyExists(err) {
+			return err
+		}
+	}
+
+	return fmt.Errorf("failed to acquire lease for %s: %w", key, err)
+}
+
+func (l *Locker) acquire(ctx context.Context, key string) error {
+	cli := l.leases.Leases(l.ns)
+	now := metav1.NewMicroTime(time.Now())
+
+	lease, err := cli.Get(ctx, LeaseName(key), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = cli.Create(ctx, &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        LeaseName(key),
+				Namespace:   l.ns,
+				Labels:      map[string]string{LabelManagedBy: managedBy},
+				Annotations: map[string]string{AnnotationKey: key},
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity: ptr.To(l.holder),
+				AcquireTime:    &now,
+				RenewTime:      &now,
+			},
+		}, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if ptr.Deref(lease.Spec.HolderIdentity, "") == l.holder {
+		return nil
+	}
+	if err := l.check(ctx, key, lease); err != nil {
+		return err
+	}
+
+	lease.Spec.HolderIdentity = ptr.To(l.holder)
+	lease.Spec.AcquireTime = &now
+	lease.Spec.RenewTime = &now
+	lease.Spec.LeaseTransitions = ptr.To(ptr.Deref(lease.Spec.LeaseTransitions, 0) + 1)
+
+	_, err = cli.Update(ctx, lease, metav1.UpdateOptions{})
+	return err
+}
+
+// Check fails with a ConflictError if key is owned by another
+// KrateoPlatformOps that still exists, without acquiring it.
+func (l *Locker) Check(ctx context.Context, key string) error {
+	if l == nil {
+		return nil
+	}
+
+	lease, err := l.leases.Leases(l.ns).Get(ctx, LeaseName(key), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return l.check(ctx, key, lease)
+}
+
+// check tells if the lease can be held by the holder: it is not held,
+// held by the holder or by a KrateoPlatformOps gone.
+func (l *Locker) check(ctx context.Context, key string, lease *coordinationv1.Lease) error {
+	owner := ptr.Deref(lease.Spec.HolderIdentity, "")
+	if len(owner) == 0 || owner == l.holder {
+		return nil
+	}
+
+	if l.exists == nil {
+		return &ConflictError{Key: key, Owner: owner}
+	}
+	ok, err := l.exists(ctx, owner)
+	if err != nil {
+		return fmt.Errorf("failed to check owner %s of %s: %w", owner, key, err)
+	}
+	if ok {
+		return &ConflictError{Key: key, Owner: owner}
+	}
+	return nil
+}
+
+// Release gives up the ownership of key, once the release or the object
+// has been deleted. Leases owned by others are left as they are.
+func (l *Locker) Release(ctx context.Context, key string) error {
+	if l == nil {
+		return nil
+	}
+
+	cli := l.leases.Leases(l.ns)
+
+	lease, err := cli.Get(ctx, LeaseName(key), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if ptr.Deref(lease.Spec.HolderIdentity, "") != l.holder {
+		return nil
+	}
+
+	err = cli.Delete(ctx, lease.Name, metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{ResourceVersion: ptr.To(lease.ResourceVersion)},
+	})
+	if apierrors.IsNotFound(err) || apierrors.IsConflict(err) {
+		return nil
+	}
+	return err
+}
+
+// IsConflict reports whether err is, or wraps, a ConflictError.
+func IsConflict(err error) bool {
+	var ce *ConflictError
+	return errors.As(err, &ce)
+}
+
+// Holder returns the holder identity of a KrateoPlatformOps.
+func Holder(namespace, name string) string {
+	return strings.Join([]string{namespace, name}, "/")
+}
@@ -0,0 +1,220 @@
+package locker
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/ptr"
+)
+
+func newTestLocker(cs *fake.Clientset, holder string, alive map[string]bool) *Locker {
+	return &Locker{
+		leases: cs.CoordinationV1(),
+		ns:     "krateo-system",
+		holder: holder,
+		exists: func(_ context.Context, holder string) (bool, error) {
+			return alive[holder], nil
+		},
+	}
+}
+
+func TestAcquire(t *testing.T) {
+	ctx := context.Background()
+	cs := fake.NewClientset()
+	alive := map[string]bool{"demo/a": true, "demo/b": true}
+
+	a := newTestLocker(cs, "demo/a", alive)
+	b := newTestLocker(cs, "demo/b", alive)
+
+	key := ReleaseKey("demo", "postgres")
+	if err := a.Acquire(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	// acquiring again is a no-op
+	if err := a.Acquire(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+
+	err := b.Acquire(ctx, key)
+	if !IsConflict(err) {
+		t.Fatalf("expected a conflict, got: %v", err)
+	}
+	if exp := "release demo/postgres is owned by KrateoPlatformOps demo/a"; err.Error() != exp {
+		t.Fatalf("got %q, expected %q", err.Error(), exp)
+	}
+
+	// other keys are not affected
+	if err := b.Acquire(ctx, ReleaseKey("demo", "redis")); err != nil {
+		t.Fatal(err)
+	}
+
+	lease, err := cs.CoordinationV1().Leases("krateo-system").Get(ctx, LeaseName(key), metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := lease.Annotations[AnnotationKey]; got != key {
+		t.Fatalf("got key annotation %q, expected %q", got, key)
+	}
+	if got := ptr.Deref(lease.Spec.HolderIdentity, ""); got != "demo/a" {
+		t.Fatalf("got holder %q, expected demo/a", got)
+	}
+}
+
+func TestAcquireTakeOver(t *testing.T) {
+	ctx := context.Background()
+	cs := fake.NewClientset()
+	alive := map[string]bool{"demo/a": true, "demo/b": true}
+
+	a := newTestLocker(cs, "demo/a", alive)
+	b := newTestLocker(cs, "demo/b", alive)
+
+	key := ObjectKey(schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}, "", "krateo")
+	if err := a.Acquire(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+
+	alive["demo/a"] = false
+	if err := b.Acquire(ctx, key); err != nil {
+		t.Fatalf("expected the lease of a deleted owner to be taken over, got: %v", err)
+	}
+
+	lease, err := cs.CoordinationV1().Leases("krateo-system").Get(ctx, LeaseName(key), metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ptr.Deref(lease.Spec.HolderIdentity, ""); got != "demo/b" {
+		t.Fatalf("got holder %q, expected demo/b", got)
+	}
+	if got := ptr.Deref(lease.Spec.LeaseTransitions, 0); got != 1 {
+		t.Fatalf("got %d transitions, expected 1", got)
+	}
+}
+
+func TestAcquireExistsError(t *testing.T) {
+	ctx := context.Background()
+	cs := fake.NewClientset()
+
+	a := newTestLocker(cs, "demo/a", nil)
+	if err := a.Acquire(ctx, "x"); err != nil {
+		t.Fatal(err)
+	}
+
+	b := newTestLocker(cs, "demo/b", nil)
+	b.exists = func(context.Context, string) (bool, error) {
+		return false, fmt.Errorf("boom")
+	}
+	if err := b.Acquire(ctx, "x"); err == nil || IsConflict(err) {
+		t.Fatalf("expected the error checking the owner, got: %v", err)
+	}
+}
+
+func TestRelease(t *testing.T) {
+	ctx := context.Background()
+	cs := fake.NewClientset()
+	alive := map[string]bool{"demo/a": true, "demo/b": true}
+
+	a := newTestLocker(cs, "demo/a", alive)
+	b := newTestLocker(cs, "demo/b", alive)
+
+	key := ReleaseKey("demo", "postgres")
+	if err := a.Acquire(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+
+	// not the owner, the lease is kept
+	if err := b.Release(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Acquire(ctx, key); !IsConflict(err) {
+		t.Fatalf("expected a conflict, got: %v", err)
+	}
+
+	if err := a.Release(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	_, err := cs.CoordinationV1().Leases("krateo-system").Get(ctx, LeaseName(key), metav1.GetOptions{})
+	if !apierrors.IsNotFound(err) {
+		t.Fatalf("expected the lease to be deleted, got: %v", err)
+	}
+
+	// releasing a key not locked is a no-op
+	if err := a.Release(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Acquire(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	ctx := context.Background()
+	cs := fake.NewClientset()
+	alive := map[string]bool{"demo/a": true, "demo/b": true}
+
+	a := newTestLocker(cs, "demo/a", alive)
+	b := newTestLocker(cs, "demo/b", alive)
+
+	key := ObjectKey(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, "demo", "settings")
+	if err := b.Check(ctx, key); err != nil {
+		t.Fatalf("expected no conflict for a key not locked, got: %v", err)
+	}
+
+	if err := a.Acquire(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Check(ctx, key); err != nil {
+		t.Fatalf("expected no conflict for the holder, got: %v", err)
+	}
+	if err := b.Check(ctx, key); !IsConflict(err) {
+		t.Fatalf("expected a conflict, got: %v", err)
+	}
+
+	// the lease is not acquired by a check
+	alive["demo/a"] = false
+	if err := b.Check(ctx, key); err != nil {
+		t.Fatalf("expected no conflict once the holder is gone, got: %v", err)
+	}
+	lease, err := cs.CoordinationV1().Leases("krateo-system").Get(ctx, LeaseName(key), metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ptr.Deref(lease.Spec.HolderIdentity, ""); got != "demo/a" {
+		t.Fatalf("got holder %q, expected demo/a", got)
+	}
+}
+
+func TestNilLocker(t *testing.T) {
+	var l *Locker
+	if err := l.Acquire(context.Background(), "x"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Release(context.Background(), "x"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Check(context.Background(), "x"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestObjectKey(t *testing.T) {
+	tests := []struct {
+		gvk       schema.GroupVersionKind
+		namespace string
+		exp       string
+	}{
+		{schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, "demo", "Deployment.apps demo/x"},
+		{schema.GroupVersionKind{Group: "apps", Version: "v1beta1", Kind: "Deployment"}, "demo", "Deployment.apps demo/x"},
+		{schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}, "", "Namespace x"},
+	}
+
+	for _, tc := range tests {
+		if got := ObjectKey(tc.gvk, tc.namespace, "x"); got != tc.exp {
+			t.Errorf("got %q, expected %q", got, tc.exp)
+		}
+	}
+}
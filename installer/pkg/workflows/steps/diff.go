@@ -0,0 +1,152 @@
+package steps
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/releaseutil"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+// ResourceName formats the identity of an object for reports,
+// i.e. "ConfigMap default/demo" or "Namespace demo".
+func ResourceName(kind, namespace, name string) string {
+	if len(namespace) == 0 {
+		return fmt.Sprintf("%s %s", kind, name)
+	}
+	return fmt.Sprintf("%s %s/%s", kind, namespace, name)
+}
+
+// WithoutServerFields strips from an object the fields maintained by the API server.
+func WithoutServerFields(obj map[string]any) map[string]any {
+	res := runtime.DeepCopyJSON(obj)
+	delete(res, "status")
+	if md, ok := res["metadata"].(map[string]any); ok {
+		for _, k := range []string{"managedFields", "resourceVersion", "generation", "uid", "creationTimestamp"} {
+			delete(md, k)
+		}
+	}
+	return res
+}
+
+// DiffPaths lists the paths of the fields added (+), removed (-) or
+// changed (~) from a to b, sorted by path. Lists are compared as a whole.
+func DiffPaths(a, b map[string]any) []string {
+	all := []string{}
+	diffPaths("", a, b, &all)
+
+	slices.SortFunc(all, func(x, y string) int {
+		return strings.Compare(x[2:], y[2:])
+	})
+
+	return all
+}
+
+func diffPaths(prefix string, a, b map[string]any, out *[]string) {
+	for k, av := range a {
+		p := k
+		if len(prefix) > 0 {
+			p = prefix + "." + k
+		}
+
+		bv, ok := b[k]
+		if !ok {
+			*out = append(*out, "- "+p)
+			continue
+		}
+
+		am, aok := av.(map[string]any)
+		bm, bok := bv.(map[string]any)
+		if aok && bok {
+			diffPaths(p, am, bm, out)
+			continue
+		}
+
+		if !equalJSON(av, bv) {
+			*out = append(*out, "~ "+p)
+		}
+	}
+
+	for k := range b {
+		if _, ok := a[k]; ok {
+			continue
+		}
+		if len(prefix) > 0 {
+			k = prefix + "." + k
+		}
+		*out = append(*out, "+ "+k)
+	}
+}
+
+// equalJSON compares two values by their JSON encoding, so that
+// numbers decoded with different types compare equal.
+func equalJSON(a, b any) bool {
+	x, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	y, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(x, y)
+}
+
+// DiffManifests lists the objects added (+), removed (-) or changed (~)
+// from the multi-document YAML manifest a to b, sorted by identity.
+func DiffManifests(a, b string) ([]string, error) {
+	ao, err := manifestObjects(a)
+	if err != nil {
+		return nil, err
+	}
+	bo, err := manifestObjects(b)
+	if err != nil {
+		return nil, err
+	}
+
+	all := []string{}
+	for k, x := range ao {
+		y, ok := bo[k]
+		if !ok {
+			all = append(all, "- "+k)
+			continue
+		}
+		if !equalJSON(x, y) {
+			all = append(all, "~ "+k)
+		}
+	}
+	for k := range bo {
+		if _, ok := ao[k]; !ok {
+			all = append(all, "+ "+k)
+		}
+	}
+
+	slices.SortFunc(all, func(x, y string) int {
+		return strings.Compare(x[2:], y[2:])
+	})
+
+	return all, nil
+}
+
+func manifestObjects(manifest string) (map[string]map[string]any, error) {
+	all := map[string]map[string]any{}
+	for _, doc := range releaseutil.SplitManifests(manifest) {
+		obj := map[string]any{}
+		if err := yaml.Unmarshal([]byte(doc), &obj); err != nil {
+			return nil, err
+		}
+		if len(obj) == 0 {
+			continue
+		}
+
+		u := unstructured.Unstructured{Object: obj}
+		all[ResourceName(u.GetKind(), u.GetNamespace(), u.GetName())] = obj
+	}
+
+	return all, nil
+}
@@ -0,0 +1,69 @@
+package steps
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDiffPaths(t *testing.T) {
+	a := map[string]any{
+		"replicas": float64(1),
+		"image":    map[string]any{"tag": "1.0", "pullPolicy": "Always"},
+		"ports":    []any{80},
+	}
+	b := map[string]any{
+		"replicas": int64(1),
+		"image":    map[string]any{"tag": "1.1"},
+		"ports":    []any{80, 443},
+		"debug":    true,
+	}
+
+	got := fmt.Sprint(DiffPaths(a, b))
+	want := "[+ debug - image.pullPolicy ~ image.tag ~ ports]"
+	if got != want {
+		t.Fatalf("got: %s, expected: %s", got, want)
+	}
+}
+
+func TestDiffManifests(t *testing.T) {
+	a := `---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: one
+  namespace: demo
+data:
+  key: a
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: gone
+  namespace: demo
+`
+	b := `---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: one
+  namespace: demo
+data:
+  key: b
+---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: demo
+`
+
+	all, err := DiffManifests(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := fmt.Sprint(all)
+	want := "[~ ConfigMap demo/one + Namespace demo - Secret demo/gone]"
+	if got != want {
+		t.Fatalf("got: %s, expected: %s", got, want)
+	}
+}
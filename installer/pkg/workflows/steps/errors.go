@@ -0,0 +1,94 @@
+package steps
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/repo"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// PermanentError wraps a step failure that retrying won't fix,
+// i.e. invalid input or a chart version that doesn't exist.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent marks err as a permanent failure. It returns nil if err is nil.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var pe *PermanentError
+	if errors.As(err, &pe) {
+		return err
+	}
+
+	return &PermanentError{Err: err}
+}
+
+// IsPermanent reports whether err is a permanent failure: either it has
+// been marked as such by a handler or it is a well known error caused by
+// invalid input. Any other error is considered transient.
+func IsPermanent(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var pe *PermanentError
+	if errors.As(err, &pe) {
+		return true
+	}
+
+	var (
+		syntaxErr *json.SyntaxError
+		typeErr   *json.UnmarshalTypeError
+	)
+	if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+		return true
+	}
+
+	if errors.Is(err, repo.ErrNoChartVersion) {
+		return true
+	}
+
+	switch {
+	case apierrors.IsInvalid(err),
+		apierrors.IsBadRequest(err),
+		apierrors.IsMethodNotSupported(err),
+		apierrors.IsNotAcceptable(err),
+		apierrors.IsUnsupportedMediaType(err),
+		apierrors.IsRequestEntityTooLargeError(err):
+		return true
+	}
+
+	// helm reports these as plain strings
+	msg := err.Error()
+	for _, s := range permanentMessages {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+var permanentMessages = []string{
+	"no chart version found",
+	"could not locate a version matching provided version string",
+	"failed parsing --set",
+	"is not a valid Repo ref",
+	"is not a valid OCI ref",
+	"is not a valid .tgz ref",
+	"no handler found for url",
+}
@@ -0,0 +1,41 @@
+package steps
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/repo"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestIsPermanent(t *testing.T) {
+	gr := schema.GroupResource{Resource: "configmaps"}
+
+	table := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errors.New("connection refused"), false},
+		{Permanent(errors.New("boom")), true},
+		{fmt.Errorf("step a: %w", Permanent(errors.New("boom"))), true},
+		{json.Unmarshal([]byte("{"), &map[string]any{}), true},
+		{fmt.Errorf("failed to get chart: %w", repo.ErrNoChartVersion), true},
+		{errors.New("no chart version found for postgresql-99.0.0"), true},
+		{apierrors.NewBadRequest("bad"), true},
+		{apierrors.NewInvalid(schema.GroupKind{Kind: "ConfigMap"}, "x", nil), true},
+		{apierrors.NewConflict(gr, "x", errors.New("conflict")), false},
+		{apierrors.NewTooManyRequests("slow down", 1), false},
+		{apierrors.NewServiceUnavailable("unavailable"), false},
+		{apierrors.NewNotFound(gr, "x"), false},
+	}
+
+	for i, tc := range table {
+		if got := IsPermanent(tc.err); got != tc.want {
+			t.Fatalf("[tc: %d] - got: %v, expected: %v (%v)", i, got, tc.want, tc.err)
+		}
+	}
+}
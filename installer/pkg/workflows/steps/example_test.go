@@ -0,0 +1,85 @@
+package steps_test
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+	"github.com/krateoplatformops/installer/pkg/cache"
+	"github.com/krateoplatformops/installer/pkg/dynamic/getter"
+	"github.com/krateoplatformops/installer/pkg/workflows/steps"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// configMapKeyHandler is a step type defined outside the installer, it
+// sets a variable from a key of a ConfigMap. It only uses the exported
+// handler API, as an out-of-tree handler would.
+type configMapKeyHandler struct {
+	get func(ctx context.Context, opts getter.GetOptions) (*unstructured.Unstructured, error)
+	env *cache.Cache[string, string]
+	ns  string
+}
+
+func (h *configMapKeyHandler) Namespace(ns string) { h.ns = ns }
+
+func (h *configMapKeyHandler) Op(steps.Op) {}
+
+func (h *configMapKeyHandler) Handle(ctx context.Context, id string, ext *runtime.RawExtension) (*steps.VarResult, error) {
+	spec := struct {
+		Name string `json:"name"`
+		Key  string `json:"key"`
+	}{}
+	if err := json.Unmarshal(ext.Raw, &spec); err != nil {
+		return nil, steps.Permanent(err)
+	}
+
+	obj, err := h.get(ctx, getter.GetOptions{
+		GVK:       schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"},
+		Namespace: h.ns,
+		Name:      spec.Name,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	val, _, err := unstructured.NestedString(obj.Object, "data", spec.Key)
+	if err != nil {
+		return nil, err
+	}
+	h.env.Set(id, val)
+
+	return &steps.VarResult{Name: id, Value: val}, nil
+}
+
+func TestRegisterOutOfTree(t *testing.T) {
+	const typ v1alpha1.StepType = "configMapKey"
+
+	steps.Register(typ, func(opts steps.HandlerOptions) steps.Handler[steps.Result] {
+		return steps.Adapt(&configMapKeyHandler{get: opts.Getter.Get, env: opts.Env})
+	})
+
+	if !slices.Contains(steps.Types(), typ) {
+		t.Fatalf("expected %q in %v", typ, steps.Types())
+	}
+
+	env := cache.New[string, string]()
+	hdl := steps.NewHandlers(steps.HandlerOptions{Env: env})[typ]
+	hdl.Namespace("krateo-system")
+	steps.Unwrap(hdl).(*configMapKeyHandler).get = func(_ context.Context, opts getter.GetOptions) (*unstructured.Unstructured, error) {
+		return &unstructured.Unstructured{Object: map[string]any{
+			"data": map[string]any{"url": "https://" + opts.Name + "." + opts.Namespace},
+		}}, nil
+	}
+
+	_, err := hdl.Handle(context.Background(), "PORTAL_URL", &runtime.RawExtension{Raw: []byte(`{"name": "portal", "key": "url"}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := env.Get("PORTAL_URL"); v != "https://portal.krateo-system" {
+		t.Fatalf("unexpected PORTAL_URL: %s", v)
+	}
+}
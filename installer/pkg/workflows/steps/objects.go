@@ -0,0 +1,292 @@
+package steps
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+	"github.com/krateoplatformops/installer/pkg/dynamic/applier"
+	"github.com/krateoplatformops/installer/pkg/dynamic/deletor"
+	"github.com/krateoplatformops/installer/pkg/dynamic/getter"
+	"github.com/krateoplatformops/installer/pkg/locker"
+	rtv1 "github.com/krateoplatformops/provider-runtime/apis/common/v1"
+	"github.com/krateoplatformops/provider-runtime/pkg/logging"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// crdTimeout bounds the wait for the CRDs applied to be established.
+const crdTimeout = time.Minute
+
+// crdInterval is the interval between two checks of the CRDs applied,
+// a variable to be shortened by the tests.
+var crdInterval = time.Second
+
+// Objects applies and deletes the objects of a set, i.e. the documents
+// of a manifest, locking each of them. Lock can be nil.
+type Objects struct {
+	Apply  func(ctx context.Context, content map[string]any, opts applier.ApplyOptions) (*unstructured.Unstructured, error)
+	Get    func(ctx context.Context, opts getter.GetOptions) (*unstructured.Unstructured, error)
+	Delete func(ctx context.Context, opts deletor.DeleteOptions) error
+	// Reset invalidates the kinds discovered by Apply and Get, once
+	// the CRDs of the set are established.
+	Reset func()
+	Lock  *locker.Locker
+	Log   logging.Logger
+}
+
+// ApplyAll applies the objects in the order used by helm, Namespaces
+// and CRDs first. The CRDs are established before the objects after
+// them are applied, so that a set can ship CRDs and their resources.
+// It returns the objects applied, to be recorded in the status, even
+// on failure.
+func (r *Objects) ApplyAll(ctx context.Context, all []unstructured.Unstructured) ([]v1alpha1.ObjectMeta, error) {
+	sortByKind(all, releaseutil.InstallOrder)
+
+	applied := []v1alpha1.ObjectMeta{}
+	crds := []unstructured.Unstructured{}
+	for _, x := range all {
+		if len(crds) > 0 && !isCRD(&x) {
+			if err := r.establish(ctx, crds); err != nil {
+				return applied, err
+			}
+			crds = nil
+		}
+
+		gvk := x.GroupVersionKind()
+		if err := r.Lock.Acquire(ctx, locker.ObjectKey(gvk, x.GetNamespace(), x.GetName())); err != nil {
+			return applied, err
+		}
+
+		opts := applier.ApplyOptions{
+			GVK:       gvk,
+			Namespace: x.GetNamespace(),
+			Name:      x.GetName(),
+		}
+		_, err := r.Apply(ctx, x.Object, opts)
+		if meta.IsNoMatchError(err) && r.Reset != nil {
+			// the kind may have been installed since it was discovered
+			r.Reset()
+			_, err = r.Apply(ctx, x.Object, opts)
+		}
+		if err != nil {
+			return applied, fmt.Errorf("applying %s: %w",
+				ResourceName(x.GetKind(), x.GetNamespace(), x.GetName()), err)
+		}
+
+		applied = append(applied, v1alpha1.ObjectMeta{
+			APIVersion: x.GetAPIVersion(),
+			Kind:       x.GetKind(),
+			Metadata:   rtv1.Reference{Name: x.GetName(), Namespace: x.GetNamespace()},
+		})
+		if isCRD(&x) {
+			crds = append(crds, x)
+		}
+	}
+
+	return applied, nil
+}
+
+// establish waits for the CRDs to be established, then resets the
+// discovered kinds so that their resources can be applied.
+func (r *Objects) establish(ctx context.Context, crds []unstructured.Unstructured) error {
+	for _, x := range crds {
+		var last error
+		err := wait.PollUntilContextTimeout(ctx, crdInterval, crdTimeout, true, func(ctx context.Context) (bool, error) {
+			cur, err := r.Get(ctx, getter.GetOptions{GVK: x.GroupVersionKind(), Name: x.GetName()})
+			if err != nil {
+				last = err
+				return false, nil
+			}
+			return isEstablished(cur), nil
+		})
+		if err != nil {
+			if last != nil {
+				err = last
+			}
+			return fmt.Errorf("waiting for %s to be established: %w",
+				ResourceName(x.GetKind(), "", x.GetName()), err)
+		}
+	}
+
+	if r.Reset != nil {
+		r.Reset()
+	}
+	return nil
+}
+
+func isCRD(obj *unstructured.Unstructured) bool {
+	return obj.GetKind() == "CustomResourceDefinition" &&
+		obj.GroupVersionKind().Group == "apiextensions.k8s.io"
+}
+
+func isEstablished(crd *unstructured.Unstructured) bool {
+	all, _, _ := unstructured.NestedSlice(crd.Object, "status", "conditions")
+	for _, x := range all {
+		cond, ok := x.(map[string]any)
+		if ok && cond["type"] == "Established" && cond["status"] == "True" {
+			return true
+		}
+	}
+	return false
+}
+
+// DeleteAll deletes the objects in the reverse order of ApplyAll,
+// skipping the ones locked by another KrateoPlatformOps.
+func (r *Objects) DeleteAll(ctx context.Context, all []unstructured.Unstructured) error {
+	sortByKind(all, releaseutil.UninstallOrder)
+
+	for _, x := range all {
+		gvk := x.GroupVersionKind()
+		key := locker.ObjectKey(gvk, x.GetNamespace(), x.GetName())
+
+		// the object now belongs to another KrateoPlatformOps
+		if err := r.Lock.Acquire(ctx, key); locker.IsConflict(err) {
+			r.Log.Info(fmt.Sprintf("WARN: not deleting, %s", err.Error()))
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		err := r.Delete(ctx, deletor.DeleteOptions{
+			GVK:       gvk,
+			Namespace: x.GetNamespace(),
+			Name:      x.GetName(),
+		})
+		if err != nil && !apierrors.IsNotFound(err) && !meta.IsNoMatchError(err) {
+			return err
+		}
+		if err := r.Lock.Release(ctx, key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DeleteRecorded deletes the objects recorded in the status, as DeleteAll.
+func (r *Objects) DeleteRecorded(ctx context.Context, all []v1alpha1.ObjectMeta) error {
+	objs := make([]unstructured.Unstructured, 0, len(all))
+	for _, x := range all {
+		u := unstructured.Unstructured{}
+		u.SetAPIVersion(x.APIVersion)
+		u.SetKind(x.Kind)
+		u.SetName(x.Metadata.Name)
+		u.SetNamespace(x.Metadata.Namespace)
+		objs = append(objs, u)
+	}
+
+	return r.DeleteAll(ctx, objs)
+}
+
+// Plan lists the objects to create, and the existing ones the
+// server-side dry-run apply changes. The objects to create are not
+// validated since they may depend on the ones before them.
+func (r *Objects) Plan(ctx context.Context, resource string, all []unstructured.Unstructured) (*v1alpha1.PlannedChange, error) {
+	sortByKind(all, releaseutil.InstallOrder)
+
+	change := &v1alpha1.PlannedChange{
+		Action:   "none",
+		Resource: resource,
+	}
+
+	for _, x := range all {
+		gvk := x.GroupVersionKind()
+		name := ResourceName(x.GetKind(), x.GetNamespace(), x.GetName())
+
+		cur, err := r.Get(ctx, getter.GetOptions{GVK: gvk, Namespace: x.GetNamespace(), Name: x.GetName()})
+		if apierrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+			change.Changes = append(change.Changes, "+ "+name)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		got, err := r.Apply(ctx, x.Object, applier.ApplyOptions{
+			GVK:       gvk,
+			Namespace: x.GetNamespace(),
+			Name:      x.GetName(),
+			DryRun:    true,
+		})
+		if err != nil {
+			change.Error = fmt.Sprintf("%s: %s", name, err.Error())
+			continue
+		}
+
+		diff := DiffPaths(WithoutServerFields(cur.Object), WithoutServerFields(got.Object))
+		if len(diff) > 0 {
+			change.Changes = append(change.Changes, "~ "+name)
+		}
+	}
+
+	if len(change.Changes) > 0 {
+		change.Action = "apply"
+	}
+
+	return change, nil
+}
+
+// DecodeObjects returns the objects of a multi-document YAML, the
+// namespace defaults to ns.
+func DecodeObjects(dat []byte, ns string) ([]unstructured.Unstructured, error) {
+	all := []unstructured.Unstructured{}
+	dec := utilyaml.NewYAMLOrJSONDecoder(bytes.NewReader(dat), 4096)
+	for {
+		obj := map[string]any{}
+		err := dec.Decode(&obj)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, Permanent(err)
+		}
+		if len(obj) == 0 {
+			continue
+		}
+
+		u := unstructured.Unstructured{Object: obj}
+		if len(u.GetAPIVersion()) == 0 || len(u.GetKind()) == 0 || len(u.GetName()) == 0 {
+			return nil, Permanent(fmt.Errorf("document %d: apiVersion, kind and metadata.name are required", len(all)+1))
+		}
+		if _, err := schema.ParseGroupVersion(u.GetAPIVersion()); err != nil {
+			return nil, Permanent(err)
+		}
+		if len(u.GetNamespace()) == 0 {
+			u.SetNamespace(ns)
+		}
+
+		all = append(all, u)
+	}
+
+	if len(all) == 0 {
+		return nil, Permanent(fmt.Errorf("no objects found"))
+	}
+
+	return all, nil
+}
+
+// sortByKind sorts the objects by kind as helm does, keeping
+// the original order for the same kind. Unknown kinds come last.
+func sortByKind(all []unstructured.Unstructured, order releaseutil.KindSortOrder) {
+	rank := func(kind string) int {
+		if i := slices.Index(order, kind); i >= 0 {
+			return i
+		}
+		return len(order)
+	}
+
+	slices.SortStableFunc(all, func(a, b unstructured.Unstructured) int {
+		return rank(a.GetKind()) - rank(b.GetKind())
+	})
+}
@@ -0,0 +1,125 @@
+package steps
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/krateoplatformops/installer/pkg/dynamic/applier"
+	"github.com/krateoplatformops/installer/pkg/dynamic/getter"
+	"github.com/krateoplatformops/provider-runtime/pkg/logging"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const crdManifest = `
+apiVersion: example.org/v1
+kind: Widget
+metadata:
+  name: default
+---
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: widgets.example.org
+spec:
+  group: example.org
+  names:
+    kind: Widget
+    plural: widgets
+  scope: Namespaced
+`
+
+func TestApplyAllEstablishesCRDs(t *testing.T) {
+	defer func(d time.Duration) { crdInterval = d }(crdInterval)
+	crdInterval = time.Millisecond
+
+	all, err := DecodeObjects([]byte(crdManifest), "demo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the CRD is established at the third check, its kind is
+	// discovered only after a reset following the CRD apply
+	calls, checks := []string{}, 0
+	crdApplied, discovered := false, false
+	objs := Objects{
+		Apply: func(_ context.Context, content map[string]any, opts applier.ApplyOptions) (*unstructured.Unstructured, error) {
+			if opts.GVK.Kind == "Widget" && !discovered {
+				calls = append(calls, "no match "+opts.Name)
+				return nil, &meta.NoKindMatchError{GroupKind: opts.GVK.GroupKind(), SearchedVersions: []string{opts.GVK.Version}}
+			}
+			crdApplied = crdApplied || opts.GVK.Kind == "CustomResourceDefinition"
+			calls = append(calls, "apply "+opts.Name)
+			return &unstructured.Unstructured{Object: content}, nil
+		},
+		Get: func(_ context.Context, opts getter.GetOptions) (*unstructured.Unstructured, error) {
+			if !crdApplied {
+				return nil, apierrors.NewNotFound(schema.GroupResource{Resource: "customresourcedefinitions"}, opts.Name)
+			}
+			checks++
+			status := "False"
+			if checks >= 3 {
+				status = "True"
+			}
+			return &unstructured.Unstructured{Object: map[string]any{
+				"status": map[string]any{"conditions": []any{
+					map[string]any{"type": "Established", "status": status},
+				}},
+			}}, nil
+		},
+		Reset: func() {
+			calls = append(calls, "reset")
+			discovered = crdApplied && checks >= 3
+		},
+		Log: logging.NewNopLogger(),
+	}
+
+	applied, err := objs.ApplyAll(context.Background(), all)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []string{"apply widgets.example.org", "reset", "apply default"}
+	if !slices.Equal(calls, exp) {
+		t.Fatalf("got calls %v, expected %v", calls, exp)
+	}
+	if checks != 3 || len(applied) != 2 {
+		t.Fatalf("unexpected %d checks, applied %+v", checks, applied)
+	}
+}
+
+func TestApplyAllResetsOnNoMatch(t *testing.T) {
+	all, err := DecodeObjects([]byte(`{"apiVersion": "example.org/v1", "kind": "Widget", "metadata": {"name": "default"}}`), "demo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resets := 0
+	objs := Objects{
+		Apply: func(_ context.Context, content map[string]any, opts applier.ApplyOptions) (*unstructured.Unstructured, error) {
+			if resets < 2 {
+				return nil, &meta.NoKindMatchError{GroupKind: opts.GVK.GroupKind(), SearchedVersions: []string{opts.GVK.Version}}
+			}
+			return &unstructured.Unstructured{Object: content}, nil
+		},
+		Reset: func() { resets++ },
+		Log:   logging.NewNopLogger(),
+	}
+
+	// the kind is still unknown after a reset, the step is retried later
+	_, err = objs.ApplyAll(context.Background(), all)
+	if !meta.IsNoMatchError(err) || IsPermanent(err) {
+		t.Fatalf("expected a transient no match error, got: %v", err)
+	}
+
+	if _, err := objs.ApplyAll(context.Background(), all); err != nil {
+		t.Fatal(err)
+	}
+	if resets != 2 {
+		t.Fatalf("expected 2 resets, got %d", resets)
+	}
+}
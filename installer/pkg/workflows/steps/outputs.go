@@ -0,0 +1,83 @@
+package steps
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/itchyny/gojq"
+	"github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+	"github.com/krateoplatformops/installer/pkg/cache"
+	"github.com/krateoplatformops/installer/pkg/dynamic"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// MaxOutputBytes bounds the values of the outputs, since they are
+// recorded in the status: a selector returning i.e. the whole manifest
+// of a release fails the step.
+const MaxOutputBytes = 4096
+
+// SetOutputs evaluates the selectors of the outputs against src and
+// stores the values in the workflow env. It returns the values set.
+func SetOutputs(ctx context.Context, env *cache.Cache[string, string], outputs []*v1alpha1.Output, src map[string]any) ([]Output, error) {
+	if len(outputs) == 0 {
+		return nil, nil
+	}
+
+	obj := &unstructured.Unstructured{Object: src}
+
+	res := make([]Output, 0, len(outputs))
+	for _, x := range outputs {
+		if _, err := gojq.Parse(x.Selector); err != nil {
+			return res, Permanent(fmt.Errorf("output %s: invalid selector %q: %w", x.Name, x.Selector, err))
+		}
+
+		val, err := dynamic.Extract(ctx, obj, x.Selector)
+		if err != nil {
+			return res, fmt.Errorf("output %s: %w", x.Name, err)
+		}
+		if val == nil {
+			return res, fmt.Errorf("output %s: selector %q selected nothing", x.Name, x.Selector)
+		}
+
+		str, err := outputValue(val)
+		if err != nil {
+			return res, fmt.Errorf("output %s: %w", x.Name, err)
+		}
+		if len(str) > MaxOutputBytes {
+			return res, Permanent(fmt.Errorf("output %s: selector %q selected %d bytes, more than the %d allowed",
+				x.Name, x.Selector, len(str), MaxOutputBytes))
+		}
+
+		env.Set(x.Name, str)
+		res = append(res, Output{
+			Data:      v1alpha1.Data{Name: x.Name, Value: str},
+			Sensitive: x.Sensitive,
+		})
+	}
+
+	return res, nil
+}
+
+// outputValue stores scalars as strings, objects and arrays as JSON.
+func outputValue(val any) (string, error) {
+	switch v := val.(type) {
+	case map[string]any, []any:
+		dat, err := json.Marshal(v)
+		return string(dat), err
+	case float64:
+		// selectors results are decoded from JSON, avoid the exponent notation
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	}
+	return Strval(val), nil
+}
+
+// OutputsOf returns the outputs declared by a chart or object step.
+func OutputsOf(in []byte) ([]*v1alpha1.Output, error) {
+	res := struct {
+		Outputs []*v1alpha1.Output `json:"outputs,omitempty"`
+	}{}
+	err := json.Unmarshal(in, &res)
+	return res.Outputs, err
+}
@@ -0,0 +1,99 @@
+package steps
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+	"github.com/krateoplatformops/installer/pkg/cache"
+)
+
+func TestSetOutputs(t *testing.T) {
+	src := map[string]any{
+		"metadata": map[string]any{"name": "krateo", "uid": "1234"},
+		"spec": map[string]any{
+			"replicas": int64(3),
+			"memory":   int64(2147483648),
+			"ports":    []any{map[string]any{"port": int64(8080)}},
+		},
+	}
+
+	env := cache.New[string, string]()
+	got, err := SetOutputs(context.Background(), env, []*v1alpha1.Output{
+		{Name: "uid", Selector: ".metadata.uid"},
+		{Name: "replicas", Selector: ".spec.replicas"},
+		{Name: "memory", Selector: ".spec.memory"},
+		{Name: "ports", Selector: "[.spec.ports[].port]"},
+	}, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := map[string]string{"uid": "1234", "replicas": "3", "memory": "2147483648", "ports": "[8080]"}
+	if len(got) != len(exp) {
+		t.Fatalf("unexpected outputs: %v", got)
+	}
+	for k, v := range exp {
+		if val, _ := env.Get(k); val != v {
+			t.Errorf("output %s: got %q, expected %q", k, val, v)
+		}
+	}
+}
+
+func TestSetOutputsErrors(t *testing.T) {
+	src := map[string]any{
+		"metadata": map[string]any{"name": "krateo"},
+		"manifest": strings.Repeat("x", MaxOutputBytes+1),
+	}
+
+	tests := []struct {
+		selector  string
+		err       string
+		permanent bool
+	}{
+		{selector: ".metadata.", err: "invalid selector", permanent: true},
+		{selector: ".status.ready", err: "selected nothing"},
+		{selector: ".manifest", err: "more than the 4096 allowed", permanent: true},
+	}
+
+	for _, tc := range tests {
+		_, err := SetOutputs(context.Background(), cache.New[string, string](), []*v1alpha1.Output{
+			{Name: "x", Selector: tc.selector},
+		}, src)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Fatalf("selector %s: expected error containing %q, got: %v", tc.selector, tc.err, err)
+		}
+		if IsPermanent(err) != tc.permanent {
+			t.Fatalf("selector %s: got permanent %v, expected %v", tc.selector, IsPermanent(err), tc.permanent)
+		}
+	}
+}
+
+func TestSensitiveOutputs(t *testing.T) {
+	src := map[string]any{"token": "s3cr3t", "id": "42"}
+
+	env := cache.New[string, string]()
+	got, err := SetOutputs(context.Background(), env, []*v1alpha1.Output{
+		{Name: "TOKEN", Selector: ".token", Sensitive: true},
+		{Name: "ID", Selector: ".id"},
+	}, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val, _ := env.Get("TOKEN"); val != "s3cr3t" {
+		t.Fatalf("expected the sensitive value in the env, got %q", val)
+	}
+
+	status := &v1alpha1.WorkflowStatus{}
+	(&HTTPResult{Outputs: got}).PopulateStatus(status, "register")
+
+	exp := []v1alpha1.VarStatus{
+		{Var: v1alpha1.Var{Data: v1alpha1.Data{Name: "TOKEN"}}, Step: "register", Sensitive: true},
+		{Var: v1alpha1.Var{Data: v1alpha1.Data{Name: "ID", Value: "42"}}, Step: "register"},
+	}
+	if !reflect.DeepEqual(status.VarList, exp) {
+		t.Fatalf("got var list %+v, expected %+v", status.VarList, exp)
+	}
+}
@@ -0,0 +1,135 @@
+package steps
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+	"github.com/krateoplatformops/installer/pkg/cache"
+	"github.com/krateoplatformops/installer/pkg/dynamic/applier"
+	"github.com/krateoplatformops/installer/pkg/dynamic/deletor"
+	"github.com/krateoplatformops/installer/pkg/dynamic/getter"
+	"github.com/krateoplatformops/installer/pkg/helmclient"
+	"github.com/krateoplatformops/installer/pkg/locker"
+	"github.com/krateoplatformops/provider-runtime/pkg/logging"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Result is the outcome of a step, as recorded in the workflow status.
+type Result interface {
+	// PopulateStatus records the result of the step with the given id.
+	PopulateStatus(status *v1alpha1.WorkflowStatus, step string)
+	// PlannedChange reports what the step would change, it is set in
+	// plan mode only.
+	PlannedChange() *v1alpha1.PlannedChange
+}
+
+// HandlerOptions are the dependencies available to the step handlers.
+type HandlerOptions struct {
+	// Env holds the workflow variables.
+	Env *cache.Cache[string, string]
+	// Inventory holds the objects recorded by the last run for each step,
+	// the steps applying a set of objects delete the recorded ones.
+	Inventory  *cache.Cache[string, []v1alpha1.ObjectMeta]
+	Log        logging.Logger
+	Getter     *getter.Getter
+	Applier    *applier.Applier
+	Deletor    *deletor.Deletor
+	HelmClient helmclient.Client
+	// Clientset is the typed client, for the APIs the dynamic
+	// getter can't reach (i.e. the pod logs).
+	Clientset kubernetes.Interface
+	// Locker acquires the ownership of the releases and objects changed
+	// by the steps, it can be nil.
+	Locker *locker.Locker
+}
+
+// Factory creates the handler of the steps of a type.
+type Factory func(opts HandlerOptions) Handler[Result]
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[v1alpha1.StepType]Factory{}
+)
+
+// Register makes a step type available to the workflows, it is meant to
+// be called from the init function of the package implementing the
+// handler. It panics if the type is already registered.
+func Register(t v1alpha1.StepType, f Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if f == nil {
+		panic(fmt.Sprintf("steps: nil factory for step type %q", t))
+	}
+	if _, ok := registry[t]; ok {
+		panic(fmt.Sprintf("steps: step type %q registered twice", t))
+	}
+	registry[t] = f
+}
+
+// Types returns the registered step types, sorted.
+func Types() []v1alpha1.StepType {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	res := make([]v1alpha1.StepType, 0, len(registry))
+	for t := range registry {
+		res = append(res, t)
+	}
+	slices.Sort(res)
+	return res
+}
+
+// NewHandlers creates the handlers of all the registered step types.
+func NewHandlers(opts HandlerOptions) map[v1alpha1.StepType]Handler[Result] {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	res := make(map[v1alpha1.StepType]Handler[Result], len(registry))
+	for t, f := range registry {
+		res[t] = f(opts)
+	}
+	return res
+}
+
+// Adapt returns a handler with the common result type, the optional
+// interfaces of h (Rollbacker, Planner) are reachable via Unwrap.
+func Adapt[T interface {
+	comparable
+	Result
+}](h Handler[T]) Handler[Result] {
+	return &adapted[T]{h}
+}
+
+type adapted[T interface {
+	comparable
+	Result
+}] struct {
+	Handler[T]
+}
+
+func (h *adapted[T]) Handle(ctx context.Context, id string, in *runtime.RawExtension) (Result, error) {
+	res, err := h.Handler.Handle(ctx, id, in)
+
+	var zero T
+	if res == zero {
+		return nil, err
+	}
+	return res, err
+}
+
+func (h *adapted[T]) Unwrap() any {
+	return h.Handler
+}
+
+// Unwrap returns the handler adapted by Adapt, h itself otherwise.
+func Unwrap(h Handler[Result]) any {
+	if u, ok := h.(interface{ Unwrap() any }); ok {
+		return u.Unwrap()
+	}
+	return h
+}
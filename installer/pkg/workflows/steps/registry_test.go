@@ -0,0 +1,85 @@
+package steps
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+type echoHandler struct {
+	ns  string
+	nil bool
+}
+
+func (h *echoHandler) Namespace(ns string) { h.ns = ns }
+
+func (h *echoHandler) Op(Op) {}
+
+func (h *echoHandler) DryRun(bool) {}
+
+func (h *echoHandler) Handle(_ context.Context, id string, _ *runtime.RawExtension) (*VarResult, error) {
+	if h.nil {
+		return nil, nil
+	}
+	return &VarResult{Name: id, Value: h.ns}, nil
+}
+
+func TestRegister(t *testing.T) {
+	const typ v1alpha1.StepType = "echo"
+
+	Register(typ, func(HandlerOptions) Handler[Result] {
+		return Adapt[*VarResult](&echoHandler{})
+	})
+	defer func() {
+		registryMu.Lock()
+		delete(registry, typ)
+		registryMu.Unlock()
+	}()
+
+	if !slices.Contains(Types(), typ) {
+		t.Fatalf("expected %q in %v", typ, Types())
+	}
+
+	hdl, ok := NewHandlers(HandlerOptions{})[typ]
+	if !ok {
+		t.Fatalf("handler for %q not created", typ)
+	}
+	hdl.Namespace("krateo-system")
+
+	res, err := hdl.Handle(context.Background(), "hello", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status := &v1alpha1.WorkflowStatus{}
+	res.PopulateStatus(status, "hello")
+	if len(status.VarList) != 1 || status.VarList[0].Value != "krateo-system" || status.VarList[0].Step != "hello" {
+		t.Fatalf("unexpected status: %+v", status.VarList)
+	}
+
+	if _, ok := Unwrap(hdl).(Planner); !ok {
+		t.Fatal("expected the adapted handler to be reachable via Unwrap")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected a panic registering the same type twice")
+		}
+	}()
+	Register(typ, func(HandlerOptions) Handler[Result] { return nil })
+}
+
+func TestAdaptNilResult(t *testing.T) {
+	hdl := Adapt[*VarResult](&echoHandler{nil: true})
+
+	res, err := hdl.Handle(context.Background(), "x", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != nil {
+		t.Fatalf("expected a nil result, got: %#v", res)
+	}
+}
@@ -0,0 +1,37 @@
+package steps
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+type Op int
+
+const (
+	Create Op = iota + 1
+	Update
+	Delete
+)
+
+type Handler[T any] interface {
+	Namespace(ns string)
+	Op(op Op)
+	Handle(ctx context.Context, id string, in *runtime.RawExtension) (T, error)
+}
+
+// Rollback restores the state changed by a step.
+type Rollback func(ctx context.Context) error
+
+// Rollbacker is implemented by the handlers whose steps can be rolled back.
+type Rollbacker interface {
+	// Prepare captures the state the step is about to change
+	// and returns the function that restores it.
+	Prepare(ctx context.Context, id string, in *runtime.RawExtension) (Rollback, error)
+}
+
+// Planner is implemented by the handlers able to report what a step
+// would change without changing anything.
+type Planner interface {
+	DryRun(on bool)
+}
@@ -4,8 +4,25 @@ import (
 	"fmt"
 	"path"
 	"strings"
+
+	"github.com/krateoplatformops/installer/internal/expand"
+	"github.com/krateoplatformops/installer/pkg/cache"
 )
 
+// Subst returns the lookup of expand.Known resolving the workflow variables.
+func Subst(env *cache.Cache[string, string]) func(string) (string, bool) {
+	return env.Get
+}
+
+// SubstJSON is Subst for a raw JSON document, i.e. the with block of a
+// step: the values are escaped to be embedded in a JSON string.
+func SubstJSON(env *cache.Cache[string, string]) func(string) (string, bool) {
+	return func(k string) (string, bool) {
+		v, ok := env.Get(k)
+		return expand.JSONEscape(v), ok
+	}
+}
+
 func Strval(v any) string {
 	switch v := v.(type) {
 	case string:
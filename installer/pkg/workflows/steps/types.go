@@ -0,0 +1,294 @@
+package steps
+
+import (
+	"github.com/krateoplatformops/installer/apis/workflows/v1alpha1"
+	rtv1 "github.com/krateoplatformops/provider-runtime/apis/common/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Add these result types to the existing file
+
+var (
+	_ Result = (*VarResult)(nil)
+	_ Result = (*ObjectResult)(nil)
+	_ Result = (*ChartResult)(nil)
+	_ Result = (*WaitResult)(nil)
+	_ Result = (*JobResult)(nil)
+	_ Result = (*PatchResult)(nil)
+	_ Result = (*ManifestResult)(nil)
+	_ Result = (*HTTPResult)(nil)
+	_ Result = (*GenerateResult)(nil)
+)
+
+type VarResult struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type ObjectResult struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	Namespace  string `json:"namespace"`
+	Operation  string `json:"operation"`
+	// Plan is set in plan mode.
+	Plan *v1alpha1.PlannedChange `json:"plan,omitempty"`
+	// Outputs are the variables set by the step.
+	Outputs []Output `json:"outputs,omitempty"`
+}
+
+type ChartResult struct {
+	ReleaseName  string      `json:"releaseName"`
+	ChartName    string      `json:"chartName"`
+	ChartVersion string      `json:"version"`
+	AppVersion   string      `json:"appVersion"`
+	Namespace    string      `json:"namespace"`
+	Status       string      `json:"status"`
+	Operation    string      `json:"operation"`
+	Revision     int         `json:"revision,omitempty"`
+	Updated      metav1.Time `json:"updated,omitempty"`
+	// Adopted lists the existing resources the release took over.
+	Adopted []string `json:"adopted,omitempty"`
+	// Refused lists the existing resources the release didn't take over,
+	// owned by another release or KrateoPlatformOps, with the reason.
+	Refused []string `json:"refused,omitempty"`
+	// Plan is set in plan mode.
+	Plan *v1alpha1.PlannedChange `json:"plan,omitempty"`
+	// Outputs are the variables set by the step.
+	Outputs []Output `json:"outputs,omitempty"`
+}
+
+// WaitResult reports the object a wait step waited for and
+// the value of its condition when the wait ended.
+type WaitResult struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	Namespace  string `json:"namespace"`
+	Value      string `json:"value"`
+}
+
+// JobResult reports the Job run by a job step.
+type JobResult struct {
+	Name           string       `json:"name"`
+	Namespace      string       `json:"namespace"`
+	Operation      string       `json:"operation"`
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	Logs           string       `json:"logs,omitempty"`
+	// Kept is true if the Job has not been deleted once completed.
+	Kept bool `json:"kept,omitempty"`
+	// Plan is set in plan mode.
+	Plan *v1alpha1.PlannedChange `json:"plan,omitempty"`
+	// Outputs are the variables set by the step.
+	Outputs []Output `json:"outputs,omitempty"`
+}
+
+// PatchResult reports the objects patched by a patch step.
+type PatchResult struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	// Patched lists the objects patched, as namespace/name or name.
+	Patched []string `json:"patched,omitempty"`
+	// Plan is set in plan mode.
+	Plan *v1alpha1.PlannedChange `json:"plan,omitempty"`
+}
+
+// ManifestResult reports the objects applied by a manifest or a kustomize step.
+type ManifestResult struct {
+	Operation string                `json:"operation"`
+	Objects   []v1alpha1.ObjectMeta `json:"objects,omitempty"`
+	// Plan is set in plan mode.
+	Plan *v1alpha1.PlannedChange `json:"plan,omitempty"`
+}
+
+// HTTPResult reports the request made by an http step.
+type HTTPResult struct {
+	Method     string `json:"method"`
+	URL        string `json:"url"`
+	StatusCode int    `json:"statusCode,omitempty"`
+	// Plan is set in plan mode.
+	Plan *v1alpha1.PlannedChange `json:"plan,omitempty"`
+	// Outputs are the variables set by the step.
+	Outputs []Output `json:"outputs,omitempty"`
+}
+
+// GenerateResult reports the Secret of a generate step.
+type GenerateResult struct {
+	Name        string       `json:"name"`
+	Namespace   string       `json:"namespace"`
+	Operation   string       `json:"operation"`
+	GeneratedAt metav1.Time  `json:"generatedAt,omitempty"`
+	RotateAt    *metav1.Time `json:"rotateAt,omitempty"`
+	// Plan is set in plan mode.
+	Plan *v1alpha1.PlannedChange `json:"plan,omitempty"`
+	// Outputs are the variables set by the step.
+	Outputs []Output `json:"outputs,omitempty"`
+}
+
+func (r *VarResult) PopulateStatus(status *v1alpha1.WorkflowStatus, step string) {
+	status.VarList = append(status.VarList, v1alpha1.VarStatus{
+		Var: v1alpha1.Var{
+			Data: v1alpha1.Data{
+				Name:  r.Name,
+				Value: r.Value,
+			},
+		},
+		Step: step,
+	})
+}
+
+func (r *VarResult) PlannedChange() *v1alpha1.PlannedChange {
+	return nil
+}
+
+func (r *ObjectResult) PopulateStatus(status *v1alpha1.WorkflowStatus, step string) {
+	status.ObjectList = append(status.ObjectList, v1alpha1.ObjectStatus{
+		ObjectMeta: v1alpha1.ObjectMeta{
+			APIVersion: r.APIVersion,
+			Kind:       r.Kind,
+			Metadata: rtv1.Reference{
+				Name:      r.Name,
+				Namespace: r.Namespace,
+			},
+		},
+		Step: step,
+	})
+	populateOutputs(status, step, r.Outputs)
+}
+
+func (r *ObjectResult) PlannedChange() *v1alpha1.PlannedChange {
+	return r.Plan
+}
+
+func (r *ChartResult) PopulateStatus(status *v1alpha1.WorkflowStatus, step string) {
+	status.ReleaseList = append(status.ReleaseList, v1alpha1.Release{
+		Step:         step,
+		ReleaseName:  r.ReleaseName,
+		ChartName:    r.ChartName,
+		ChartVersion: r.ChartVersion,
+		AppVersion:   r.AppVersion,
+		Namespace:    r.Namespace,
+		Status:       r.Status,
+		Revision:     r.Revision,
+		Updated:      r.Updated,
+		Adopted:      r.Adopted,
+	})
+	populateOutputs(status, step, r.Outputs)
+}
+
+func (r *ChartResult) PlannedChange() *v1alpha1.PlannedChange {
+	return r.Plan
+}
+
+// PopulateStatus records nothing: the object waited for
+// is not managed by the workflow.
+func (r *WaitResult) PopulateStatus(*v1alpha1.WorkflowStatus, string) {}
+
+func (r *WaitResult) PlannedChange() *v1alpha1.PlannedChange {
+	return nil
+}
+
+// PopulateStatus records the Job, and the Job object if it has been
+// kept so that it is pruned with the step.
+func (r *JobResult) PopulateStatus(status *v1alpha1.WorkflowStatus, step string) {
+	status.JobList = append(status.JobList, v1alpha1.JobStatus{
+		Step:           step,
+		Name:           r.Name,
+		Namespace:      r.Namespace,
+		CompletionTime: r.CompletionTime,
+		Logs:           r.Logs,
+	})
+	if r.Kept {
+		status.ObjectList = append(status.ObjectList, v1alpha1.ObjectStatus{
+			ObjectMeta: v1alpha1.ObjectMeta{
+				APIVersion: "batch/v1",
+				Kind:       "Job",
+				Metadata: rtv1.Reference{
+					Name:      r.Name,
+					Namespace: r.Namespace,
+				},
+			},
+			Step: step,
+		})
+	}
+	populateOutputs(status, step, r.Outputs)
+}
+
+func (r *JobResult) PlannedChange() *v1alpha1.PlannedChange {
+	return r.Plan
+}
+
+// PopulateStatus records nothing: the objects patched are not managed
+// by the workflow, they are neither pruned nor deleted with it.
+func (r *PatchResult) PopulateStatus(*v1alpha1.WorkflowStatus, string) {}
+
+func (r *PatchResult) PlannedChange() *v1alpha1.PlannedChange {
+	return r.Plan
+}
+
+// PopulateStatus records the applied objects, so that the ones
+// removed from the manifest or the kustomization are pruned.
+func (r *ManifestResult) PopulateStatus(status *v1alpha1.WorkflowStatus, step string) {
+	for _, x := range r.Objects {
+		status.ObjectList = append(status.ObjectList, v1alpha1.ObjectStatus{
+			ObjectMeta: x,
+			Step:       step,
+		})
+	}
+}
+
+func (r *ManifestResult) PlannedChange() *v1alpha1.PlannedChange {
+	return r.Plan
+}
+
+// PopulateStatus records the outputs only: the request
+// is not undone when the workflow is deleted.
+func (r *HTTPResult) PopulateStatus(status *v1alpha1.WorkflowStatus, step string) {
+	populateOutputs(status, step, r.Outputs)
+}
+
+func (r *HTTPResult) PlannedChange() *v1alpha1.PlannedChange {
+	return r.Plan
+}
+
+// PopulateStatus records the Secret with its next rotation. It is not
+// recorded with the objects: the Secret is never pruned, its values
+// can't be generated again.
+func (r *GenerateResult) PopulateStatus(status *v1alpha1.WorkflowStatus, step string) {
+	status.SecretList = append(status.SecretList, v1alpha1.GeneratedSecretStatus{
+		Step:        step,
+		Name:        r.Name,
+		Namespace:   r.Namespace,
+		GeneratedAt: r.GeneratedAt,
+		RotateAt:    r.RotateAt,
+	})
+	populateOutputs(status, step, r.Outputs)
+}
+
+func (r *GenerateResult) PlannedChange() *v1alpha1.PlannedChange {
+	return r.Plan
+}
+
+// Output is a variable set by a step.
+type Output struct {
+	v1alpha1.Data `json:",inline"`
+	// Sensitive outputs are recorded in the status without their value.
+	Sensitive bool `json:"sensitive,omitempty"`
+}
+
+// populateOutputs records the outputs of a step with the variables, so
+// that they are available on delete as well. The values of the sensitive
+// ones are left to the caller to store.
+func populateOutputs(status *v1alpha1.WorkflowStatus, step string, all []Output) {
+	for _, x := range all {
+		data := x.Data
+		if x.Sensitive {
+			data = v1alpha1.Data{Name: x.Name}
+		}
+		status.VarList = append(status.VarList, v1alpha1.VarStatus{
+			Var:       v1alpha1.Var{Data: data},
+			Step:      step,
+			Sensitive: x.Sensitive,
+		})
+	}
+}